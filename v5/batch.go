@@ -0,0 +1,171 @@
+package jsonpatch
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// BatchOptions specifies options for calls to ApplyBatch and ApplyBatchFunc.
+// Use NewBatchOptions to obtain default values for BatchOptions.
+type BatchOptions struct {
+	// ApplyOptions are the options the patch is applied with.
+	ApplyOptions *ApplyOptions
+	// MaxFailures aborts the batch once as many documents failed.
+	// Default to 0, which means no limit.
+	MaxFailures int
+}
+
+// NewBatchOptions creates a default set of options for calls to ApplyBatch.
+func NewBatchOptions() *BatchOptions {
+	return &BatchOptions{
+		ApplyOptions: NewApplyOptions(),
+	}
+}
+
+// BatchResult is the outcome of applying a patch to one document of a batch.
+type BatchResult struct {
+	// Index is the position of the document in the batch.
+	Index int
+	// Doc is the patched document, unless Err is set.
+	Doc []byte
+	// Err is the error applying the patch to the document.
+	Err error
+}
+
+// ApplyBatch applies the patch to each of docs, using up to workers
+// goroutines, or one per CPU when workers is not positive. It returns the
+// results in the order of docs.
+//
+// The patch is checked once for the whole batch. When the batch is aborted
+// after BatchOptions.MaxFailures failures, the results of the documents up to
+// the last failure are returned along with a *BatchFailuresError.
+func ApplyBatch(p Patch, docs [][]byte, workers int, options *BatchOptions) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, len(docs))
+
+	i := 0
+	next := func() ([]byte, error) {
+		if i == len(docs) {
+			return nil, io.EOF
+		}
+		i++
+		return docs[i-1], nil
+	}
+
+	err := ApplyBatchFunc(p, next, workers, options, func(r BatchResult) error {
+		results = append(results, r)
+		return nil
+	})
+
+	return results, err
+}
+
+// ApplyBatchFunc is like ApplyBatch for documents read one at a time, such as
+// the lines of an NDJSON stream. next returns the next document, or io.EOF
+// after the last one, and must not change a document it returned. Results
+// are passed to emit in the order of the documents, as soon as the documents
+// before them are done, so that only a few documents are held at a time.
+//
+// Both next and emit are called from the calling goroutine. An error they
+// return, other than io.EOF from next, aborts the batch and is returned once
+// the documents read so far were emitted, or at once for emit.
+func ApplyBatchFunc(p Patch, next func() ([]byte, error), workers int, options *BatchOptions, emit func(BatchResult) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	o := *options.ApplyOptions
+	if err := validatePatch(p, &o); err != nil {
+		return err
+	}
+	if err := checkPatchLimits(p, &o); err != nil {
+		return err
+	}
+	o.patchChecked = true
+
+	// At most window documents are read and not yet emitted.
+	window := 4 * workers
+
+	type job struct {
+		index int
+		doc   []byte
+	}
+
+	jobs := make(chan job, window)
+	done := make(chan struct{})
+	results := make(chan BatchResult, window)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				select {
+				case <-done:
+					continue
+				default:
+				}
+
+				doc, err := p.ApplyWithOptions(j.doc, &o)
+				results <- BatchResult{Index: j.index, Doc: doc, Err: err}
+			}
+		}()
+	}
+
+	defer func() {
+		close(done)
+		close(jobs)
+		wg.Wait()
+	}()
+
+	var readErr error
+	eof := false
+	read, emitted, failures := 0, 0, 0
+	pending := map[int]BatchResult{}
+
+	for {
+		for !eof && read-emitted < window {
+			doc, err := next()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					readErr = err
+				}
+				eof = true
+				break
+			}
+
+			jobs <- job{index: read, doc: doc}
+			read++
+		}
+
+		if emitted == read {
+			return readErr
+		}
+
+		r := <-results
+		pending[r.Index] = r
+
+		for {
+			r, ok := pending[emitted]
+			if !ok {
+				break
+			}
+
+			delete(pending, emitted)
+			emitted++
+
+			if err := emit(r); err != nil {
+				return err
+			}
+
+			if r.Err != nil {
+				failures++
+				if options.MaxFailures > 0 && failures >= options.MaxFailures {
+					return NewBatchFailuresError(options.MaxFailures)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package jsonpatch
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func batchDocuments(n int) [][]byte {
+	docs := make([][]byte, n)
+	for i := range docs {
+		if i%10 == 3 {
+			docs[i] = []byte(fmt.Sprintf(`{"id": %d}`, i))
+			continue
+		}
+		docs[i] = []byte(fmt.Sprintf(`{"id": %d, "status": "active"}`, i))
+	}
+	return docs
+}
+
+func TestApplyBatch(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "replace", "path": "/status", "value": "archived"}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	docs := batchDocuments(100)
+
+	for _, workers := range []int{0, 1, 4, 32} {
+		t.Run(fmt.Sprintf("workers %d", workers), func(t *testing.T) {
+			results, err := ApplyBatch(p, docs, workers, NewBatchOptions())
+			if err != nil {
+				t.Fatalf("unable to apply batch: %s", err)
+			}
+
+			if len(results) != len(docs) {
+				t.Fatalf("expected %d results, got %d", len(docs), len(results))
+			}
+
+			for i, r := range results {
+				if r.Index != i {
+					t.Errorf("expected result %d, got %d", i, r.Index)
+				}
+
+				if i%10 == 3 {
+					if !errors.Is(r.Err, ErrMissing) {
+						t.Errorf("expected ErrMissing for document %d, got %v", i, r.Err)
+					}
+					continue
+				}
+
+				if r.Err != nil {
+					t.Errorf("unable to patch document %d: %s", i, r.Err)
+					continue
+				}
+
+				expected := fmt.Sprintf(`{"id": %d, "status": "archived"}`, i)
+				if !compareJSON(string(r.Doc), expected) {
+					t.Errorf("expected %s, got %s", expected, r.Doc)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyBatchMaxFailures(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "replace", "path": "/status", "value": "archived"}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	options := NewBatchOptions()
+	options.MaxFailures = 2
+
+	results, err := ApplyBatch(p, batchDocuments(100), 4, options)
+
+	var e *BatchFailuresError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected BatchFailuresError, got %v", err)
+	}
+
+	// The second failure is document 13.
+	if len(results) != 14 {
+		t.Fatalf("expected 14 results, got %d", len(results))
+	}
+
+	if results[13].Err == nil {
+		t.Errorf("expected the last result to have failed")
+	}
+}
+
+func TestApplyBatchInvalidPatch(t *testing.T) {
+	p := Patch{Operation{"op": rawString("bogus"), "path": rawString("/a")}}
+
+	calls := 0
+	next := func() ([]byte, error) {
+		calls++
+		return nil, io.EOF
+	}
+
+	err := ApplyBatchFunc(p, next, 2, NewBatchOptions(), func(BatchResult) error { return nil })
+	if err == nil {
+		t.Fatal("expected the patch to be rejected")
+	}
+
+	if calls != 0 {
+		t.Errorf("expected no document to be read, got %d", calls)
+	}
+
+	options := NewBatchOptions()
+	options.ApplyOptions.MaxOperations = 1
+
+	p, _ = DecodePatch([]byte(`[{"op": "add", "path": "/a", "value": 1}, {"op": "add", "path": "/b", "value": 2}]`))
+
+	var e *OperationCountError
+	if _, err := ApplyBatch(p, batchDocuments(3), 2, options); !errors.As(err, &e) {
+		t.Errorf("expected OperationCountError, got %v", err)
+	}
+}
+
+func TestApplyBatchFunc(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/seen", "value": true}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	var input strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&input, "{\"n\": %d}\n", i)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(input.String()))
+	next := func() ([]byte, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		// The scanner reuses its buffer.
+		return append([]byte(nil), scanner.Bytes()...), nil
+	}
+
+	n := 0
+	err = ApplyBatchFunc(p, next, 8, NewBatchOptions(), func(r BatchResult) error {
+		if r.Index != n {
+			t.Errorf("expected result %d, got %d", n, r.Index)
+		}
+
+		expected := fmt.Sprintf(`{"n": %d, "seen": true}`, n)
+		if r.Err != nil || !compareJSON(string(r.Doc), expected) {
+			t.Errorf("expected %s, got %s (%v)", expected, r.Doc, r.Err)
+		}
+
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to apply batch: %s", err)
+	}
+
+	if n != 500 {
+		t.Errorf("expected 500 results, got %d", n)
+	}
+}
+
+func TestApplyBatchFuncErrors(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/seen", "value": true}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	readErr := errors.New("read failed")
+
+	read := 0
+	next := func() ([]byte, error) {
+		if read == 10 {
+			return nil, readErr
+		}
+		read++
+		return []byte(`{}`), nil
+	}
+
+	emitted := 0
+	err = ApplyBatchFunc(p, next, 4, NewBatchOptions(), func(BatchResult) error {
+		emitted++
+		return nil
+	})
+
+	if !errors.Is(err, readErr) {
+		t.Errorf("expected the read error, got %v", err)
+	}
+
+	if emitted != 10 {
+		t.Errorf("expected the 10 documents read to be emitted, got %d", emitted)
+	}
+
+	emitErr := errors.New("emit failed")
+
+	read = 0
+	emitted = 0
+	err = ApplyBatchFunc(p, next, 4, NewBatchOptions(), func(BatchResult) error {
+		emitted++
+		if emitted == 3 {
+			return emitErr
+		}
+		return nil
+	})
+
+	if !errors.Is(err, emitErr) {
+		t.Errorf("expected the emit error, got %v", err)
+	}
+
+	if emitted != 3 {
+		t.Errorf("expected 3 documents to be emitted, got %d", emitted)
+	}
+}
@@ -1,6 +1,9 @@
 package jsonpatch
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func BenchmarkMergePatch(b *testing.B) {
 	original := []byte(`{"name": "John", "age": 24, "height": 3.21}`)
@@ -16,3 +19,65 @@ func BenchmarkMergePatch(b *testing.B) {
 		MergePatch(alternative, patch)
 	}
 }
+
+func benchmarkPatchDocument() ([]byte, Patch) {
+	doc := []byte(`{"items": [` + strings.Repeat(`{"name": "item", "tags": ["a", "b", "c"], "meta": {"size": 1, "color": "red"}},`, 200) + `{}], "count": 0}`)
+
+	patch, err := DecodePatch([]byte(`[
+		{"op": "copy", "from": "/items/0", "path": "/first"},
+		{"op": "replace", "path": "/count", "value": 201},
+		{"op": "test", "path": "/items/3/meta/color", "value": "red"}
+	]`))
+	if err != nil {
+		panic(err)
+	}
+
+	return doc, patch
+}
+
+func BenchmarkApply(b *testing.B) {
+	doc, patch := benchmarkPatchDocument()
+
+	for n := 0; n < b.N; n++ {
+		patch.Apply(doc)
+	}
+}
+
+func BenchmarkCanApply(b *testing.B) {
+	doc, patch := benchmarkPatchDocument()
+	options := NewApplyOptions()
+
+	for n := 0; n < b.N; n++ {
+		patch.CanApply(doc, options)
+	}
+}
+
+func benchmarkBatchDocuments() ([][]byte, Patch) {
+	doc, patch := benchmarkPatchDocument()
+
+	docs := make([][]byte, 100)
+	for i := range docs {
+		docs[i] = doc
+	}
+
+	return docs, patch
+}
+
+func BenchmarkApplyLoop(b *testing.B) {
+	docs, patch := benchmarkBatchDocuments()
+
+	for n := 0; n < b.N; n++ {
+		for _, doc := range docs {
+			patch.Apply(doc)
+		}
+	}
+}
+
+func BenchmarkApplyBatch(b *testing.B) {
+	docs, patch := benchmarkBatchDocuments()
+	options := NewBatchOptions()
+
+	for n := 0; n < b.N; n++ {
+		ApplyBatch(patch, docs, 0, options)
+	}
+}
@@ -0,0 +1,875 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// ErrIndeterminate is returned when the combined effect of operations depends
+// on a document that is not available, such as whether an index refers to an
+// element appended by an earlier operation.
+var ErrIndeterminate = errors.New("result depends on the document")
+
+// ComposePatches squashes a sequence of patches into a single patch that has
+// the same effect as applying each of them in order.
+//
+// Operations are folded into earlier ones wherever their combined effect can
+// be determined without the document: an add followed by a remove of the same
+// array element cancels out, repeated replaces collapse into the last one,
+// operations inside a value that was added earlier are applied to that value,
+// and array indices are adjusted for the insertions and removals of the
+// operations they are moved across. Operations that cannot be folded are kept
+// as they are. For instance, an add of an object member followed by its
+// removal is kept, since the add may have replaced an existing member.
+//
+// Numeric reference tokens are taken to be array indices, unless the patches
+// also address the same container by member name or set it to an object. They
+// are ambiguous when the container they address may differ from one operation
+// to the next, because an operation replaces it, or inserts or removes
+// elements of an array above it.
+//
+// The patches are assumed to apply cleanly in sequence. An error wrapping
+// ErrIndeterminate is returned when folding an operation would require
+// knowledge of the document, and other errors are returned when the sequence
+// can be shown to fail regardless of the document.
+func ComposePatches(patches ...Patch) (Patch, error) {
+	c := newComposer()
+
+	var ops []*composeOp
+
+	for _, p := range patches {
+		for _, op := range p {
+			cop, err := newComposeOp(op)
+			if err != nil {
+				return nil, err
+			}
+
+			c.observe(cop)
+			ops = append(ops, cop)
+		}
+	}
+
+	for _, op := range ops {
+		var err error
+
+		c.ops, err = c.fold(c.ops, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make(Patch, 0, len(c.ops))
+	for _, op := range c.ops {
+		out = append(out, op.operation())
+	}
+
+	return out, nil
+}
+
+// composeOp is the decoded form of an Operation used while composing and
+// transforming patches.
+type composeOp struct {
+	kind  string
+	path  []string
+	from  []string
+	value *json.RawMessage
+	op    Operation
+}
+
+func newComposeOp(op Operation) (*composeOp, error) {
+	if err := validateOperation(op, NewApplyOptions()); err != nil {
+		return nil, invalidOperationError(op, err)
+	}
+
+	kind := op.Kind()
+
+	path, _ := op.Path()
+
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, invalidOperationError(op, err)
+	}
+
+	c := &composeOp{kind: kind, path: tokens, op: op}
+
+	switch kind {
+	case "move", "copy":
+		from, _ := op.From()
+
+		c.from, err = splitPointer(from)
+		if err != nil {
+			return nil, invalidOperationError(op, err)
+		}
+	case "add", "replace", "test":
+		c.value = op["value"]
+		if c.value == nil {
+			c.value = newRawMessage(rawJSONNull)
+		}
+	}
+
+	return c, nil
+}
+
+func invalidOperationError(op Operation, err error) error {
+	opData, infoErr := json.Marshal(op)
+	if infoErr != nil {
+		return fmt.Errorf("invalid operation: %w", err)
+	}
+
+	return fmt.Errorf("invalid operation %s: %w", opData, err)
+}
+
+// pointers returns the path of c, followed by its from pointer if it has one.
+func (c *composeOp) pointers() [][]string {
+	if c.from != nil {
+		return [][]string{c.path, c.from}
+	}
+	return [][]string{c.path}
+}
+
+func (c *composeOp) clone() *composeOp {
+	n := *c
+	n.path = appendPointer(c.path)
+	if c.from != nil {
+		n.from = appendPointer(c.from)
+	}
+	return &n
+}
+
+// withKind returns a copy of c turned into an operation of the given kind,
+// keeping any additional members of the original operation.
+func (c *composeOp) withKind(kind string, value *json.RawMessage) *composeOp {
+	n := c.clone()
+	n.kind = kind
+	n.value = value
+	if kind != "move" && kind != "copy" {
+		n.from = nil
+	}
+	return n
+}
+
+func (c *composeOp) operation() Operation {
+	op := make(Operation, len(c.op))
+	for k, v := range c.op {
+		op[k] = v
+	}
+
+	op["op"] = rawString(c.kind)
+	op["path"] = rawString(joinPointer(c.path))
+
+	if c.from != nil {
+		op["from"] = rawString(joinPointer(c.from))
+	} else {
+		delete(op, "from")
+	}
+
+	if c.value != nil {
+		op["value"] = c.value
+	} else {
+		delete(op, "value")
+	}
+
+	return op
+}
+
+func rawString(s string) *json.RawMessage {
+	buf, _ := json.MarshalEscaped(s, false)
+	return newRawMessage(buf)
+}
+
+type containerKind int
+
+const (
+	kindUnknown containerKind = iota
+	kindArray
+	kindObject
+	kindConflict
+)
+
+type tokenClass int
+
+const (
+	// tokenMember names an object member.
+	tokenMember tokenClass = iota
+	// tokenIndex is an index into a container taken to be an array.
+	tokenIndex
+	// tokenAppend is the "-" token referring past the end of an array.
+	tokenAppend
+	// tokenNegative is a negative index counting from the end of an array.
+	tokenNegative
+	// tokenAmbiguous is numeric or "-", but the container is addressed both
+	// as an array and as an object, or may be a different value from one
+	// operation to the next.
+	tokenAmbiguous
+)
+
+type effectKind int
+
+const (
+	effectRead effectKind = iota
+	effectWrite
+	effectInsert
+	effectRemove
+)
+
+// effect describes how an operation touches the document at a pointer.
+// Operations such as move are made of several effects applied in order.
+type effect struct {
+	kind effectKind
+	ptr  []string
+}
+
+type composer struct {
+	ops []*composeOp
+
+	// kinds records whether the container at a pointer is known to be an
+	// array or an object, as evidenced by the operations being composed.
+	kinds map[string]containerKind
+
+	// replaced records the pointers whose value an operation sets or removes
+	// as a whole, and shifted those of the containers in which an operation
+	// inserts or removes a numeric token. Below them, a pointer may refer to
+	// different values from one operation to the next, and the evidence of
+	// kinds may have been gathered from different containers.
+	replaced map[string]bool
+	shifted  map[string]bool
+}
+
+func newComposer() *composer {
+	return &composer{
+		kinds:    map[string]containerKind{},
+		replaced: map[string]bool{},
+		shifted:  map[string]bool{},
+	}
+}
+
+func (c *composer) note(ptr []string, kind containerKind) {
+	key := joinPointer(ptr)
+
+	switch cur := c.kinds[key]; {
+	case cur == kindUnknown:
+		c.kinds[key] = kind
+	case cur != kind:
+		c.kinds[key] = kindConflict
+	}
+}
+
+// observe gathers evidence about container kinds from the pointers and values
+// of an operation.
+func (c *composer) observe(op *composeOp) {
+	for _, ptr := range [][]string{op.path, op.from} {
+		for i, tok := range ptr {
+			if tok == "-" {
+				c.note(ptr[:i], kindArray)
+			} else if _, ok := arrayIndex(tok); !ok && !isNegativeIndex(tok) {
+				c.note(ptr[:i], kindObject)
+			}
+		}
+	}
+
+	changed := [][]string{op.path}
+	if op.kind == "move" {
+		changed = append(changed, op.from)
+	}
+
+	for _, ptr := range changed {
+		if op.kind == "test" || len(ptr) == 0 {
+			continue
+		}
+
+		c.replaced[joinPointer(ptr)] = true
+
+		if tok := ptr[len(ptr)-1]; tok == "-" || isNegativeIndex(tok) {
+			c.shifted[joinPointer(ptr[:len(ptr)-1])] = true
+		} else if _, ok := arrayIndex(tok); ok {
+			c.shifted[joinPointer(ptr[:len(ptr)-1])] = true
+		}
+	}
+
+	if op.value != nil && (op.kind == "add" || op.kind == "replace") {
+		switch v := bytes.TrimSpace(*op.value); {
+		case len(v) == 0:
+		case v[0] == '[':
+			c.note(op.path, kindArray)
+		case v[0] == '{':
+			c.note(op.path, kindObject)
+		}
+	}
+}
+
+func (c *composer) kindOf(ptr []string) containerKind {
+	kind := c.kinds[joinPointer(ptr)]
+	if kind == kindConflict {
+		return kindUnknown
+	}
+	return kind
+}
+
+// classify determines what the last token of ptr refers to.
+func (c *composer) classify(ptr []string) tokenClass {
+	if len(ptr) == 0 {
+		return tokenMember
+	}
+
+	parent, tok := ptr[:len(ptr)-1], ptr[len(ptr)-1]
+
+	if _, ok := arrayIndex(tok); (ok || tok == "-" || isNegativeIndex(tok)) && c.unstable(parent) {
+		// The container may have been an array for some of the operations
+		// and an object for others.
+		return tokenAmbiguous
+	}
+
+	kind := c.kindOf(parent)
+	if kind == kindObject {
+		return tokenMember
+	}
+
+	if tok == "-" {
+		return tokenAppend
+	}
+
+	if _, ok := arrayIndex(tok); ok {
+		if c.kinds[joinPointer(parent)] == kindConflict {
+			return tokenAmbiguous
+		}
+		return tokenIndex
+	}
+
+	if isNegativeIndex(tok) {
+		return tokenNegative
+	}
+
+	return tokenMember
+}
+
+// unstable reports whether ptr may refer to different containers from one
+// operation to the next: an operation sets or removes the value at ptr or at
+// one of its ancestors, or shifts the elements of an array above it.
+func (c *composer) unstable(ptr []string) bool {
+	for i := 0; i <= len(ptr); i++ {
+		key := joinPointer(ptr[:i])
+		if c.replaced[key] || (i < len(ptr) && c.shifted[key]) {
+			return true
+		}
+	}
+	return false
+}
+
+// placement is the effect of putting a value at ptr: an insertion if ptr
+// refers to a position in an array, a write otherwise.
+func (c *composer) placement(ptr []string) effect {
+	if c.classify(ptr) == tokenMember {
+		return effect{kind: effectWrite, ptr: ptr}
+	}
+	return effect{kind: effectInsert, ptr: ptr}
+}
+
+func (c *composer) effects(op *composeOp) []effect {
+	switch op.kind {
+	case "test":
+		return []effect{{kind: effectRead, ptr: op.path}}
+	case "replace":
+		return []effect{{kind: effectWrite, ptr: op.path}}
+	case "remove":
+		return []effect{{kind: effectRemove, ptr: op.path}}
+	case "add":
+		return []effect{c.placement(op.path)}
+	case "copy":
+		return []effect{{kind: effectRead, ptr: op.from}, c.placement(op.path)}
+	case "move":
+		return []effect{{kind: effectRemove, ptr: op.from}, c.placement(op.path)}
+	}
+
+	return nil
+}
+
+// overwrites returns the pointer whose whole value op replaces or removes, if
+// any.
+func (c *composer) overwrites(op *composeOp) ([]string, bool) {
+	effects := c.effects(op)
+	if len(effects) == 0 {
+		return nil, false
+	}
+
+	last := effects[len(effects)-1]
+
+	switch last.kind {
+	case effectWrite:
+		return last.ptr, true
+	case effectRemove:
+		return last.ptr, true
+	}
+
+	return nil, false
+}
+
+// fold appends op to ops, folding it into earlier operations where possible.
+// Earlier operations are visited from last to first: those independent of op
+// are swapped with it, adjusting array indices as needed, until an operation
+// is found that op can be merged with or that it depends on.
+func (c *composer) fold(ops []*composeOp, op *composeOp) ([]*composeOp, error) {
+	work := concatOps(ops)
+	cur := op
+	changed := false
+
+	i := len(work) - 1
+
+	for ; i >= 0; i-- {
+		prev := work[i]
+
+		if c.supersedes(cur, prev) {
+			work = concatOps(work[:i], work[i+1:])
+			changed = true
+			continue
+		}
+
+		merged, ok, err := c.merge(prev, cur)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			head, tail := work[:i], work[i+1:]
+
+			if len(merged) == 0 {
+				return concatOps(head, tail), nil
+			}
+
+			head, err = c.fold(head, merged[0])
+			if err != nil {
+				return nil, err
+			}
+
+			return concatOps(head, tail), nil
+		}
+
+		second, first, ok, err := c.swap(prev, cur)
+
+		var ie *indeterminateError
+		if errors.As(err, &ie) && !c.mayCombine(work[:i+1], cur, ie.level) {
+			// Nothing that cur could be folded into lies beyond prev, so
+			// there is no harm in leaving it where it is.
+			err, ok = nil, false
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			break
+		}
+
+		work[i] = first
+		cur = second
+	}
+
+	if !changed {
+		return concatOps(ops, []*composeOp{op}), nil
+	}
+
+	return concatOps(work[:i+1], []*composeOp{cur}, work[i+1:]), nil
+}
+
+func concatOps(parts ...[]*composeOp) []*composeOp {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+
+	out := make([]*composeOp, 0, n)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+
+	return out
+}
+
+// supersedes reports whether later replaces or removes a value that contains
+// everything prev touched, making prev redundant.
+func (c *composer) supersedes(later, prev *composeOp) bool {
+	if prev.kind == "test" {
+		return false
+	}
+
+	target, ok := c.overwrites(later)
+	if !ok {
+		return false
+	}
+
+	// A move or copy reading the value prev changed depends on it, whether it
+	// reads from inside target or from a value containing it.
+	if later.from != nil && (hasPointerPrefix(later.from, target) || hasPointerPrefix(target, later.from)) {
+		return false
+	}
+
+	for _, e := range c.effects(prev) {
+		if len(e.ptr) <= len(target) || !hasPointerPrefix(e.ptr, target) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// merge combines prev with the operation cur that directly follows it. It
+// returns the replacement operations and whether a merge was possible.
+func (c *composer) merge(prev, cur *composeOp) ([]*composeOp, bool, error) {
+	if prev.kind == "test" {
+		return nil, false, nil
+	}
+
+	effects := c.effects(prev)
+	target := effects[len(effects)-1]
+
+	if pointerEqual(target.ptr, cur.path) {
+		return c.mergeSame(prev, cur, target)
+	}
+
+	if prev.kind != "add" && prev.kind != "replace" {
+		return nil, false, nil
+	}
+
+	if len(prev.path) > 0 && prev.path[len(prev.path)-1] == "-" {
+		return nil, false, nil
+	}
+
+	for _, ptr := range cur.pointers() {
+		if len(ptr) <= len(prev.path) || !hasPointerPrefix(ptr, prev.path) {
+			return nil, false, nil
+		}
+	}
+
+	val, err := applyWithin(prev, cur)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []*composeOp{prev.withKind(prev.kind, val)}, true, nil
+}
+
+// mergeSame combines prev with an operation cur that targets the location
+// prev last wrote to, inserted at or removed from.
+func (c *composer) mergeSame(prev, cur *composeOp, target effect) ([]*composeOp, bool, error) {
+	class := c.classify(cur.path)
+
+	if class == tokenAppend {
+		return nil, false, nil
+	}
+
+	if target.kind == effectRemove {
+		switch {
+		case cur.kind == "add":
+			// Removing and adding at the same location, be it an object member
+			// or an array index, is the same as replacing the value there.
+			return []*composeOp{cur.withKind("replace", cur.value)}, true, nil
+		case class != tokenMember:
+			return nil, false, nil
+		case cur.kind == "replace", cur.kind == "remove":
+			return nil, false, fmt.Errorf("%s operation does not apply: %q was removed by an earlier operation: %w",
+				cur.kind, joinPointer(cur.path), ErrMissing)
+		}
+
+		return nil, false, nil
+	}
+
+	switch cur.kind {
+	case "replace":
+		switch prev.kind {
+		case "add", "copy":
+			return []*composeOp{prev.withKind("add", cur.value)}, true, nil
+		case "replace":
+			return []*composeOp{prev.withKind("replace", cur.value)}, true, nil
+		}
+	case "add":
+		if target.kind != effectWrite || c.classify(cur.path) != tokenMember {
+			return nil, false, nil
+		}
+
+		switch prev.kind {
+		case "add", "copy":
+			return []*composeOp{prev.withKind("add", cur.value)}, true, nil
+		case "replace":
+			return []*composeOp{prev.withKind("replace", cur.value)}, true, nil
+		}
+	case "remove":
+		if prev.kind == "replace" {
+			return []*composeOp{prev.withKind("remove", nil)}, true, nil
+		}
+
+		switch {
+		case class == tokenMember:
+			// The add may have replaced an existing member, in which case the
+			// pair removes it, or created it, in which case it is a no-op.
+			return nil, false, nil
+		case class == tokenAmbiguous, class == tokenNegative && c.kindOf(cur.path[:len(cur.path)-1]) != kindArray:
+			return nil, false, fmt.Errorf("cannot tell whether %q is an array index or an object member: %w",
+				joinPointer(cur.path), ErrIndeterminate)
+		}
+
+		switch prev.kind {
+		case "add", "copy":
+			return nil, true, nil
+		case "move":
+			rm := prev.withKind("remove", nil)
+			rm.path = appendPointer(prev.from)
+			return []*composeOp{rm}, true, nil
+		}
+	case "test":
+		if prev.kind != "add" && prev.kind != "replace" {
+			return nil, false, nil
+		}
+
+		if !newLazyNode(prev.value).equal(newLazyNode(cur.value)) {
+			return nil, false, fmt.Errorf("testing value %s failed: %w", joinPointer(cur.path), ErrTestFailed)
+		}
+
+		return []*composeOp{prev}, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// applyWithin applies op, whose pointers lie inside the value set by holder,
+// directly to that value and returns the result.
+func applyWithin(holder, op *composeOp) (*json.RawMessage, error) {
+	rel := op.clone()
+	rel.path = rel.path[len(holder.path):]
+	if rel.from != nil {
+		rel.from = rel.from[len(holder.path):]
+	}
+
+	doc := bytes.TrimSpace(*holder.value)
+	if len(doc) == 0 || (doc[0] != '{' && doc[0] != '[') {
+		return nil, fmt.Errorf("%s operation does not apply: %q is not a container: %w",
+			op.kind, joinPointer(holder.path), ErrInvalid)
+	}
+
+	out, err := Patch{rel.operation()}.ApplyWithOptions(doc, NewApplyOptions())
+	if err != nil {
+		return nil, fmt.Errorf("%s operation does not apply to the value at %q: %w",
+			op.kind, joinPointer(holder.path), err)
+	}
+
+	return newRawMessage(out), nil
+}
+
+// swap exchanges first with the operation second that directly follows it. It
+// returns second and first rewritten so that applying them in that order has
+// the same effect, and false if the two depend on each other.
+func (c *composer) swap(first, second *composeOp) (*composeOp, *composeOp, bool, error) {
+	fe, se := c.effects(first), c.effects(second)
+
+	if len(fe) == 1 && len(se) == 1 {
+		return c.swapSingle(first, second, fe[0], se[0])
+	}
+
+	for _, a := range fe {
+		for _, b := range se {
+			if c.interacts(a, b) || c.interacts(b, a) {
+				return nil, nil, false, nil
+			}
+		}
+	}
+
+	return second.clone(), first.clone(), true, nil
+}
+
+// interacts reports whether b overlaps a, or lies in an array whose indices a
+// shifts.
+func (c *composer) interacts(a, b effect) bool {
+	if a.kind == effectRead && b.kind == effectRead {
+		return false
+	}
+
+	if hasPointerPrefix(a.ptr, b.ptr) || hasPointerPrefix(b.ptr, a.ptr) {
+		return true
+	}
+
+	return c.shiftLevel(a, b) >= 0
+}
+
+// shiftLevel returns the depth of the array in which a inserts or removes an
+// element and that b addresses, or -1.
+func (c *composer) shiftLevel(a, b effect) int {
+	if a.kind != effectInsert && a.kind != effectRemove {
+		return -1
+	}
+
+	if c.classify(a.ptr) == tokenMember {
+		return -1
+	}
+
+	level := len(a.ptr) - 1
+	if len(b.ptr) <= level || !hasPointerPrefix(b.ptr, a.ptr[:level]) {
+		return -1
+	}
+
+	return level
+}
+
+func (c *composer) swapSingle(first, second *composeOp, fe, se effect) (*composeOp, *composeOp, bool, error) {
+	level := c.shiftLevel(fe, se)
+	if level < 0 {
+		level = c.shiftLevel(se, fe)
+	}
+
+	if level < 0 {
+		if c.interacts(fe, se) {
+			return nil, nil, false, nil
+		}
+		return second.clone(), first.clone(), true, nil
+	}
+
+	fk := positionKind(fe, level)
+	sk := positionKind(se, level)
+
+	fc := c.classify(fe.ptr[:level+1])
+	sc := c.classify(se.ptr[:level+1])
+
+	indeterminate := func() (*composeOp, *composeOp, bool, error) {
+		return nil, nil, false, &indeterminateError{
+			level: level,
+			err: fmt.Errorf("cannot compose %s at %q with %s at %q: %w",
+				first.kind, joinPointer(fe.ptr), second.kind, joinPointer(se.ptr), ErrIndeterminate),
+		}
+	}
+
+	switch {
+	case fc == tokenMember || sc == tokenMember:
+		return nil, nil, false, nil
+	case fc == tokenNegative || sc == tokenNegative:
+		return indeterminate()
+	case fc == tokenAppend && fk == effectInsert:
+		if sc == tokenAppend {
+			return nil, nil, false, nil
+		}
+		return indeterminate()
+	case sc == tokenAppend && sk == effectInsert:
+		return second.clone(), first.clone(), true, nil
+	case fc == tokenAppend || sc == tokenAppend:
+		return nil, nil, false, nil
+	}
+
+	a, _ := arrayIndex(fe.ptr[level])
+	b, _ := arrayIndex(se.ptr[level])
+
+	a2, b2, ok := swapIndices(fk, a, sk, b)
+	if !ok {
+		return nil, nil, false, nil
+	}
+
+	if (fc == tokenAmbiguous || sc == tokenAmbiguous) && (a2 != a || b2 != b) {
+		// As object members the two would not affect each other, so the
+		// result depends on the kind of the container.
+		return indeterminate()
+	}
+
+	s, f := second.clone(), first.clone()
+	s.path[level] = strconv.Itoa(b2)
+	f.path[level] = strconv.Itoa(a2)
+
+	return s, f, true, nil
+}
+
+// indeterminateError reports that two operations on the same array cannot be
+// swapped without knowing the array.
+type indeterminateError struct {
+	level int
+	err   error
+}
+
+func (e *indeterminateError) Error() string {
+	return e.err.Error()
+}
+
+func (e *indeterminateError) Unwrap() error {
+	return e.err
+}
+
+// mayCombine reports whether any of ops could be merged with op, which
+// addresses the array at the given depth, if only op could be moved next to
+// it.
+func (c *composer) mayCombine(ops []*composeOp, op *composeOp, level int) bool {
+	parent := op.path[:level]
+	inserts := positionKind(c.effects(op)[0], level) == effectInsert
+
+	for _, prev := range ops {
+		for _, e := range c.effects(prev) {
+			switch {
+			case len(e.ptr) <= level:
+				if e.kind != effectRead && hasPointerPrefix(parent, e.ptr) {
+					return true
+				}
+			case !hasPointerPrefix(e.ptr, parent):
+			case !inserts:
+				return true
+			case e.kind == effectRemove && len(e.ptr) == level+1:
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// positionKind reports how an effect acts on the array element it addresses at
+// level: by inserting or removing it, or by acting on or inside it.
+func positionKind(e effect, level int) effectKind {
+	if len(e.ptr) == level+1 && (e.kind == effectInsert || e.kind == effectRemove) {
+		return e.kind
+	}
+	return effectRead
+}
+
+// swapIndices exchanges two operations on the same array. The first acts on
+// index a, the second on index b after the first was applied. It returns the
+// index of the first after the second was applied, and the index of the second
+// before the first was applied.
+func swapIndices(fk effectKind, a int, sk effectKind, b int) (int, int, bool) {
+	switch fk {
+	case effectInsert:
+		switch {
+		case sk == effectInsert && b <= a:
+			return a + 1, b, true
+		case b == a:
+			return 0, 0, false
+		case b < a && sk == effectRemove:
+			return a - 1, b, true
+		case b < a:
+			return a, b, true
+		default:
+			return a, b - 1, true
+		}
+	case effectRemove:
+		switch {
+		case sk == effectInsert && b <= a:
+			return a + 1, b, true
+		case sk == effectInsert:
+			return a, b + 1, true
+		case b >= a:
+			return a, b + 1, true
+		case sk == effectRemove:
+			return a - 1, b, true
+		default:
+			return a, b, true
+		}
+	default:
+		switch {
+		case sk == effectInsert && b <= a:
+			return a + 1, b, true
+		case sk == effectInsert:
+			return a, b, true
+		case b == a:
+			return 0, 0, false
+		case b < a:
+			return a - 1, b, true
+		default:
+			return a, b, true
+		}
+	}
+}
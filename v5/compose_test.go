@@ -0,0 +1,331 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+func decodePatches(t *testing.T, patches []string) []Patch {
+	t.Helper()
+
+	out := make([]Patch, len(patches))
+	for i, p := range patches {
+		decoded, err := DecodePatch([]byte(p))
+		if err != nil {
+			t.Fatalf("unable to decode patch %s: %s", p, err)
+		}
+		out[i] = decoded
+	}
+
+	return out
+}
+
+func applySequence(t *testing.T, doc string, patches []Patch) string {
+	t.Helper()
+
+	cur := []byte(doc)
+	for _, p := range patches {
+		var err error
+
+		cur, err = p.Apply(cur)
+		if err != nil {
+			t.Fatalf("unable to apply patch: %s", err)
+		}
+	}
+
+	return string(cur)
+}
+
+var ComposeCases = []struct {
+	doc     string
+	patches []string
+	result  string
+}{
+	{
+		`{"foo": "bar"}`,
+		[]string{
+			`[{"op": "replace", "path": "/foo", "value": 1}]`,
+			`[{"op": "replace", "path": "/foo", "value": 2}]`,
+			`[{"op": "replace", "path": "/foo", "value": 3}]`,
+		},
+		`[{"op": "replace", "path": "/foo", "value": 3}]`,
+	},
+	{
+		`{"list": ["a", "b"]}`,
+		[]string{
+			`[{"op": "add", "path": "/list/1", "value": "x"}]`,
+			`[{"op": "remove", "path": "/list/1"}]`,
+		},
+		`[]`,
+	},
+	{
+		`{"list": ["a", "b"]}`,
+		[]string{
+			`[{"op": "add", "path": "/list/-", "value": "x"}]`,
+			`[{"op": "add", "path": "/list/0", "value": "y"}]`,
+			`[{"op": "remove", "path": "/list/0"}]`,
+		},
+		`[{"op": "add", "path": "/list/-", "value": "x"}]`,
+	},
+	{
+		`{"list": ["a", "b", "c"]}`,
+		[]string{
+			`[{"op": "add", "path": "/list/1", "value": "x"}]`,
+			`[{"op": "remove", "path": "/list/0"}]`,
+			`[{"op": "replace", "path": "/list/0", "value": "y"}]`,
+		},
+		`[{"op": "add", "path": "/list/1", "value": "y"}, {"op": "remove", "path": "/list/0"}]`,
+	},
+	{
+		`{"list": ["a", "b", "c"]}`,
+		[]string{
+			`[{"op": "add", "path": "/list/0", "value": "x"}]`,
+			`[{"op": "replace", "path": "/list/3", "value": "y"}]`,
+			`[{"op": "remove", "path": "/list/0"}]`,
+		},
+		`[{"op": "replace", "path": "/list/2", "value": "y"}]`,
+	},
+	{
+		`{"list": ["a", "b", "c"]}`,
+		[]string{
+			`[{"op": "remove", "path": "/list/1"}]`,
+			`[{"op": "add", "path": "/list/1", "value": "x"}]`,
+		},
+		`[{"op": "replace", "path": "/list/1", "value": "x"}]`,
+	},
+	{
+		`{"foo": "bar"}`,
+		[]string{
+			`[{"op": "add", "path": "/child", "value": {"a": 1}}]`,
+			`[{"op": "add", "path": "/child/b", "value": 2}]`,
+			`[{"op": "remove", "path": "/child/a"}]`,
+			`[{"op": "test", "path": "/child/b", "value": 2}]`,
+		},
+		`[{"op": "add", "path": "/child", "value": {"b": 2}}]`,
+	},
+	{
+		`{"foo": {"a": 1}}`,
+		[]string{
+			`[{"op": "add", "path": "/foo/b", "value": 2}]`,
+			`[{"op": "replace", "path": "/foo/a", "value": 3}]`,
+			`[{"op": "remove", "path": "/foo"}]`,
+		},
+		`[{"op": "remove", "path": "/foo"}]`,
+	},
+	{
+		`{"foo": 1}`,
+		[]string{
+			`[{"op": "remove", "path": "/foo"}]`,
+			`[{"op": "add", "path": "/foo", "value": 2}]`,
+		},
+		`[{"op": "replace", "path": "/foo", "value": 2}]`,
+	},
+	{
+		`{"foo": 1}`,
+		[]string{
+			`[{"op": "replace", "path": "/foo", "value": 2}]`,
+			`[{"op": "remove", "path": "/foo"}]`,
+		},
+		`[{"op": "remove", "path": "/foo"}]`,
+	},
+	{
+		`{"foo": 1}`,
+		[]string{
+			`[{"op": "add", "path": "/foo", "value": 2}]`,
+			`[{"op": "remove", "path": "/foo"}]`,
+		},
+		`[{"op": "add", "path": "/foo", "value": 2}, {"op": "remove", "path": "/foo"}]`,
+	},
+	{
+		`{"foo": 1, "bar": 2}`,
+		[]string{
+			`[{"op": "test", "path": "/foo", "value": 1}]`,
+			`[{"op": "replace", "path": "/bar", "value": 3}]`,
+			`[{"op": "replace", "path": "/foo", "value": 4}]`,
+			`[{"op": "replace", "path": "/bar", "value": 5}]`,
+		},
+		`[{"op": "test", "path": "/foo", "value": 1}, {"op": "replace", "path": "/bar", "value": 5}, {"op": "replace", "path": "/foo", "value": 4}]`,
+	},
+	{
+		`{"a": {"x": 1}, "b": {}}`,
+		[]string{
+			`[{"op": "move", "from": "/a/x", "path": "/b/x"}]`,
+			`[{"op": "replace", "path": "/b/x", "value": 2}]`,
+		},
+		`[{"op": "move", "from": "/a/x", "path": "/b/x"}, {"op": "replace", "path": "/b/x", "value": 2}]`,
+	},
+	{
+		`{"list": [1, 2, 3]}`,
+		[]string{
+			`[{"op": "move", "from": "/list/0", "path": "/list/-"}]`,
+			`[{"op": "add", "path": "/other", "value": true}]`,
+		},
+		`[{"op": "move", "from": "/list/0", "path": "/list/-"}, {"op": "add", "path": "/other", "value": true}]`,
+	},
+	{
+		`{"o": {"x": {"y": 1}}}`,
+		[]string{
+			`[{"op": "replace", "path": "/o/x/y", "value": 2}]`,
+			`[{"op": "copy", "from": "/o", "path": "/o/x"}]`,
+		},
+		`[{"op": "replace", "path": "/o/x/y", "value": 2}, {"op": "copy", "from": "/o", "path": "/o/x"}]`,
+	},
+}
+
+func TestComposePatches(t *testing.T) {
+	for i, c := range ComposeCases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			patches := decodePatches(t, c.patches)
+
+			composed, err := ComposePatches(patches...)
+			if err != nil {
+				t.Fatalf("unable to compose patches: %s", err)
+			}
+
+			got, err := json.Marshal(composed)
+			if err != nil {
+				t.Fatalf("unable to marshal composed patch: %s", err)
+			}
+
+			if !compareJSON(string(got), c.result) {
+				t.Errorf("unexpected composed patch. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(got)))
+			}
+
+			expected := applySequence(t, c.doc, patches)
+
+			out, err := composed.Apply([]byte(c.doc))
+			if err != nil {
+				t.Fatalf("unable to apply composed patch: %s", err)
+			}
+
+			if !compareJSON(string(out), expected) {
+				t.Errorf("composed patch did not apply. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(expected), reformatJSON(string(out)))
+			}
+		})
+	}
+}
+
+func TestComposePatchesErrors(t *testing.T) {
+	cases := []struct {
+		patches       []string
+		indeterminate bool
+	}{
+		{
+			[]string{
+				`[{"op": "add", "path": "/list/-", "value": 1}]`,
+				`[{"op": "remove", "path": "/list/2"}]`,
+			},
+			true,
+		},
+		{
+			[]string{
+				`[{"op": "test", "path": "/m/name", "value": 1}]`,
+				`[{"op": "test", "path": "/m/-", "value": null}]`,
+				`[{"op": "add", "path": "/m/1", "value": 1}]`,
+				`[{"op": "remove", "path": "/m/1"}]`,
+			},
+			true,
+		},
+		{
+			[]string{
+				`[{"op": "add", "path": "/list/-1", "value": 1}]`,
+				`[{"op": "replace", "path": "/list/0", "value": 2}]`,
+			},
+			true,
+		},
+		{
+			[]string{
+				`[{"op": "remove", "path": "/a/1"}]`,
+				`[{"op": "remove", "path": "/a/1"}]`,
+				`[{"op": "add", "path": "/a", "value": {}}]`,
+			},
+			true,
+		},
+		{
+			[]string{
+				`[{"op": "remove", "path": "/foo"}]`,
+				`[{"op": "replace", "path": "/foo", "value": 1}]`,
+			},
+			false,
+		},
+		{
+			[]string{
+				`[{"op": "add", "path": "/foo", "value": "bar"}]`,
+				`[{"op": "add", "path": "/foo/baz", "value": 1}]`,
+			},
+			false,
+		},
+		{
+			[]string{
+				`[{"op": "add", "path": "/foo", "value": {"a": 1}}]`,
+				`[{"op": "test", "path": "/foo/a", "value": 2}]`,
+			},
+			false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			_, err := ComposePatches(decodePatches(t, c.patches)...)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if errors.Is(err, ErrIndeterminate) != c.indeterminate {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestComposePatchesRandom(t *testing.T) {
+	docs := []string{
+		`{"a": [{"k": 0}, {"k": 1}, 2, 3], "o": {"x": {"y": 1}, "z": [4, 5]}, "s": "t"}`,
+		`{"a": [[1, [2, 3]], {"b": [4, {"c": 5}]}], "d": {"e": [6], "f": {"g": 7}}}`,
+	}
+
+	values := []string{"1", "2", `"x"`, "{}", "[]", `{"k": 3}`, "[4, 5]"}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, doc := range docs {
+		for i := 0; i < 3000; i++ {
+			var patches []Patch
+
+			cur := doc
+			for n := 2 + r.Intn(4); n > 0; n-- {
+				p := randomOperation(t, r, cur, values...)
+				patches = append(patches, p)
+				cur = applySequence(t, cur, []Patch{p})
+			}
+
+			composed, err := ComposePatches(patches...)
+			if errors.Is(err, ErrIndeterminate) {
+				continue
+			}
+
+			seq, _ := json.Marshal(patches)
+
+			if err != nil {
+				t.Fatalf("unable to compose %s: %s", seq, err)
+			}
+
+			out, err := composed.Apply([]byte(doc))
+			if err != nil {
+				c, _ := json.Marshal(composed)
+				t.Fatalf("composition %s of %s does not apply: %s", c, seq, err)
+			}
+
+			if !compareJSON(string(out), cur) {
+				c, _ := json.Marshal(composed)
+				t.Fatalf("composition %s of %s diverges:\n%s\n%s", c, seq, out, cur)
+			}
+		}
+	}
+}
@@ -0,0 +1,791 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// CRDTID identifies an operation of a CRDTDocument, along with the values
+// and array elements it creates. IDs are Lamport timestamps, ordered by
+// Clock, then Replica, then Seq.
+type CRDTID struct {
+	Clock   uint64 `json:"clock"`
+	Replica string `json:"replica"`
+	Seq     int    `json:"seq,omitempty"`
+}
+
+func (id CRDTID) less(o CRDTID) bool {
+	if id.Clock != o.Clock {
+		return id.Clock < o.Clock
+	}
+	if id.Replica != o.Replica {
+		return id.Replica < o.Replica
+	}
+	return id.Seq < o.Seq
+}
+
+func (id CRDTID) String() string {
+	return fmt.Sprintf("%d@%s.%d", id.Clock, id.Replica, id.Seq)
+}
+
+// CRDTOperation is a single change to a CRDTDocument, as exchanged between
+// replicas. Its Kind is one of:
+//
+//   - "set" and "delete", which change the member Key of the object
+//     Container. Setting member "" of the zero Container sets the whole
+//     document.
+//   - "insert", which inserts Value into the array Container after Element,
+//     or at the start of the array if Element is nil.
+//   - "update" and "remove", which change the array element Element of the
+//     array Container.
+//
+// Prev is the clock of the previous operation of the same replica, or zero
+// for its first one, so that replicas integrate the operations of each
+// replica in order and notice the ones they missed.
+type CRDTOperation struct {
+	ID        CRDTID          `json:"id"`
+	Prev      uint64          `json:"prev,omitempty"`
+	Kind      string          `json:"kind"`
+	Container CRDTID          `json:"container"`
+	Key       string          `json:"key,omitempty"`
+	Element   *CRDTID         `json:"element,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+}
+
+// VersionVector holds, for each replica, the clock of the last operation
+// integrated from it. All earlier operations of the replica have been
+// integrated as well.
+type VersionVector map[string]uint64
+
+// CRDTDocument is a JSON document that converges across replicas. Local
+// changes are made with RFC 6902 patches, and are turned into CRDT
+// operations to send to other replicas, which integrate them with Merge.
+//
+// Object members are last-writer-wins registers, and arrays are replicated
+// growable arrays (RGA), so concurrent insertions are all kept. Removal of
+// an array element wins over a concurrent update of it.
+//
+// A CRDTDocument is not safe for concurrent use.
+type CRDTDocument struct {
+	replica string
+	clock   uint64
+	initial []byte
+
+	nodes   map[CRDTID]*crdtNode
+	log     []CRDTOperation
+	seen    map[CRDTID]bool
+	pending []CRDTOperation
+	version VersionVector
+}
+
+type crdtValue struct {
+	raw  json.RawMessage
+	node *crdtNode
+}
+
+type crdtRegister struct {
+	ts    CRDTID
+	value *crdtValue
+}
+
+type crdtMember struct {
+	crdtRegister
+	created CRDTID
+	deleted bool
+}
+
+type crdtElement struct {
+	crdtRegister
+	id      CRDTID
+	removed bool
+}
+
+type crdtNode struct {
+	id       CRDTID
+	array    bool
+	members  map[string]*crdtMember
+	elements map[CRDTID]*crdtElement
+	// children holds the elements inserted after each element, with the
+	// zero ID standing for the start of the array. Later insertions come
+	// first.
+	children map[CRDTID][]*crdtElement
+}
+
+// NewCRDTDocument creates a replica of doc. All replicas of a document must
+// be created from the same doc, each with its own replica name.
+func NewCRDTDocument(replica string, doc []byte) (*CRDTDocument, error) {
+	if replica == "" {
+		return nil, fmt.Errorf("replica name must not be empty: %w", ErrInvalid)
+	}
+
+	if !json.Valid(doc) {
+		return nil, ErrInvalid
+	}
+
+	c := &CRDTDocument{
+		replica: replica,
+		initial: append([]byte(nil), doc...),
+		nodes: map[CRDTID]*crdtNode{
+			{}: {members: map[string]*crdtMember{}},
+		},
+		seen:    map[CRDTID]bool{},
+		version: VersionVector{},
+	}
+
+	err := c.integrate(CRDTOperation{Kind: "set", Value: json.RawMessage(c.initial)})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Replica returns the name of the replica.
+func (c *CRDTDocument) Replica() string {
+	return c.replica
+}
+
+// Version returns the clock of the last operation integrated from each
+// replica, without gaps: an operation that arrives before an earlier one of
+// the same replica is not integrated, nor counted, until the earlier one is.
+func (c *CRDTDocument) Version() VersionVector {
+	out := make(VersionVector, len(c.version))
+	for k, v := range c.version {
+		out[k] = v
+	}
+	return out
+}
+
+// Pending returns the number of operations passed to Merge that wait for
+// operations they depend on, or for earlier operations of their replica.
+func (c *CRDTDocument) Pending() int {
+	return len(c.pending)
+}
+
+// Changes returns the integrated operations not covered by since, in an
+// order suitable for Merge. A nil since returns all operations.
+func (c *CRDTDocument) Changes(since VersionVector) []CRDTOperation {
+	var out []CRDTOperation
+	for _, op := range c.log {
+		if op.ID.Clock > since[op.ID.Replica] {
+			out = append(out, op)
+		}
+	}
+	return out
+}
+
+// MarshalJSON returns the current state of the document as plain JSON.
+func (c *CRDTDocument) MarshalJSON() ([]byte, error) {
+	root := c.nodes[CRDTID{}].members[""]
+	return json.Marshal(c.export(root.value))
+}
+
+// Apply applies a patch to the document, and returns the CRDT operations
+// that other replicas need to merge to see the change. Nothing is changed
+// if the patch does not apply.
+func (c *CRDTDocument) Apply(patch Patch) ([]CRDTOperation, error) {
+	cur, err := c.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := patch.Apply(cur); err != nil {
+		return nil, err
+	}
+
+	var out []CRDTOperation
+
+	emit := func(op CRDTOperation) error {
+		c.clock++
+		op.ID = CRDTID{Clock: c.clock, Replica: c.replica}
+		op.Prev = c.version[c.replica]
+
+		if err := c.integrate(op); err != nil {
+			return err
+		}
+
+		out = append(out, op)
+		return nil
+	}
+
+	for _, op := range patch {
+		path, err := op.Path()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens, err := splitPointer(path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Kind() {
+		case "add", "replace":
+			var value json.RawMessage
+			value, err = opValue(op)
+			if err != nil {
+				return nil, err
+			}
+			err = c.write(tokens, op.Kind() == "add", value, emit)
+		case "remove":
+			err = c.erase(tokens, emit)
+		case "move", "copy":
+			var from string
+			from, err = op.From()
+			if err != nil {
+				return nil, err
+			}
+
+			var fromTokens []string
+			fromTokens, err = splitPointer(from)
+			if err != nil {
+				return nil, err
+			}
+
+			if op.Kind() == "move" && pointerEqual(fromTokens, tokens) {
+				continue
+			}
+
+			var value json.RawMessage
+			value, err = c.read(fromTokens)
+			if err != nil {
+				return nil, err
+			}
+
+			if op.Kind() == "move" {
+				if err = c.erase(fromTokens, emit); err != nil {
+					return nil, err
+				}
+			}
+
+			err = c.write(tokens, true, value, emit)
+		case "test":
+		default:
+			err = fmt.Errorf("Unexpected kind: %s", op.Kind())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// Merge integrates operations from other replicas. Operations may arrive in
+// any order and more than once; operations whose dependencies or earlier
+// operations of the same replica have not been integrated yet are kept until
+// they are.
+func (c *CRDTDocument) Merge(ops ...CRDTOperation) error {
+	for _, op := range ops {
+		if c.seen[op.ID] {
+			continue
+		}
+
+		if op.ID.Replica == "" {
+			return fmt.Errorf("operation %s has no replica: %w", op.ID, ErrInvalid)
+		}
+
+		if op.Prev >= op.ID.Clock {
+			return fmt.Errorf("operation %s follows operation %d of its replica: %w", op.ID, op.Prev, ErrInvalid)
+		}
+
+		switch op.Kind {
+		case "set", "delete", "insert":
+		case "update", "remove":
+			if op.Element == nil {
+				return fmt.Errorf("%s operation %s has no element: %w", op.Kind, op.ID, ErrInvalid)
+			}
+		default:
+			return fmt.Errorf("Unexpected kind: %s", op.Kind)
+		}
+
+		c.pending = append(c.pending, op)
+	}
+
+	var err error
+
+	for progress := true; progress; {
+		progress = false
+
+		rest := c.pending[:0]
+		for _, op := range c.pending {
+			switch {
+			case c.seen[op.ID]:
+				continue
+			case !c.ready(op):
+				rest = append(rest, op)
+				continue
+			}
+
+			if ierr := c.integrate(op); ierr != nil && err == nil {
+				err = ierr
+			}
+			progress = true
+		}
+		c.pending = rest
+	}
+
+	return err
+}
+
+// Delta returns an RFC 6902 patch from the state of the document at since to
+// its current state.
+func (c *CRDTDocument) Delta(since VersionVector) (Patch, error) {
+	old, err := NewCRDTDocument(c.replica, c.initial)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []CRDTOperation
+	for _, op := range c.log {
+		if op.ID.Clock <= since[op.ID.Replica] {
+			ops = append(ops, op)
+		}
+	}
+
+	if err := old.Merge(ops...); err != nil {
+		return nil, err
+	}
+
+	a, err := old.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return CreatePatch(a, b)
+}
+
+// ready reports whether everything op refers to, and the previous operation
+// of its replica, have been integrated.
+func (c *CRDTDocument) ready(op CRDTOperation) bool {
+	if op.Prev != c.version[op.ID.Replica] {
+		return false
+	}
+
+	node, ok := c.nodes[op.Container]
+	if !ok {
+		return false
+	}
+
+	switch op.Kind {
+	case "insert":
+		if op.Element == nil {
+			return true
+		}
+		_, ok = node.elements[*op.Element]
+	case "update", "remove":
+		_, ok = node.elements[*op.Element]
+	}
+
+	return ok
+}
+
+func (c *CRDTDocument) integrate(op CRDTOperation) error {
+	node := c.nodes[op.Container]
+
+	if node.array != (op.Kind == "insert" || op.Kind == "update" || op.Kind == "remove") {
+		return fmt.Errorf("operation %s cannot %s in %s: %w", op.ID, op.Kind, op.Container, ErrInvalid)
+	}
+
+	var value *crdtValue
+
+	switch op.Kind {
+	case "set", "insert", "update":
+		seq := op.ID.Seq
+		v, err := c.materialize(op.Value, op.ID, &seq)
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+
+	switch op.Kind {
+	case "set", "delete":
+		m, ok := node.members[op.Key]
+		if !ok {
+			m = &crdtMember{crdtRegister: crdtRegister{ts: op.ID}, created: op.ID}
+			node.members[op.Key] = m
+		} else if op.ID.less(m.created) {
+			m.created = op.ID
+		}
+
+		if !ok || m.ts.less(op.ID) {
+			m.ts = op.ID
+			m.value = value
+			m.deleted = op.Kind == "delete"
+		}
+	case "insert":
+		ref := CRDTID{}
+		if op.Element != nil {
+			ref = *op.Element
+		}
+
+		e := &crdtElement{
+			crdtRegister: crdtRegister{ts: op.ID, value: value},
+			id:           op.ID,
+		}
+		node.insertAfter(ref, e)
+	case "update", "remove":
+		e := node.elements[*op.Element]
+		if op.Kind == "remove" {
+			e.removed = true
+		} else if e.ts.less(op.ID) {
+			e.ts = op.ID
+			e.value = value
+		}
+	}
+
+	if op.ID.Replica == "" {
+		// The initial document is shared by all replicas.
+		return nil
+	}
+
+	c.seen[op.ID] = true
+	c.log = append(c.log, op)
+
+	if op.ID.Clock > c.clock {
+		c.clock = op.ID.Clock
+	}
+
+	c.version[op.ID.Replica] = op.ID.Clock
+
+	return nil
+}
+
+func (n *crdtNode) insertAfter(ref CRDTID, e *crdtElement) {
+	siblings := n.children[ref]
+
+	i := sort.Search(len(siblings), func(i int) bool {
+		return siblings[i].id.less(e.id)
+	})
+
+	siblings = append(siblings, nil)
+	copy(siblings[i+1:], siblings[i:])
+	siblings[i] = e
+
+	n.children[ref] = siblings
+	n.elements[e.id] = e
+}
+
+// visible returns the elements of an array node that have not been removed,
+// in order.
+func (n *crdtNode) visible() []*crdtElement {
+	var out []*crdtElement
+
+	// Walk the tree of insertions depth first, visiting later insertions
+	// after the same element first.
+	var stack []*crdtElement
+	push := func(ref CRDTID) {
+		siblings := n.children[ref]
+		for i := len(siblings) - 1; i >= 0; i-- {
+			stack = append(stack, siblings[i])
+		}
+	}
+
+	push(CRDTID{})
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !e.removed {
+			out = append(out, e)
+		}
+		push(e.id)
+	}
+
+	return out
+}
+
+// members returns the names of the members of an object node that have not
+// been deleted, in the order they were first set.
+func (n *crdtNode) memberNames() []string {
+	var out []string
+	for k, m := range n.members {
+		if !m.deleted {
+			out = append(out, k)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return n.members[out[i]].created.less(n.members[out[j]].created)
+	})
+
+	return out
+}
+
+// materialize turns a JSON value into CRDT values. Objects and arrays
+// become nodes, whose IDs along with those of their members and elements
+// are derived from id, so that every replica assigns the same ones.
+func (c *CRDTDocument) materialize(raw json.RawMessage, id CRDTID, seq *int) (*crdtValue, error) {
+	if len(raw) == 0 {
+		raw = rawJSONNull
+	}
+
+	node := newLazyNode(newRawMessage(raw))
+
+	next := func() CRDTID {
+		*seq++
+		return CRDTID{Clock: id.Clock, Replica: id.Replica, Seq: *seq}
+	}
+
+	switch nodeType(node) {
+	case nodeObject:
+		doc, err := node.intoDoc(NewApplyOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		n := &crdtNode{id: next(), members: map[string]*crdtMember{}}
+		c.nodes[n.id] = n
+
+		for _, k := range doc.keys {
+			if _, ok := n.members[k]; ok {
+				continue
+			}
+
+			mid := next()
+			v, err := c.materialize(rawNode(doc.obj[k]), id, seq)
+			if err != nil {
+				return nil, err
+			}
+
+			n.members[k] = &crdtMember{
+				crdtRegister: crdtRegister{ts: mid, value: v},
+				created:      mid,
+			}
+		}
+
+		return &crdtValue{node: n}, nil
+	case nodeArray:
+		ary, err := node.intoAry()
+		if err != nil {
+			return nil, err
+		}
+
+		n := &crdtNode{
+			id:       next(),
+			array:    true,
+			elements: map[CRDTID]*crdtElement{},
+			children: map[CRDTID][]*crdtElement{},
+		}
+		c.nodes[n.id] = n
+
+		prev := CRDTID{}
+		for _, elem := range ary.nodes {
+			eid := next()
+			v, err := c.materialize(rawNode(elem), id, seq)
+			if err != nil {
+				return nil, err
+			}
+
+			n.insertAfter(prev, &crdtElement{
+				crdtRegister: crdtRegister{ts: eid, value: v},
+				id:           eid,
+			})
+			prev = eid
+		}
+
+		return &crdtValue{node: n}, nil
+	}
+
+	return &crdtValue{raw: node.compact()}, nil
+}
+
+func rawNode(n *lazyNode) json.RawMessage {
+	if n == nil || n.raw == nil {
+		return rawJSONNull
+	}
+	return *n.raw
+}
+
+func (c *CRDTDocument) export(v *crdtValue) *lazyNode {
+	if v.node == nil {
+		raw := v.raw
+		return newLazyNode(&raw)
+	}
+
+	if v.node.array {
+		elems := v.node.visible()
+
+		ary := &partialArray{nodes: make([]*lazyNode, len(elems))}
+		for i, e := range elems {
+			ary.nodes[i] = c.export(e.value)
+		}
+
+		return &lazyNode{ary: ary, which: eAry}
+	}
+
+	doc := &partialDoc{
+		keys: v.node.memberNames(),
+		obj:  map[string]*lazyNode{},
+		opts: NewApplyOptions(),
+	}
+	for _, k := range doc.keys {
+		doc.obj[k] = c.export(v.node.members[k].value)
+	}
+
+	return &lazyNode{doc: doc, which: eDoc}
+}
+
+// lookup returns the value at the pointer tokens.
+func (c *CRDTDocument) lookup(tokens []string) (*crdtValue, error) {
+	v := c.nodes[CRDTID{}].members[""].value
+
+	for i, tok := range tokens {
+		if v.node == nil {
+			return nil, fmt.Errorf("unable to find %s: %w", joinPointer(tokens[:i+1]), ErrMissing)
+		}
+
+		if !v.node.array {
+			m, ok := v.node.members[tok]
+			if !ok || m.deleted {
+				return nil, fmt.Errorf("unable to find %s: %w", joinPointer(tokens[:i+1]), ErrMissing)
+			}
+			v = m.value
+			continue
+		}
+
+		elems := v.node.visible()
+
+		idx, err := elementIndex(tok, len(elems))
+		if err != nil {
+			return nil, err
+		}
+
+		v = elems[idx].value
+	}
+
+	return v, nil
+}
+
+// parent returns the container holding the value at the pointer tokens.
+func (c *CRDTDocument) parent(tokens []string) (*crdtNode, error) {
+	if len(tokens) == 0 {
+		return c.nodes[CRDTID{}], nil
+	}
+
+	v, err := c.lookup(tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	if v.node == nil {
+		return nil, fmt.Errorf("unable to find container of %s: %w", joinPointer(tokens), ErrMissing)
+	}
+
+	return v.node, nil
+}
+
+func elementIndex(tok string, n int) (int, error) {
+	idx, err := strconv.Atoi(tok)
+	if err != nil {
+		return 0, fmt.Errorf("value was not a proper array index: '%s': %w", tok, err)
+	}
+
+	if idx < 0 {
+		idx += n
+	}
+
+	if idx < 0 || idx >= n {
+		return 0, fmt.Errorf("Unable to access invalid index: %d: %w", idx, ErrInvalidIndex)
+	}
+
+	return idx, nil
+}
+
+func (c *CRDTDocument) read(tokens []string) (json.RawMessage, error) {
+	v, err := c.lookup(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(c.export(v))
+}
+
+func (c *CRDTDocument) write(tokens []string, insert bool, value json.RawMessage, emit func(CRDTOperation) error) error {
+	node, err := c.parent(tokens)
+	if err != nil {
+		return err
+	}
+
+	key := ""
+	if len(tokens) > 0 {
+		key = tokens[len(tokens)-1]
+	}
+
+	if !node.array {
+		return emit(CRDTOperation{Kind: "set", Container: node.id, Key: key, Value: value})
+	}
+
+	elems := node.visible()
+
+	if !insert {
+		idx, err := elementIndex(key, len(elems))
+		if err != nil {
+			return err
+		}
+
+		return emit(CRDTOperation{Kind: "update", Container: node.id, Element: &elems[idx].id, Value: value})
+	}
+
+	idx := len(elems)
+	if key != "-" {
+		idx, err = elementIndex(key, len(elems)+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	op := CRDTOperation{Kind: "insert", Container: node.id, Value: value}
+	if idx > 0 {
+		op.Element = &elems[idx-1].id
+	}
+
+	return emit(op)
+}
+
+func (c *CRDTDocument) erase(tokens []string, emit func(CRDTOperation) error) error {
+	node, err := c.parent(tokens)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return fmt.Errorf("unable to remove the whole document: %w", ErrInvalid)
+	}
+
+	key := tokens[len(tokens)-1]
+
+	if !node.array {
+		return emit(CRDTOperation{Kind: "delete", Container: node.id, Key: key})
+	}
+
+	elems := node.visible()
+
+	idx, err := elementIndex(key, len(elems))
+	if err != nil {
+		return err
+	}
+
+	return emit(CRDTOperation{Kind: "remove", Container: node.id, Element: &elems[idx].id})
+}
+
+func opValue(op Operation) (json.RawMessage, error) {
+	v, ok := op["value"]
+	if !ok || v == nil {
+		if ok {
+			return rawJSONNull, nil
+		}
+		return nil, fmt.Errorf("%s operation has no value: %w", op.Kind(), ErrMissing)
+	}
+	return *v, nil
+}
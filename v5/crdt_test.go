@@ -0,0 +1,280 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+func newReplicas(t *testing.T, doc string, names ...string) []*CRDTDocument {
+	t.Helper()
+
+	out := make([]*CRDTDocument, len(names))
+	for i, name := range names {
+		c, err := NewCRDTDocument(name, []byte(doc))
+		if err != nil {
+			t.Fatalf("unable to create replica: %s", err)
+		}
+		out[i] = c
+	}
+
+	return out
+}
+
+func applyCRDT(t *testing.T, c *CRDTDocument, patch string) []CRDTOperation {
+	t.Helper()
+
+	p, err := DecodePatch([]byte(patch))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	ops, err := c.Apply(p)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	return ops
+}
+
+func crdtJSON(t *testing.T, c *CRDTDocument) string {
+	t.Helper()
+
+	out, err := c.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unable to marshal document: %s", err)
+	}
+
+	return string(out)
+}
+
+func TestCRDTConverges(t *testing.T) {
+	r := newReplicas(t, `{"name": "x", "list": [1, 2, 3]}`, "a", "b")
+	a, b := r[0], r[1]
+
+	opsA := applyCRDT(t, a, `[{"op": "replace", "path": "/name", "value": "a"}, {"op": "add", "path": "/list/1", "value": "a"}]`)
+	opsB := applyCRDT(t, b, `[{"op": "replace", "path": "/name", "value": "b"}, {"op": "add", "path": "/list/1", "value": "b"}, {"op": "remove", "path": "/list/2"}]`)
+
+	if err := a.Merge(opsB...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if err := b.Merge(opsA...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	expected := `{"name": "b", "list": [1, "b", "a", 3]}`
+
+	for _, c := range r {
+		if got := crdtJSON(t, c); !compareJSON(got, expected) {
+			t.Errorf("replica %s did not converge. Expected:\n%s\n\nActual:\n%s",
+				c.Replica(), reformatJSON(expected), reformatJSON(got))
+		}
+	}
+
+	if crdtJSON(t, a) != crdtJSON(t, b) {
+		t.Errorf("replicas differ in member order: %s and %s", crdtJSON(t, a), crdtJSON(t, b))
+	}
+}
+
+func TestCRDTNestedValues(t *testing.T) {
+	r := newReplicas(t, `{}`, "a", "b")
+	a, b := r[0], r[1]
+
+	ops := applyCRDT(t, a, `[{"op": "add", "path": "/child", "value": {"tags": ["x"]}}, {"op": "add", "path": "/child/tags/-", "value": "y"}]`)
+	if err := b.Merge(ops...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	ops = applyCRDT(t, b, `[{"op": "add", "path": "/child/tags/0", "value": "w"}, {"op": "move", "from": "/child/tags/2", "path": "/last"}]`)
+	if err := a.Merge(ops...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	expected := `{"child": {"tags": ["w", "x"]}, "last": "y"}`
+
+	for _, c := range r {
+		if got := crdtJSON(t, c); !compareJSON(got, expected) {
+			t.Errorf("unexpected document on replica %s. Expected:\n%s\n\nActual:\n%s",
+				c.Replica(), reformatJSON(expected), reformatJSON(got))
+		}
+	}
+}
+
+func TestCRDTRemoveWinsOverUpdate(t *testing.T) {
+	r := newReplicas(t, `{"list": [1, 2]}`, "a", "b")
+	a, b := r[0], r[1]
+
+	opsA := applyCRDT(t, a, `[{"op": "remove", "path": "/list/0"}]`)
+	opsB := applyCRDT(t, b, `[{"op": "replace", "path": "/list/0", "value": 3}]`)
+
+	a.Merge(opsB...)
+	b.Merge(opsA...)
+
+	for _, c := range r {
+		if got := crdtJSON(t, c); !compareJSON(got, `{"list": [2]}`) {
+			t.Errorf("unexpected document on replica %s: %s", c.Replica(), got)
+		}
+	}
+}
+
+func TestCRDTMergeOutOfOrder(t *testing.T) {
+	r := newReplicas(t, `{}`, "a", "b")
+	a, b := r[0], r[1]
+
+	ops := applyCRDT(t, a, `[{"op": "add", "path": "/list", "value": []}, {"op": "add", "path": "/list/0", "value": 1}, {"op": "add", "path": "/list/1", "value": 2}]`)
+
+	if err := b.Merge(ops[2], ops[1]); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if b.Pending() != 2 {
+		t.Errorf("expected 2 pending operations, got %d", b.Pending())
+	}
+
+	if err := b.Merge(ops...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if b.Pending() != 0 {
+		t.Errorf("expected no pending operations, got %d", b.Pending())
+	}
+
+	if got := crdtJSON(t, b); !compareJSON(got, `{"list": [1, 2]}`) {
+		t.Errorf("unexpected document: %s", got)
+	}
+
+	if v := b.Version(); v["a"] != 3 {
+		t.Errorf("unexpected version: %v", v)
+	}
+
+	if n := len(b.Changes(VersionVector{"a": 1})); n != 2 {
+		t.Errorf("expected 2 changes, got %d", n)
+	}
+}
+
+func TestCRDTMergeWithGap(t *testing.T) {
+	r := newReplicas(t, `{"x": 0, "y": 0}`, "a", "b", "c")
+	a, b, c := r[0], r[1], r[2]
+
+	first := applyCRDT(t, a, `[{"op": "replace", "path": "/x", "value": 1}]`)
+	second := applyCRDT(t, a, `[{"op": "replace", "path": "/y", "value": 2}]`)
+
+	// The second change does not depend on the first one, but is held until
+	// it arrives, so that Version never covers a missing operation.
+	if err := b.Merge(second...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if b.Pending() != 1 || b.Version()["a"] != 0 || len(b.Changes(nil)) != 0 {
+		t.Errorf("expected the operation to be held, got %d pending, version %v", b.Pending(), b.Version())
+	}
+
+	if got := crdtJSON(t, b); !compareJSON(got, `{"x": 0, "y": 0}`) {
+		t.Errorf("unexpected document: %s", got)
+	}
+
+	// Catching up from b's version does not skip the first change.
+	if err := c.Merge(a.Changes(b.Version())...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if err := b.Merge(first...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if b.Pending() != 0 || b.Version()["a"] != a.Version()["a"] {
+		t.Errorf("expected all operations to be integrated, got %d pending, version %v", b.Pending(), b.Version())
+	}
+
+	for _, c := range []*CRDTDocument{b, c} {
+		if got := crdtJSON(t, c); !compareJSON(got, `{"x": 1, "y": 2}`) {
+			t.Errorf("unexpected document on replica %s: %s", c.Replica(), got)
+		}
+	}
+
+	err := b.Merge(CRDTOperation{ID: CRDTID{Clock: 3, Replica: "a"}, Prev: 3, Kind: "delete", Key: "x"})
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected an error for an operation following itself, got %v", err)
+	}
+}
+
+func TestCRDTOperationsRoundTrip(t *testing.T) {
+	r := newReplicas(t, `{"list": ["a"]}`, "a", "b")
+	a, b := r[0], r[1]
+
+	ops := applyCRDT(t, a, `[{"op": "add", "path": "/list/-", "value": {"b": true}}, {"op": "remove", "path": "/list/0"}]`)
+
+	buf, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatalf("unable to marshal operations: %s", err)
+	}
+
+	var decoded []CRDTOperation
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatalf("unable to unmarshal operations: %s", err)
+	}
+
+	if err := b.Merge(decoded...); err != nil {
+		t.Fatalf("unable to merge: %s", err)
+	}
+
+	if got := crdtJSON(t, b); !compareJSON(got, `{"list": [{"b": true}]}`) {
+		t.Errorf("unexpected document: %s", got)
+	}
+}
+
+func TestCRDTApplyFailure(t *testing.T) {
+	c := newReplicas(t, `{"foo": 1}`, "a")[0]
+
+	p, err := DecodePatch([]byte(`[{"op": "replace", "path": "/foo", "value": 2}, {"op": "test", "path": "/foo", "value": 1}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, err := c.Apply(p); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected a failed test, got %v", err)
+	}
+
+	if got := crdtJSON(t, c); !compareJSON(got, `{"foo": 1}`) {
+		t.Errorf("document changed after a failed patch: %s", got)
+	}
+}
+
+func TestCRDTDelta(t *testing.T) {
+	c := newReplicas(t, `{"foo": 1, "list": [1]}`, "a")[0]
+
+	applyCRDT(t, c, `[{"op": "replace", "path": "/foo", "value": 2}]`)
+	since := c.Version()
+	applyCRDT(t, c, `[{"op": "add", "path": "/list/0", "value": 0}, {"op": "add", "path": "/bar", "value": true}]`)
+
+	delta, err := c.Delta(since)
+	if err != nil {
+		t.Fatalf("unable to create delta: %s", err)
+	}
+
+	got, err := json.Marshal(delta)
+	if err != nil {
+		t.Fatalf("unable to marshal delta: %s", err)
+	}
+
+	expected := `[{"op": "add", "path": "/list/0", "value": 0}, {"op": "add", "path": "/bar", "value": true}]`
+	if !compareJSON(string(got), expected) {
+		t.Errorf("unexpected delta. Expected:\n%s\n\nActual:\n%s", reformatJSON(expected), reformatJSON(string(got)))
+	}
+}
+
+func TestCRDTMergeInvalid(t *testing.T) {
+	c := newReplicas(t, `{}`, "a")[0]
+
+	err := c.Merge(CRDTOperation{ID: CRDTID{Clock: 1, Replica: "b"}, Kind: "bogus"})
+	if err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+
+	err = c.Merge(CRDTOperation{ID: CRDTID{Clock: 1, Replica: "b"}, Kind: "insert", Value: json.RawMessage(`1`)})
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected an error inserting into an object, got %v", err)
+	}
+}
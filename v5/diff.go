@@ -0,0 +1,532 @@
+package jsonpatch
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// DiffOptions specifies options for calls to CreatePatchWithOptions.
+type DiffOptions struct {
+	// Preconditions prefixes each operation with "test" operations
+	// asserting the values it changes in original, as done by
+	// Patch.WithPreconditions.
+	// Default to false.
+	Preconditions bool
+}
+
+// CreatePatch creates an RFC 6902 patch that turns original into modified.
+// Both documents must be valid JSON. Object members are compared
+// recursively, and arrays are compared element by element, keeping the
+// longest common subsequence of elements in place. Arrays that differ in too
+// many elements to compare cheaply are replaced as a whole.
+func CreatePatch(original, modified []byte) (Patch, error) {
+	if !json.Valid(original) || !json.Valid(modified) {
+		return nil, ErrInvalid
+	}
+
+	a := newLazyNode(newRawMessage(original))
+	b := newLazyNode(newRawMessage(modified))
+
+	p := Patch{}
+	if err := diffNodes(&p, "", a, b); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// CreatePatchWithOptions is like CreatePatch, with the passed in DiffOptions.
+func CreatePatchWithOptions(original, modified []byte, options *DiffOptions) (Patch, error) {
+	p, err := CreatePatch(original, modified)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Preconditions {
+		return p.WithPreconditions(original)
+	}
+
+	return p, nil
+}
+
+const (
+	nodeScalar = iota
+	nodeObject
+	nodeArray
+)
+
+func nodeType(n *lazyNode) int {
+	switch {
+	case n == nil:
+		return nodeScalar
+	case n.which == eDoc:
+		return nodeObject
+	case n.which == eAry:
+		return nodeArray
+	case n.raw == nil:
+		return nodeScalar
+	}
+
+	switch n.nextByte() {
+	case '{':
+		return nodeObject
+	case '[':
+		return nodeArray
+	}
+
+	return nodeScalar
+}
+
+// nodeEqual reports whether two nodes are structurally equal, treating nil
+// nodes as null.
+func nodeEqual(a, b *lazyNode) bool {
+	if a.isNull() || b.isNull() {
+		return a.isNull() && b.isNull()
+	}
+
+	kind := nodeType(a)
+	if kind != nodeType(b) {
+		return false
+	}
+
+	switch kind {
+	case nodeObject:
+		ad, err := a.intoDoc(NewApplyOptions())
+		if err != nil {
+			return false
+		}
+
+		bd, err := b.intoDoc(NewApplyOptions())
+		if err != nil || len(ad.obj) != len(bd.obj) {
+			return false
+		}
+
+		for k, v := range ad.obj {
+			ov, ok := bd.obj[k]
+			if !ok || !nodeEqual(v, ov) {
+				return false
+			}
+		}
+
+		return true
+	case nodeArray:
+		aa, err := a.intoAry()
+		if err != nil {
+			return false
+		}
+
+		ba, err := b.intoAry()
+		if err != nil || len(aa.nodes) != len(ba.nodes) {
+			return false
+		}
+
+		for i, v := range aa.nodes {
+			if !nodeEqual(v, ba.nodes[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return a.equal(b)
+}
+
+func diffNodes(p *Patch, path string, a, b *lazyNode) error {
+	if nodeEqual(a, b) {
+		return nil
+	}
+
+	kind := nodeType(a)
+	if kind != nodeType(b) || kind == nodeScalar {
+		return appendOperation(p, "replace", path, b)
+	}
+
+	if kind == nodeObject {
+		return diffObjects(p, path, a, b)
+	}
+
+	return diffArrays(p, path, a, b)
+}
+
+func diffObjects(p *Patch, path string, a, b *lazyNode) error {
+	ad, err := a.intoDoc(NewApplyOptions())
+	if err != nil {
+		return err
+	}
+
+	bd, err := b.intoDoc(NewApplyOptions())
+	if err != nil {
+		return err
+	}
+
+	// Duplicate member names only count once, as the last value wins.
+	seen := map[string]bool{}
+
+	for _, k := range ad.keys {
+		if _, ok := bd.obj[k]; !ok && !seen[k] {
+			seen[k] = true
+			if err := appendOperation(p, "remove", path+"/"+encodePatchKey(k), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, k := range bd.keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		child := path + "/" + encodePatchKey(k)
+
+		av, ok := ad.obj[k]
+		if !ok {
+			if err := appendOperation(p, "add", child, bd.obj[k]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := diffNodes(p, child, av, bd.obj[k]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maxArrayDiffCost bounds the work done comparing two arrays, counted as the
+// combined length of the arrays times the number of elements removed and
+// inserted. Arrays that differ by more than that are replaced as a whole.
+const maxArrayDiffCost = 1 << 24
+
+func diffArrays(p *Patch, path string, a, b *lazyNode) error {
+	aa, err := a.intoAry()
+	if err != nil {
+		return err
+	}
+
+	ba, err := b.intoAry()
+	if err != nil {
+		return err
+	}
+
+	x, y := aa.nodes, ba.nodes
+
+	// Elements are compared by the ids of their canonical encoding, so that
+	// nested values are only walked once.
+	ids := map[string]int{}
+	xi, err := elementIDs(ids, x)
+	if err != nil {
+		return err
+	}
+
+	yi, err := elementIDs(ids, y)
+	if err != nil {
+		return err
+	}
+
+	// Common prefix and suffix are left untouched.
+	prefix := 0
+	for prefix < len(xi) && prefix < len(yi) && xi[prefix] == yi[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(xi)-prefix && suffix < len(yi)-prefix && xi[len(xi)-1-suffix] == yi[len(yi)-1-suffix] {
+		suffix++
+	}
+
+	x, y = x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+	xi, yi = xi[prefix:len(xi)-suffix], yi[prefix:len(yi)-suffix]
+
+	var matches [][2]int
+	if len(x) > 0 && len(y) > 0 {
+		maxD := maxArrayDiffCost / (len(x) + len(y))
+		if !commonElements(&matches, xi, yi, 0, 0, maxD) {
+			return appendOperation(p, "replace", path, b)
+		}
+	}
+
+	// The elements between two common elements are paired up as changes in
+	// place, and the rest removed or inserted.
+	matches = append(matches, [2]int{len(x), len(y)})
+
+	idx := prefix
+	i, j := 0, 0
+
+	for _, m := range matches {
+		removed, added := x[i:m[0]], y[j:m[1]]
+
+		n := len(removed)
+		if len(added) < n {
+			n = len(added)
+		}
+
+		for k := 0; k < n; k++ {
+			if err := diffNodes(p, path+"/"+strconv.Itoa(idx), removed[k], added[k]); err != nil {
+				return err
+			}
+			idx++
+		}
+
+		for range removed[n:] {
+			if err := appendOperation(p, "remove", path+"/"+strconv.Itoa(idx), nil); err != nil {
+				return err
+			}
+		}
+
+		for _, v := range added[n:] {
+			if err := appendOperation(p, "add", path+"/"+strconv.Itoa(idx), v); err != nil {
+				return err
+			}
+			idx++
+		}
+
+		// Step over the common element.
+		i, j = m[0]+1, m[1]+1
+		idx++
+	}
+
+	return nil
+}
+
+// elementIDs returns an id for each node, equal for two nodes exactly when
+// nodeEqual reports them equal.
+func elementIDs(ids map[string]int, nodes []*lazyNode) ([]int, error) {
+	out := make([]int, len(nodes))
+
+	for i, n := range nodes {
+		buf, err := appendCanonical(nil, n)
+		if err != nil {
+			return nil, err
+		}
+
+		id, ok := ids[string(buf)]
+		if !ok {
+			id = len(ids)
+			ids[string(buf)] = id
+		}
+
+		out[i] = id
+	}
+
+	return out, nil
+}
+
+// appendCanonical appends an encoding of n to buf with sorted object members,
+// the last of duplicate members, and strings with their escapes normalized.
+func appendCanonical(buf []byte, n *lazyNode) ([]byte, error) {
+	if n.isNull() {
+		return append(buf, rawJSONNull...), nil
+	}
+
+	switch nodeType(n) {
+	case nodeObject:
+		d, err := n.intoDoc(NewApplyOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(d.obj))
+		for k := range d.obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf = append(buf, '{')
+		for i, k := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+
+			key, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+
+			buf = append(append(buf, key...), ':')
+			if buf, err = appendCanonical(buf, d.obj[k]); err != nil {
+				return nil, err
+			}
+		}
+
+		return append(buf, '}'), nil
+	case nodeArray:
+		ary, err := n.intoAry()
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, '[')
+		for i, v := range ary.nodes {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+
+			if buf, err = appendCanonical(buf, v); err != nil {
+				return nil, err
+			}
+		}
+
+		return append(buf, ']'), nil
+	}
+
+	c := n.compact()
+	if len(c) > 0 && c[0] == '"' {
+		var s string
+		if err := json.UnmarshalValid(c, &s); err != nil {
+			return nil, err
+		}
+
+		enc, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(buf, enc...), nil
+	}
+
+	return append(buf, c...), nil
+}
+
+// commonElements appends to matches the positions, offset by xo and yo, of a
+// longest common subsequence of x and y, found with Myers' linear space
+// algorithm. It reports false, without appending, if x and y differ by more
+// than maxD removed and inserted elements.
+func commonElements(matches *[][2]int, x, y []int, xo, yo, maxD int) bool {
+	if len(x) == 0 || len(y) == 0 {
+		return true
+	}
+
+	d, sx, sy, ex, ey, ok := middleSnake(x, y, maxD)
+	if !ok {
+		return false
+	}
+
+	if d <= 1 {
+		// At most one element was removed or inserted, so the shorter of x and
+		// y is a subsequence of the other.
+		i, j := 0, 0
+		for i < len(x) && j < len(y) {
+			switch {
+			case x[i] == y[j]:
+				*matches = append(*matches, [2]int{xo + i, yo + j})
+				i++
+				j++
+			case len(x) > len(y):
+				i++
+			default:
+				j++
+			}
+		}
+
+		return true
+	}
+
+	// The halves on either side of the middle snake each differ by fewer
+	// elements than the whole, so they can not exceed the bound.
+	commonElements(matches, x[:sx], y[:sy], xo, yo, d)
+	for k := 0; k < ex-sx; k++ {
+		*matches = append(*matches, [2]int{xo + sx + k, yo + sy + k})
+	}
+	commonElements(matches, x[ex:], y[ey:], xo+ex, yo+ey, d)
+
+	return true
+}
+
+// middleSnake returns the number d of elements removed and inserted by a
+// shortest edit of x into y, and the start and end of the run of common
+// elements in the middle of that edit. It reports false if d exceeds maxD.
+func middleSnake(x, y []int, maxD int) (d, sx, sy, ex, ey int, ok bool) {
+	n, m := len(x), len(y)
+	delta := n - m
+	odd := delta%2 != 0
+
+	limit := (n + m + 1) / 2
+	off := limit + 1
+
+	// forward[off+k] is the furthest x reached on diagonal k from the start,
+	// and backward[off+k] the furthest reached on diagonal k from the end.
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+
+	for h := 0; h <= limit; h++ {
+		if 2*h-1 > maxD {
+			return 0, 0, 0, 0, 0, false
+		}
+
+		for k := -h; k <= h; k += 2 {
+			var i int
+			if k == -h || (k != h && forward[off+k-1] < forward[off+k+1]) {
+				i = forward[off+k+1]
+			} else {
+				i = forward[off+k-1] + 1
+			}
+
+			j := i - k
+			si, sj := i, j
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			forward[off+k] = i
+
+			if odd && delta-k >= -(h-1) && delta-k <= h-1 && i+backward[off+delta-k] >= n {
+				return 2*h - 1, si, sj, i, j, true
+			}
+		}
+
+		if 2*h > maxD {
+			return 0, 0, 0, 0, 0, false
+		}
+
+		for k := -h; k <= h; k += 2 {
+			var i int
+			if k == -h || (k != h && backward[off+k-1] < backward[off+k+1]) {
+				i = backward[off+k+1]
+			} else {
+				i = backward[off+k-1] + 1
+			}
+
+			j := i - k
+			si, sj := i, j
+			for i < n && j < m && x[n-1-i] == y[m-1-j] {
+				i++
+				j++
+			}
+			backward[off+k] = i
+
+			if !odd && delta-k >= -h && delta-k <= h && i+forward[off+delta-k] >= n {
+				return 2 * h, n - i, m - j, n - si, m - sj, true
+			}
+		}
+	}
+
+	return 0, 0, 0, 0, 0, false
+}
+
+func appendOperation(p *Patch, kind, path string, value *lazyNode) error {
+	op := Operation{
+		"op":   rawString(kind),
+		"path": rawString(path),
+	}
+
+	if kind != "remove" {
+		if value == nil {
+			op["value"] = newRawMessage(rawJSONNull)
+		} else {
+			buf, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			op["value"] = newRawMessage(buf)
+		}
+	}
+
+	*p = append(*p, op)
+	return nil
+}
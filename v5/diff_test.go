@@ -0,0 +1,261 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+var CreatePatchCases = []struct {
+	original, modified string
+	result             string
+}{
+	{
+		`{"a": 1, "b": 2}`,
+		`{"a": 1, "b": 2}`,
+		`[]`,
+	},
+	{
+		`{"a": 1, "b": 2}`,
+		`{"a": 3, "c": 4}`,
+		`[{"op": "remove", "path": "/b"}, {"op": "replace", "path": "/a", "value": 3}, {"op": "add", "path": "/c", "value": 4}]`,
+	},
+	{
+		`{"a": {"b": {"c": 1}}}`,
+		`{"a": {"b": {"c": 2}}}`,
+		`[{"op": "replace", "path": "/a/b/c", "value": 2}]`,
+	},
+	{
+		`{"a/b": 1, "c~d": 2}`,
+		`{"a/b": 3, "c~d": 2}`,
+		`[{"op": "replace", "path": "/a~1b", "value": 3}]`,
+	},
+	{
+		`{"list": [1, 2, 3, 4]}`,
+		`{"list": [1, 3, 4, 5]}`,
+		`[{"op": "remove", "path": "/list/1"}, {"op": "add", "path": "/list/3", "value": 5}]`,
+	},
+	{
+		`{"list": [1, 2, 3]}`,
+		`{"list": [1, 9, 3]}`,
+		`[{"op": "replace", "path": "/list/1", "value": 9}]`,
+	},
+	{
+		`{"list": [{"a": 1}, {"b": 2}]}`,
+		`{"list": [{"a": 1}, {"b": 3}]}`,
+		`[{"op": "replace", "path": "/list/1/b", "value": 3}]`,
+	},
+	{
+		`{"list": [1, 2]}`,
+		`{"list": {"0": 1}}`,
+		`[{"op": "replace", "path": "/list", "value": {"0": 1}}]`,
+	},
+	{
+		`[1, 2, 3]`,
+		`[0, 1, 2, 3]`,
+		`[{"op": "add", "path": "/0", "value": 0}]`,
+	},
+	{
+		`{"a": null, "b": [null, 1]}`,
+		`{"a": 1, "b": [null]}`,
+		`[{"op": "replace", "path": "/a", "value": 1}, {"op": "remove", "path": "/b/1"}]`,
+	},
+}
+
+func TestCreatePatch(t *testing.T) {
+	for i, c := range CreatePatchCases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p, err := CreatePatch([]byte(c.original), []byte(c.modified))
+			if err != nil {
+				t.Fatalf("unable to create patch: %s", err)
+			}
+
+			got, err := json.Marshal(p)
+			if err != nil {
+				t.Fatalf("unable to marshal patch: %s", err)
+			}
+
+			if !compareJSON(string(got), c.result) {
+				t.Errorf("unexpected patch. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(got)))
+			}
+
+			out, err := p.Apply([]byte(c.original))
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(string(out), c.modified) {
+				t.Errorf("patch did not apply. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.modified), reformatJSON(string(out)))
+			}
+		})
+	}
+}
+
+func TestCreatePatchInvalid(t *testing.T) {
+	if _, err := CreatePatch([]byte(`{`), []byte(`{}`)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestNodeEqualInMemoryContainers(t *testing.T) {
+	raw := func(s string) *lazyNode {
+		r := json.RawMessage(s)
+		return newLazyNode(&r)
+	}
+
+	// Containers built in memory, such as the root of a document, have no
+	// raw value, and must not be taken for null.
+	object := &lazyNode{doc: &partialDoc{keys: []string{"a"}, obj: map[string]*lazyNode{"a": raw(`1`)}, opts: NewApplyOptions()}, which: eDoc}
+	array := &lazyNode{ary: &partialArray{nodes: []*lazyNode{raw(`1`)}}, which: eAry}
+	empty := &lazyNode{doc: &partialDoc{obj: map[string]*lazyNode{}, opts: NewApplyOptions()}, which: eDoc}
+
+	cases := []struct {
+		a, b  *lazyNode
+		equal bool
+	}{
+		{object, raw(`{"a": 1}`), true},
+		{object, raw(`{"a": 2}`), false},
+		{object, raw(`null`), false},
+		{object, nil, false},
+		{object, empty, false},
+		{array, raw(`[1]`), true},
+		{array, raw(`null`), false},
+		{empty, nil, false},
+		{raw(`null`), nil, true},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			if nodeEqual(c.a, c.b) != c.equal || nodeEqual(c.b, c.a) != c.equal {
+				t.Errorf("expected nodeEqual to be %v", c.equal)
+			}
+		})
+	}
+}
+
+func TestCommonElements(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		x := make([]int, rnd.Intn(30))
+		for k := range x {
+			x[k] = rnd.Intn(4)
+		}
+
+		y := make([]int, rnd.Intn(30))
+		for k := range y {
+			y[k] = rnd.Intn(4)
+		}
+
+		// lcs[i][j] is the length of the longest common subsequence of x[i:]
+		// and y[j:].
+		lcs := make([][]int, len(x)+1)
+		for k := range lcs {
+			lcs[k] = make([]int, len(y)+1)
+		}
+
+		for a := len(x) - 1; a >= 0; a-- {
+			for b := len(y) - 1; b >= 0; b-- {
+				switch {
+				case x[a] == y[b]:
+					lcs[a][b] = lcs[a+1][b+1] + 1
+				case lcs[a+1][b] >= lcs[a][b+1]:
+					lcs[a][b] = lcs[a+1][b]
+				default:
+					lcs[a][b] = lcs[a][b+1]
+				}
+			}
+		}
+
+		var matches [][2]int
+		if !commonElements(&matches, x, y, 0, 0, len(x)+len(y)) {
+			t.Fatalf("case %d: unexpected failure for %v and %v", i, x, y)
+		}
+
+		if len(matches) != lcs[0][0] {
+			t.Fatalf("case %d: expected %d common elements of %v and %v, got %v", i, lcs[0][0], x, y, matches)
+		}
+
+		for k, m := range matches {
+			if x[m[0]] != y[m[1]] || (k > 0 && (m[0] <= matches[k-1][0] || m[1] <= matches[k-1][1])) {
+				t.Fatalf("case %d: invalid common elements of %v and %v: %v", i, x, y, matches)
+			}
+		}
+	}
+}
+
+func TestCreatePatchLargeArrays(t *testing.T) {
+	array := func(n int, f func(i int) int) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(`{"list": [`)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.Itoa(f(i)))
+		}
+		buf.WriteString(`]}`)
+		return buf.Bytes()
+	}
+
+	original := array(8000, func(i int) int { return i })
+
+	cases := []struct {
+		modified []byte
+		ops      int
+	}{
+		// Every element differs, so the array is replaced as a whole.
+		{array(8000, func(i int) int { return -i - 1 }), 1},
+		// A few scattered changes are still found element by element.
+		{array(8000, func(i int) int {
+			if i%2000 == 1000 {
+				return -i
+			}
+			return i
+		}), 4},
+		{array(7990, func(i int) int {
+			if i >= 4000 {
+				return i + 10
+			}
+			return i
+		}), 10},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			p, err := CreatePatch(original, c.modified)
+			if err != nil {
+				t.Fatalf("unable to create patch: %s", err)
+			}
+
+			runtime.ReadMemStats(&after)
+
+			if len(p) != c.ops {
+				t.Errorf("expected %d operations, got %d", c.ops, len(p))
+			}
+
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+				t.Errorf("expected CreatePatch to allocate at most 64 MiB, got %d bytes", alloc)
+			}
+
+			out, err := p.Apply(original)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(string(out), string(c.modified)) {
+				t.Error("patch did not turn original into modified")
+			}
+		})
+	}
+}
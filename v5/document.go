@@ -0,0 +1,272 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// Document is a JSON document safe for concurrent use. Patches are applied
+// one at a time to a copy of the document, which then replaces it
+// atomically, so that readers never see a patch half applied and never wait
+// for one.
+//
+// The nodes of a document decode themselves lazily, even when only read,
+// which makes sharing them between goroutines unsafe. The snapshots of a
+// Document are decoded in full before they are published, and are only read
+// afterwards.
+type Document struct {
+	options *ApplyOptions
+
+	// mu serializes the patches.
+	mu      sync.Mutex
+	current atomic.Value
+}
+
+// DocumentSnapshot is an immutable version of a Document.
+type DocumentSnapshot struct {
+	raw     []byte
+	root    *lazyNode
+	version uint64
+	options *ApplyOptions
+}
+
+// NewDocument returns a Document for doc, applying patches with the passed
+// in ApplyOptions.
+func NewDocument(doc []byte, options *ApplyOptions) (*Document, error) {
+	if !json.Valid(doc) {
+		return nil, ErrInvalid
+	}
+
+	pd, err := newContainer(doc, options)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newDocumentSnapshot(pd, 0, options)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Document{options: options}
+	d.current.Store(s)
+
+	return d, nil
+}
+
+// Snapshot returns the current version of the document. Reading from a
+// snapshot gives consistent results while patches are applied.
+func (d *Document) Snapshot() *DocumentSnapshot {
+	return d.current.Load().(*DocumentSnapshot)
+}
+
+// Bytes returns the current document.
+func (d *Document) Bytes() []byte {
+	return d.Snapshot().Bytes()
+}
+
+// Get returns the value at path in the current document.
+func (d *Document) Get(path string) ([]byte, error) {
+	return d.Snapshot().Get(path)
+}
+
+// Test checks that the value at path in the current document equals value,
+// as a "test" operation does.
+func (d *Document) Test(path string, value []byte) error {
+	return d.Snapshot().Test(path, value)
+}
+
+// Apply applies the patch to the document atomically, and returns the new
+// snapshot. The document is left unchanged when the patch fails.
+func (d *Document) Apply(p Patch) (*DocumentSnapshot, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := d.Snapshot()
+
+	pd, err := newContainer(current.raw, d.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.applyTo(&pd, d.options); err != nil {
+		return nil, err
+	}
+
+	next, err := newDocumentSnapshot(pd, current.version+1, d.options)
+	if err != nil {
+		return nil, err
+	}
+
+	d.current.Store(next)
+
+	return next, nil
+}
+
+func newDocumentSnapshot(pd container, version uint64, options *ApplyOptions) (*DocumentSnapshot, error) {
+	raw, err := json.MarshalEscaped(pd, options.EscapeHTML)
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot is decoded again from its own bytes, so that it shares
+	// nothing with the document the patch was applied to.
+	root := newLazyNode(newRawMessage(raw))
+	if err := decodeAll(root, options); err != nil {
+		return nil, err
+	}
+
+	return &DocumentSnapshot{raw: raw, root: root, version: version, options: options}, nil
+}
+
+// decodeAll decodes all the objects and arrays of a node.
+func decodeAll(n *lazyNode, options *ApplyOptions) error {
+	switch nodeType(n) {
+	case nodeObject:
+		doc, err := n.intoDoc(options)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range doc.obj {
+			if v == nil {
+				continue
+			}
+			if err := decodeAll(v, options); err != nil {
+				return err
+			}
+		}
+	case nodeArray:
+		ary, err := n.intoAry()
+		if err != nil {
+			return err
+		}
+
+		for _, v := range ary.nodes {
+			if v == nil {
+				continue
+			}
+			if err := decodeAll(v, options); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Version returns the number of patches applied to the document before
+// this snapshot.
+func (s *DocumentSnapshot) Version() uint64 {
+	return s.version
+}
+
+// Bytes returns the document.
+func (s *DocumentSnapshot) Bytes() []byte {
+	return append([]byte(nil), s.raw...)
+}
+
+// Get returns the value at path.
+func (s *DocumentSnapshot) Get(path string) ([]byte, error) {
+	node, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if node == nil {
+		return append([]byte(nil), rawJSONNull...), nil
+	}
+
+	return json.Marshal(node)
+}
+
+// Test checks that the value at path equals value, as a "test" operation
+// does. It returns an error wrapping ErrTestFailed when it does not.
+func (s *DocumentSnapshot) Test(path string, value []byte) error {
+	if !json.Valid(value) {
+		return ErrInvalid
+	}
+
+	node, err := s.lookup(path)
+	if err != nil {
+		return err
+	}
+
+	want := newLazyNode(newRawMessage(value))
+
+	if node == nil {
+		if want.isNull() {
+			return nil
+		}
+		return fmt.Errorf("testing value %s failed: %w", path, ErrTestFailed)
+	}
+
+	// Comparing decodes the nodes, so a private copy of the value is
+	// compared.
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	if newLazyNode(newRawMessage(data)).equal(want) {
+		return nil
+	}
+
+	return fmt.Errorf("testing value %s failed: %w", path, ErrTestFailed)
+}
+
+// lookup finds the node at path without decoding anything. A nil node stands
+// for null.
+func (s *DocumentSnapshot) lookup(path string) (*lazyNode, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	node := s.root
+
+	for i, tok := range tokens {
+		if node == nil {
+			return nil, fmt.Errorf("unable to get %s: %s is null: %w", path, joinPointer(tokens[:i]), ErrMissing)
+		}
+
+		switch node.which {
+		case eDoc:
+			v, ok := node.doc.obj[tok]
+			if !ok {
+				return nil, fmt.Errorf("unable to get %s: %w", path, ErrMissing)
+			}
+			node = v
+		case eAry:
+			idx, ok := s.index(tok, len(node.ary.nodes))
+			if !ok {
+				return nil, fmt.Errorf("unable to get %s: %w", path, ErrInvalidIndex)
+			}
+			node = node.ary.nodes[idx]
+		default:
+			return nil, fmt.Errorf("unable to get %s: %s is not an object or array: %w", path, joinPointer(tokens[:i]), ErrMissing)
+		}
+	}
+
+	return node, nil
+}
+
+func (s *DocumentSnapshot) index(tok string, n int) (int, bool) {
+	if idx, ok := arrayIndex(tok); ok {
+		return idx, idx < n
+	}
+
+	if !s.options.SupportNegativeIndices || !strings.HasPrefix(tok, "-") {
+		return 0, false
+	}
+
+	idx, ok := arrayIndex(tok[1:])
+	if !ok || idx > n || idx == 0 {
+		return 0, false
+	}
+
+	return n - idx, true
+}
@@ -0,0 +1,156 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	d, err := NewDocument([]byte(`{"a": {"b": [1, {"c": null}]}, "s": "x"}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create document: %s", err)
+	}
+
+	cases := []struct {
+		path  string
+		value string
+		err   error
+	}{
+		{"", `{"a": {"b": [1, {"c": null}]}, "s": "x"}`, nil},
+		{"/a/b/0", `1`, nil},
+		{"/a/b/-1", `{"c": null}`, nil},
+		{"/a/b/1/c", `null`, nil},
+		{"/s", `"x"`, nil},
+		{"/a/x", ``, ErrMissing},
+		{"/a/b/2", ``, ErrInvalidIndex},
+		{"/a/b/1/c/d", ``, ErrMissing},
+		{"/s/0", ``, ErrMissing},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			got, err := d.Get(c.path)
+
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("expected %v, got %v", c.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unable to get %s: %s", c.path, err)
+			}
+
+			if !compareJSON(string(got), c.value) {
+				t.Errorf("expected %s, got %s", c.value, got)
+			}
+
+			if err := d.Test(c.path, []byte(c.value)); err != nil {
+				t.Errorf("unexpected test failure: %s", err)
+			}
+		})
+	}
+
+	if err := d.Test("/s", []byte(`"y"`)); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected ErrTestFailed, got %v", err)
+	}
+
+	before := d.Snapshot()
+
+	p, _ := DecodePatch([]byte(`[{"op": "replace", "path": "/s", "value": "y"}, {"op": "remove", "path": "/a/b/9"}]`))
+	if _, err := d.Apply(p); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("expected ErrInvalidIndex, got %v", err)
+	}
+
+	p, _ = DecodePatch([]byte(`[{"op": "replace", "path": "/s", "value": "y"}]`))
+	after, err := d.Apply(p)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if after.Version() != 1 || d.Snapshot() != after {
+		t.Errorf("unexpected snapshot version %d", after.Version())
+	}
+
+	if err := before.Test("/s", []byte(`"x"`)); err != nil {
+		t.Errorf("expected the old snapshot to be unchanged: %s", err)
+	}
+
+	if !compareJSON(string(d.Bytes()), `{"a": {"b": [1, {"c": null}]}, "s": "y"}`) {
+		t.Errorf("unexpected document %s", d.Bytes())
+	}
+}
+
+func TestDocumentConcurrency(t *testing.T) {
+	d, err := NewDocument([]byte(`{"n": 0, "m": 0, "list": [{"v": 1}, {"v": 2}], "obj": {"k": "v"}}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create document: %s", err)
+	}
+
+	const writes = 100
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				s := d.Snapshot()
+
+				// Both members are changed by the same patch, so they
+				// must agree.
+				n, err := s.Get("/n")
+				if err != nil {
+					t.Errorf("unable to get /n: %s", err)
+					return
+				}
+
+				if err := s.Test("/m", n); err != nil {
+					t.Errorf("snapshot %d has /n %s and a different /m: %s", s.Version(), n, err)
+					return
+				}
+
+				if err := s.Test("/list/1", []byte(`{"v": 2}`)); err != nil {
+					t.Errorf("unexpected test failure: %s", err)
+					return
+				}
+
+				if err := s.Test("/obj", []byte(`{"k": "v"}`)); err != nil {
+					t.Errorf("unexpected test failure: %s", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= writes; i++ {
+		p, _ := DecodePatch([]byte(fmt.Sprintf(`[
+			{"op": "test", "path": "/list/0", "value": {"v": 1}},
+			{"op": "replace", "path": "/n", "value": %d},
+			{"op": "replace", "path": "/m", "value": %d}
+		]`, i, i)))
+
+		if _, err := d.Apply(p); err != nil {
+			t.Fatalf("unable to apply patch: %s", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if v := d.Snapshot().Version(); v != writes {
+		t.Errorf("expected version %d, got %d", writes, v)
+	}
+}
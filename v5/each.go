@@ -0,0 +1,95 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// ErrLengthMismatch is returned by CreatePatchEach when the arrays of
+// documents do not have the same length.
+var ErrLengthMismatch = errors.New("arrays of documents differ in length")
+
+// PatchResult is a patch created for one pair of documents by
+// CreatePatchEach.
+type PatchResult struct {
+	// Index is the position of the documents in their arrays.
+	Index int
+	// Patch turns the original document into the modified one, unless Err
+	// is set.
+	Patch Patch
+	// Err is the error creating the patch.
+	Err error
+}
+
+// ApplyEach applies the patch to each element of docs, a JSON array of
+// documents, as ApplyWithOptions does. It returns a result for each
+// element, in order, so that the elements the patch does not apply to do
+// not fail the others. An error is returned if the patch is invalid or
+// exceeds the limits of options, or if docs is not an array.
+func ApplyEach(p Patch, docs []byte, options *ApplyOptions) ([]BatchResult, error) {
+	// The patch is the same for all the elements, so it is only validated
+	// and checked against the limits once.
+	o := *options
+	if err := validatePatch(p, &o); err != nil {
+		return nil, err
+	}
+	if err := checkPatchLimits(p, &o); err != nil {
+		return nil, err
+	}
+	o.patchChecked = true
+
+	elements := []json.RawMessage{}
+
+	if !json.Valid(docs) {
+		return nil, ErrBadJSONDoc
+	}
+
+	if err := unmarshal(docs, &elements); err != nil {
+		return nil, ErrBadJSONDoc
+	}
+
+	results := make([]BatchResult, len(elements))
+	for i, doc := range elements {
+		out, err := p.ApplyWithOptions(doc, &o)
+		results[i] = BatchResult{Index: i, Doc: out, Err: err}
+	}
+
+	return results, nil
+}
+
+// CreatePatchEach creates an RFC 6902 patch for each pair of elements of
+// originals and modifieds, two JSON arrays of documents of the same length,
+// as CreateMergePatch does for merge patches. It returns a result for each
+// pair, in order. An error wrapping ErrLengthMismatch is returned if the
+// arrays differ in length.
+func CreatePatchEach(originals, modifieds []byte) ([]PatchResult, error) {
+	originalDocs := []json.RawMessage{}
+	modifiedDocs := []json.RawMessage{}
+
+	if !json.Valid(originals) || !json.Valid(modifieds) {
+		return nil, ErrBadJSONDoc
+	}
+
+	if err := unmarshal(originals, &originalDocs); err != nil {
+		return nil, ErrBadJSONDoc
+	}
+
+	if err := unmarshal(modifieds, &modifiedDocs); err != nil {
+		return nil, ErrBadJSONDoc
+	}
+
+	if len(originalDocs) != len(modifiedDocs) {
+		return nil, fmt.Errorf("unable to pair the documents, got %d original and %d modified: %w",
+			len(originalDocs), len(modifiedDocs), ErrLengthMismatch)
+	}
+
+	results := make([]PatchResult, len(originalDocs))
+	for i := range originalDocs {
+		p, err := CreatePatch(originalDocs[i], modifiedDocs[i])
+		results[i] = PatchResult{Index: i, Patch: p, Err: err}
+	}
+
+	return results, nil
+}
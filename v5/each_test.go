@@ -0,0 +1,152 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+func TestApplyEach(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "replace", "path": "/status", "value": "archived"}, {"op": "remove", "path": "/tmp"}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	docs := `[
+		{"id": 1, "status": "active", "tmp": true},
+		{"id": 2, "tmp": true},
+		{"id": 3, "status": "active", "tmp": [1, 2]},
+		[1, 2],
+		{"id": 5, "status": null}
+	]`
+
+	cases := []struct {
+		doc string
+		err error
+	}{
+		{`{"id": 1, "status": "archived"}`, nil},
+		{``, ErrMissing},
+		{`{"id": 3, "status": "archived"}`, nil},
+		{``, ErrInvalid},
+		{``, ErrMissing},
+	}
+
+	results, err := ApplyEach(p, []byte(docs), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if len(results) != len(cases) {
+		t.Fatalf("expected %d results, got %d", len(cases), len(results))
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			r := results[i]
+
+			if r.Index != i {
+				t.Errorf("expected index %d, got %d", i, r.Index)
+			}
+
+			if c.err != nil {
+				if r.Err == nil {
+					t.Errorf("expected an error, got %s", r.Doc)
+				}
+				return
+			}
+
+			if r.Err != nil {
+				t.Fatalf("unable to apply patch: %s", r.Err)
+			}
+
+			if !compareJSON(string(r.Doc), c.doc) {
+				t.Errorf("expected %s, got %s", c.doc, r.Doc)
+			}
+		})
+	}
+
+	if _, err := ApplyEach(p, []byte(`{"id": 1}`), NewApplyOptions()); !errors.Is(err, ErrBadJSONDoc) {
+		t.Errorf("expected ErrBadJSONDoc, got %v", err)
+	}
+
+	options := NewApplyOptions()
+	options.MaxOperations = 1
+
+	var e *OperationCountError
+	if _, err := ApplyEach(p, []byte(docs), options); !errors.As(err, &e) {
+		t.Errorf("expected OperationCountError, got %v", err)
+	}
+
+	// An invalid patch fails the whole call, rather than each element.
+	invalid := Patch{{"op": rawString("add"), "path": rawString("/a")}}
+
+	if results, err := ApplyEach(invalid, []byte(docs), NewApplyOptions()); err == nil || results != nil {
+		t.Errorf("expected an error for the invalid patch, got %v, %v", results, err)
+	}
+}
+
+func TestCreatePatchEach(t *testing.T) {
+	originals := `[{"a": 1, "b": [1, 2]}, {"a": 1}, [1, 2, 3], "x"]`
+	modifieds := `[{"a": 2, "b": [1, 2]}, {"a": 1}, [1, 3], {"x": true}]`
+
+	expected := []string{
+		`[{"op": "replace", "path": "/a", "value": 2}]`,
+		`[]`,
+		`[{"op": "remove", "path": "/1"}]`,
+		`[{"op": "replace", "path": "", "value": {"x": true}}]`,
+	}
+
+	results, err := CreatePatchEach([]byte(originals), []byte(modifieds))
+	if err != nil {
+		t.Fatalf("unable to create patches: %s", err)
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+
+	for i, e := range expected {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			r := results[i]
+
+			if r.Index != i || r.Err != nil {
+				t.Fatalf("unexpected result %d: %v", r.Index, r.Err)
+			}
+
+			got, err := json.Marshal(r.Patch)
+			if err != nil {
+				t.Fatalf("unable to marshal patch: %s", err)
+			}
+
+			if !compareJSON(string(got), e) {
+				t.Errorf("expected %s, got %s", e, got)
+			}
+		})
+	}
+
+	bad := []struct {
+		originals, modifieds string
+		err                  error
+	}{
+		{`[{}]`, `[{}, {}]`, ErrLengthMismatch},
+		{`[{}, {}]`, `[]`, ErrLengthMismatch},
+		{`{}`, `[{}]`, ErrBadJSONDoc},
+		{`[{}]`, `[{]`, ErrBadJSONDoc},
+	}
+
+	for i, c := range bad {
+		t.Run(fmt.Sprintf("bad case %d", i), func(t *testing.T) {
+			if _, err := CreatePatchEach([]byte(c.originals), []byte(c.modifieds)); !errors.Is(err, c.err) {
+				t.Errorf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+
+	_, err = CreatePatchEach([]byte(`[{}]`), []byte(`[{}, {}]`))
+	if err == nil || !strings.Contains(err.Error(), "1 original and 2 modified") {
+		t.Errorf("expected the lengths in the error, got %v", err)
+	}
+}
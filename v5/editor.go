@@ -0,0 +1,437 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// ErrNoHistory is returned by Editor.Undo and Editor.Redo when there is
+// nothing to undo or redo.
+var ErrNoHistory = errors.New("no history")
+
+// Editor applies patches to a document while keeping undo and redo
+// histories. Each entry of the histories holds the patches undoing and
+// redoing a change, computed by comparing the part of the document the
+// change touched before and after it.
+//
+// An Editor is not safe for concurrent use.
+type Editor struct {
+	// MaxHistory limits the number of changes that can be undone. The
+	// oldest ones are forgotten first.
+	// Default to 0, which means no limit.
+	MaxHistory int
+
+	doc     container
+	options *ApplyOptions
+
+	undo    []editorChange
+	redo    []editorChange
+	pending []editorChange
+	inTx    bool
+}
+
+type editorChange struct {
+	Undo Patch `json:"undo"`
+	Redo Patch `json:"redo"`
+}
+
+// NewEditor returns an Editor for doc, applying patches with the passed in
+// ApplyOptions.
+func NewEditor(doc []byte, options *ApplyOptions) (*Editor, error) {
+	if err := checkDocumentSize(doc, options); err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(doc) {
+		return nil, ErrInvalid
+	}
+
+	if err := checkNestingDepth(doc, options); err != nil {
+		return nil, err
+	}
+
+	pd, err := newContainer(doc, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Editor{doc: pd, options: options}, nil
+}
+
+// Document returns the current document.
+func (e *Editor) Document() ([]byte, error) {
+	return json.MarshalEscaped(e.doc, e.options.EscapeHTML)
+}
+
+// CanUndo reports whether there is a change to undo.
+func (e *Editor) CanUndo() bool {
+	return len(e.undo) > 0
+}
+
+// CanRedo reports whether there is an undone change to redo.
+func (e *Editor) CanRedo() bool {
+	return len(e.redo) > 0
+}
+
+// Apply applies the patch to the document, and records it in the undo
+// history, forgetting the changes that were undone. The document is left
+// unchanged when the patch fails.
+func (e *Editor) Apply(p Patch) error {
+	change, changed, err := e.change(p, true)
+	if err != nil || !changed {
+		return err
+	}
+
+	if e.inTx {
+		e.pending = append(e.pending, change)
+		return nil
+	}
+
+	e.push(change)
+
+	return nil
+}
+
+// Begin starts a transaction: the patches applied until Commit are undone
+// and redone as a single change.
+func (e *Editor) Begin() error {
+	if e.inTx {
+		return fmt.Errorf("transaction already started: %w", ErrInvalid)
+	}
+
+	e.inTx = true
+
+	return nil
+}
+
+// Commit ends the transaction started by Begin, and records its patches as
+// a single change.
+func (e *Editor) Commit() error {
+	if !e.inTx {
+		return fmt.Errorf("no transaction started: %w", ErrInvalid)
+	}
+
+	e.inTx = false
+
+	if len(e.pending) == 0 {
+		return nil
+	}
+
+	var change editorChange
+	for i := range e.pending {
+		change.Redo = append(change.Redo, e.pending[i].Redo...)
+		change.Undo = append(change.Undo, e.pending[len(e.pending)-1-i].Undo...)
+	}
+
+	e.pending = nil
+	e.push(change)
+
+	return nil
+}
+
+// Rollback ends the transaction started by Begin, undoing its patches.
+func (e *Editor) Rollback() error {
+	if !e.inTx {
+		return fmt.Errorf("no transaction started: %w", ErrInvalid)
+	}
+
+	for len(e.pending) > 0 {
+		last := e.pending[len(e.pending)-1]
+
+		if _, _, err := e.change(last.Undo, false); err != nil {
+			return err
+		}
+
+		e.pending = e.pending[:len(e.pending)-1]
+	}
+
+	e.inTx = false
+
+	return nil
+}
+
+// Undo reverts the last change, and makes it available to Redo. It fails
+// with ErrNoHistory when there is nothing to undo.
+func (e *Editor) Undo() error {
+	if e.inTx {
+		return fmt.Errorf("transaction in progress: %w", ErrInvalid)
+	}
+
+	if len(e.undo) == 0 {
+		return ErrNoHistory
+	}
+
+	last := e.undo[len(e.undo)-1]
+
+	if _, _, err := e.change(last.Undo, false); err != nil {
+		return err
+	}
+
+	e.undo = e.undo[:len(e.undo)-1]
+	e.redo = append(e.redo, last)
+
+	return nil
+}
+
+// Redo applies the last change reverted by Undo again. It fails with
+// ErrNoHistory when there is nothing to redo.
+func (e *Editor) Redo() error {
+	if e.inTx {
+		return fmt.Errorf("transaction in progress: %w", ErrInvalid)
+	}
+
+	if len(e.redo) == 0 {
+		return ErrNoHistory
+	}
+
+	last := e.redo[len(e.redo)-1]
+
+	if _, _, err := e.change(last.Redo, false); err != nil {
+		return err
+	}
+
+	e.redo = e.redo[:len(e.redo)-1]
+	e.undo = append(e.undo, last)
+
+	return nil
+}
+
+func (e *Editor) push(change editorChange) {
+	e.undo = append(e.undo, change)
+	e.redo = nil
+
+	if e.MaxHistory > 0 && len(e.undo) > e.MaxHistory {
+		e.undo = append([]editorChange(nil), e.undo[len(e.undo)-e.MaxHistory:]...)
+	}
+}
+
+// change applies the patch, and with record returns the patches undoing and
+// redoing it. The part of the document that contains everything the patch
+// may change is compared before and after it, and restored on failure.
+func (e *Editor) change(p Patch, record bool) (editorChange, bool, error) {
+	scope := patchScope(&e.doc, p, e.options)
+	path := joinPointer(scope)
+
+	before, err := e.marshalAt(path)
+	if err != nil {
+		return editorChange{}, false, err
+	}
+
+	if _, err := p.applyTo(&e.doc, e.options); err != nil {
+		if restoreErr := e.restoreAt(path, before); restoreErr != nil {
+			return editorChange{}, false, restoreErr
+		}
+		return editorChange{}, false, err
+	}
+
+	if !record {
+		return editorChange{}, true, nil
+	}
+
+	after, err := e.marshalAt(path)
+	if err != nil {
+		return editorChange{}, false, err
+	}
+
+	if Equal(before, after) {
+		return editorChange{}, false, nil
+	}
+
+	undo, err := CreatePatch(after, before)
+	if err != nil {
+		return editorChange{}, false, err
+	}
+
+	redo, err := CreatePatch(before, after)
+	if err != nil {
+		return editorChange{}, false, err
+	}
+
+	return editorChange{Undo: rebasePatch(undo, scope), Redo: rebasePatch(redo, scope)}, true, nil
+}
+
+// patchScope returns the deepest existing container of doc that holds all
+// the values the patch may change. Operations with custom handlers may change
+// anything.
+func patchScope(doc *container, p Patch, options *ApplyOptions) []string {
+	var scope []string
+	first := true
+
+	for _, op := range p {
+		var paths []string
+
+		switch kind := op.Kind(); {
+		case kind == "test":
+		case options.SupportPredicates && isPredicate(kind):
+		case kind == "move":
+			from, _ := op.From()
+			path, _ := op.Path()
+			paths = append(paths, from, path)
+		case kind == "add", kind == "remove", kind == "replace", kind == "copy":
+			path, _ := op.Path()
+			paths = append(paths, path)
+		default:
+			if _, ok := options.operations[kind]; ok {
+				return nil
+			}
+			path, _ := op.Path()
+			paths = append(paths, path)
+		}
+
+		for _, path := range paths {
+			candidate := writeContainer(doc, path, options)
+
+			if first {
+				scope, first = candidate, false
+				continue
+			}
+
+			n := 0
+			for n < len(scope) && n < len(candidate) && scope[n] == candidate[n] {
+				n++
+			}
+			scope = scope[:n]
+		}
+	}
+
+	return scope
+}
+
+// writeContainer returns the deepest existing container of doc above the
+// values an operation on path may change.
+func writeContainer(doc *container, path string, options *ApplyOptions) []string {
+	tokens, err := splitPointer(path)
+	if err != nil || len(tokens) == 0 {
+		return nil
+	}
+
+	// A wildcard changes the members of the container it applies to.
+	end := len(tokens) - 1
+	if options.SupportWildcards {
+		for i, tok := range tokens[:end] {
+			if isWildcard(tok) {
+				end = i
+				break
+			}
+		}
+	}
+
+	for ; end > 0; end-- {
+		node, found := valueAt(doc, joinPointer(tokens[:end]), options)
+		if found && nodeType(node) != nodeScalar {
+			return tokens[:end]
+		}
+	}
+
+	return nil
+}
+
+func (e *Editor) marshalAt(path string) ([]byte, error) {
+	if path == "" {
+		return json.Marshal(e.doc)
+	}
+
+	node, found := valueAt(&e.doc, path, e.options)
+	if !found {
+		return nil, fmt.Errorf("unable to find %s: %w", path, ErrMissing)
+	}
+
+	return json.Marshal(node)
+}
+
+func (e *Editor) restoreAt(path string, data []byte) error {
+	if path == "" {
+		pd, err := newContainer(data, e.options)
+		if err != nil {
+			return err
+		}
+		e.doc = pd
+		return nil
+	}
+
+	con, key := findObject(&e.doc, path, e.options)
+	if con == nil {
+		return fmt.Errorf("unable to restore %s: %w", path, ErrMissing)
+	}
+
+	return con.set(key, newLazyNode(newRawMessage(data)), e.options)
+}
+
+// rebasePatch prefixes the pointers of the patch with scope.
+func rebasePatch(p Patch, scope []string) Patch {
+	if len(scope) == 0 {
+		return p
+	}
+
+	for _, op := range p {
+		for _, member := range []string{"path", "from"} {
+			raw := op[member]
+			if raw == nil {
+				continue
+			}
+
+			var path string
+			if err := unmarshal(*raw, &path); err != nil {
+				continue
+			}
+
+			tokens, err := splitPointer(path)
+			if err != nil {
+				continue
+			}
+
+			op[member] = rawString(joinPointer(append(append([]string(nil), scope...), tokens...)))
+		}
+	}
+
+	return p
+}
+
+type editorState struct {
+	Document json.RawMessage `json:"document"`
+	Undo     []editorChange  `json:"undo"`
+	Redo     []editorChange  `json:"redo"`
+}
+
+// MarshalJSON encodes the document and its histories, to be restored with
+// RestoreEditor. The patches of a transaction in progress are not included.
+func (e *Editor) MarshalJSON() ([]byte, error) {
+	doc, err := json.Marshal(e.doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.inTx {
+		// The pending patches are undone in the encoded document.
+		var undo Patch
+		for i := len(e.pending) - 1; i >= 0; i-- {
+			undo = append(undo, e.pending[i].Undo...)
+		}
+
+		if doc, err = undo.Apply(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(editorState{Document: doc, Undo: e.undo, Redo: e.redo})
+}
+
+// RestoreEditor returns an Editor from the state encoded by
+// Editor.MarshalJSON, applying patches with the passed in ApplyOptions.
+func RestoreEditor(data []byte, options *ApplyOptions) (*Editor, error) {
+	var state editorState
+	if err := unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	e, err := NewEditor(state.Document, options)
+	if err != nil {
+		return nil, err
+	}
+
+	e.undo, e.redo = state.Undo, state.Redo
+
+	return e, nil
+}
@@ -0,0 +1,277 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func editorDocument(t *testing.T, e *Editor) string {
+	t.Helper()
+
+	doc, err := e.Document()
+	if err != nil {
+		t.Fatalf("unable to encode document: %s", err)
+	}
+
+	return string(doc)
+}
+
+func TestEditorUndoRedo(t *testing.T) {
+	cases := []struct {
+		doc     string
+		patch   string
+		result  string
+		options func(o *ApplyOptions)
+	}{
+		{`{"a": 1, "b": {"c": [1, 2, 3]}}`, `[{"op": "remove", "path": "/b/c/1"}, {"op": "add", "path": "/b/c/-", "value": 4}]`, `{"a": 1, "b": {"c": [1, 3, 4]}}`, nil},
+		{`{"a": 1, "b": {"c": 2}}`, `[{"op": "move", "from": "/b/c", "path": "/a"}]`, `{"a": 2, "b": {}}`, nil},
+		{`{"a": {"x": 1}}`, `[{"op": "replace", "path": "", "value": [1, 2]}]`, `[1, 2]`, nil},
+		{`{"list": [{"a": 1}, {"a": 2}]}`, `[{"op": "add", "path": "/list/0/b", "value": {"c": true}}]`, `{"list": [{"a": 1, "b": {"c": true}}, {"a": 2}]}`, nil},
+		{`{"a": {}}`, `[{"op": "add", "path": "/a/b/c", "value": 1}]`, `{"a": {"b": {"c": 1}}}`, func(o *ApplyOptions) { o.EnsurePathExistsOnAdd = true }},
+		{`{"users": [{"n": 1}, {"n": 2}]}`, `[{"op": "replace", "path": "/users/*/n", "value": 0}]`, `{"users": [{"n": 0}, {"n": 0}]}`, func(o *ApplyOptions) { o.SupportWildcards = true }},
+		{`{"n": {"v": 1}}`, `[{"op": "inc", "path": "/n/v", "value": 2}]`, `{"n": {"v": 3}}`, func(o *ApplyOptions) { o.SupportExtensions = true }},
+		{`{"tags": ["a"]}`, `[{"op": "sort", "path": "/tags"}, {"op": "add", "path": "/tags/0", "value": "z"}]`, `{"tags": ["z", "a"]}`, func(o *ApplyOptions) {
+			if err := o.RegisterOperation("sort", sortHandler{}); err != nil {
+				panic(err)
+			}
+		}},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := NewApplyOptions()
+			if c.options != nil {
+				c.options(options)
+			}
+
+			e, err := NewEditor([]byte(c.doc), options)
+			if err != nil {
+				t.Fatalf("unable to create editor: %s", err)
+			}
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			if err := e.Apply(p); err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if got := editorDocument(t, e); !compareJSON(got, c.result) {
+				t.Errorf("expected %s, got %s", c.result, got)
+			}
+
+			if err := e.Undo(); err != nil {
+				t.Fatalf("unable to undo: %s", err)
+			}
+
+			if got := editorDocument(t, e); !compareJSON(got, c.doc) {
+				t.Errorf("expected %s after undo, got %s", c.doc, got)
+			}
+
+			if err := e.Redo(); err != nil {
+				t.Fatalf("unable to redo: %s", err)
+			}
+
+			if got := editorDocument(t, e); !compareJSON(got, c.result) {
+				t.Errorf("expected %s after redo, got %s", c.result, got)
+			}
+		})
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	e, err := NewEditor([]byte(`{"n": 0}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create editor: %s", err)
+	}
+	e.MaxHistory = 2
+
+	set := func(n int) {
+		p, err := DecodePatch([]byte(fmt.Sprintf(`[{"op": "replace", "path": "/n", "value": %d}]`, n)))
+		if err != nil {
+			t.Fatalf("unable to decode patch: %s", err)
+		}
+		if err := e.Apply(p); err != nil {
+			t.Fatalf("unable to apply patch: %s", err)
+		}
+	}
+
+	set(1)
+	set(2)
+	set(3)
+
+	if err := e.Undo(); err != nil {
+		t.Fatalf("unable to undo: %s", err)
+	}
+	if err := e.Undo(); err != nil {
+		t.Fatalf("unable to undo: %s", err)
+	}
+
+	if got := editorDocument(t, e); !compareJSON(got, `{"n": 1}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+
+	if err := e.Undo(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+
+	// A new change forgets the undone ones.
+	set(5)
+
+	if e.CanRedo() {
+		t.Errorf("expected no redo history")
+	}
+
+	if err := e.Redo(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+
+	// Patches that change nothing are not recorded.
+	test, _ := DecodePatch([]byte(`[{"op": "test", "path": "/n", "value": 5}]`))
+	if err := e.Apply(test); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if err := e.Undo(); err != nil {
+		t.Fatalf("unable to undo: %s", err)
+	}
+
+	if got := editorDocument(t, e); !compareJSON(got, `{"n": 1}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+}
+
+func TestEditorTransaction(t *testing.T) {
+	e, err := NewEditor([]byte(`{"a": 1, "list": []}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create editor: %s", err)
+	}
+
+	apply := func(s string) {
+		p, err := DecodePatch([]byte(s))
+		if err != nil {
+			t.Fatalf("unable to decode patch: %s", err)
+		}
+		if err := e.Apply(p); err != nil {
+			t.Fatalf("unable to apply patch: %s", err)
+		}
+	}
+
+	if err := e.Begin(); err != nil {
+		t.Fatalf("unable to begin: %s", err)
+	}
+	apply(`[{"op": "replace", "path": "/a", "value": 2}]`)
+	apply(`[{"op": "add", "path": "/list/-", "value": "x"}]`)
+	if err := e.Undo(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid during a transaction, got %v", err)
+	}
+	if err := e.Commit(); err != nil {
+		t.Fatalf("unable to commit: %s", err)
+	}
+
+	if err := e.Begin(); err != nil {
+		t.Fatalf("unable to begin: %s", err)
+	}
+	apply(`[{"op": "remove", "path": "/a"}]`)
+	if err := e.Rollback(); err != nil {
+		t.Fatalf("unable to roll back: %s", err)
+	}
+
+	if got := editorDocument(t, e); !compareJSON(got, `{"a": 2, "list": ["x"]}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+
+	if err := e.Undo(); err != nil {
+		t.Fatalf("unable to undo: %s", err)
+	}
+
+	if got := editorDocument(t, e); !compareJSON(got, `{"a": 1, "list": []}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+
+	if e.CanUndo() {
+		t.Errorf("expected a single change")
+	}
+}
+
+func TestEditorFailedPatch(t *testing.T) {
+	e, err := NewEditor([]byte(`{"a": {"b": 1}, "c": 2}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create editor: %s", err)
+	}
+
+	p, _ := DecodePatch([]byte(`[{"op": "replace", "path": "/a/b", "value": 5}, {"op": "remove", "path": "/a/x"}]`))
+	if err := e.Apply(p); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+
+	if got := editorDocument(t, e); !compareJSON(got, `{"a": {"b": 1}, "c": 2}`) {
+		t.Errorf("expected the document to be unchanged, got %s", got)
+	}
+
+	if e.CanUndo() {
+		t.Errorf("expected no history")
+	}
+}
+
+func TestEditorRestore(t *testing.T) {
+	e, err := NewEditor([]byte(`{"n": 0}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create editor: %s", err)
+	}
+
+	for _, n := range []int{1, 2} {
+		p, _ := DecodePatch([]byte(fmt.Sprintf(`[{"op": "replace", "path": "/n", "value": %d}]`, n)))
+		if err := e.Apply(p); err != nil {
+			t.Fatalf("unable to apply patch: %s", err)
+		}
+	}
+
+	if err := e.Undo(); err != nil {
+		t.Fatalf("unable to undo: %s", err)
+	}
+
+	if err := e.Begin(); err != nil {
+		t.Fatalf("unable to begin: %s", err)
+	}
+
+	pending, _ := DecodePatch([]byte(`[{"op": "add", "path": "/m", "value": 1}]`))
+	if err := e.Apply(pending); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	data, err := e.MarshalJSON()
+	if err != nil {
+		t.Fatalf("unable to encode editor: %s", err)
+	}
+
+	r, err := RestoreEditor(data, NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to restore editor: %s", err)
+	}
+
+	if got := editorDocument(t, r); !compareJSON(got, `{"n": 1}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+
+	if err := r.Redo(); err != nil {
+		t.Fatalf("unable to redo: %s", err)
+	}
+
+	if got := editorDocument(t, r); !compareJSON(got, `{"n": 2}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.Undo(); err != nil {
+			t.Fatalf("unable to undo: %s", err)
+		}
+	}
+
+	if got := editorDocument(t, r); !compareJSON(got, `{"n": 0}`) {
+		t.Errorf("unexpected document %s", got)
+	}
+}
@@ -1,6 +1,9 @@
 package jsonpatch
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // AccumulatedCopySizeError is an error type returned when the accumulated size
 // increase caused by copy operations in a patch operation has exceeded the
@@ -36,3 +39,230 @@ func NewArraySizeError(l, s int) *ArraySizeError {
 func (a *ArraySizeError) Error() string {
 	return fmt.Sprintf("Unable to create array of size %d, limit is %d", a.size, a.limit)
 }
+
+// BatchFailuresError is an error type returned when a batch was aborted
+// because too many of its documents failed.
+type BatchFailuresError struct {
+	limit int
+}
+
+// NewBatchFailuresError returns a BatchFailuresError.
+func NewBatchFailuresError(l int) *BatchFailuresError {
+	return &BatchFailuresError{limit: l}
+}
+
+// Error implements the error interface.
+func (b *BatchFailuresError) Error() string {
+	return fmt.Sprintf("Unable to complete the batch, %d documents failed", b.limit)
+}
+
+// UnresolvedVariablesError is an error type returned when a Template refers
+// to variables that were not provided.
+type UnresolvedVariablesError struct {
+	names []string
+}
+
+// NewUnresolvedVariablesError returns an UnresolvedVariablesError.
+func NewUnresolvedVariablesError(names []string) *UnresolvedVariablesError {
+	return &UnresolvedVariablesError{names: names}
+}
+
+// Error implements the error interface.
+func (u *UnresolvedVariablesError) Error() string {
+	return fmt.Sprintf("Unable to resolve template variables: %s", strings.Join(u.names, ", "))
+}
+
+// Variables returns the names of the unresolved variables.
+func (u *UnresolvedVariablesError) Variables() []string {
+	return u.names
+}
+
+// Unwrap allows UnresolvedVariablesError to match ErrMissing.
+func (u *UnresolvedVariablesError) Unwrap() error {
+	return ErrMissing
+}
+
+// OperationError is an error type describing the operation of a patch that
+// failed, returned by Patch.CanApply and Patch.Check.
+type OperationError struct {
+	index int
+	op    Operation
+	err   error
+}
+
+// NewOperationError returns an OperationError.
+func NewOperationError(index int, op Operation, err error) *OperationError {
+	return &OperationError{index: index, op: op, err: err}
+}
+
+// Error implements the error interface.
+func (o *OperationError) Error() string {
+	return fmt.Sprintf("Operation %d (%s) failed: %s", o.index, o.op.Kind(), o.err)
+}
+
+// Index returns the position of the operation in the patch.
+func (o *OperationError) Index() int {
+	return o.index
+}
+
+// Operation returns the operation that failed.
+func (o *OperationError) Operation() Operation {
+	return o.op
+}
+
+// Unwrap returns the error the operation failed with.
+func (o *OperationError) Unwrap() error {
+	return o.err
+}
+
+// PolicyError is an error type returned when an operation is rejected by
+// ApplyOptions.Policy.
+type PolicyError struct {
+	rule string
+	op   string
+	path string
+}
+
+// NewPolicyError returns a PolicyError.
+func NewPolicyError(rule, op, path string) *PolicyError {
+	return &PolicyError{rule: rule, op: op, path: path}
+}
+
+// Error implements the error interface.
+func (p *PolicyError) Error() string {
+	return fmt.Sprintf("Operation %s on %s is forbidden by policy rule %q", p.op, p.path, p.rule)
+}
+
+// Rule returns the name of the rule that rejected the operation, or
+// "default" when no rule allowed it.
+func (p *PolicyError) Rule() string {
+	return p.rule
+}
+
+// Op returns the kind of the rejected operation.
+func (p *PolicyError) Op() string {
+	return p.op
+}
+
+// Path returns the path that was rejected.
+func (p *PolicyError) Path() string {
+	return p.path
+}
+
+// OperationCountError is an error type returned when a patch has more
+// operations than ApplyOptions.MaxOperations.
+type OperationCountError struct {
+	limit int
+	count int
+}
+
+// NewOperationCountError returns an OperationCountError.
+func NewOperationCountError(l, c int) *OperationCountError {
+	return &OperationCountError{limit: l, count: c}
+}
+
+// Error implements the error interface.
+func (o *OperationCountError) Error() string {
+	return fmt.Sprintf("Unable to accept a patch of %d operations, limit is %d", o.count, o.limit)
+}
+
+// PointerDepthError is an error type returned when a pointer has more
+// reference tokens than ApplyOptions.MaxPointerDepth.
+type PointerDepthError struct {
+	limit int
+	depth int
+}
+
+// NewPointerDepthError returns a PointerDepthError.
+func NewPointerDepthError(l, d int) *PointerDepthError {
+	return &PointerDepthError{limit: l, depth: d}
+}
+
+// Error implements the error interface.
+func (p *PointerDepthError) Error() string {
+	return fmt.Sprintf("Unable to accept a pointer of depth %d, limit is %d", p.depth, p.limit)
+}
+
+// PointerTokenLengthError is an error type returned when a reference token
+// of a pointer is longer than ApplyOptions.MaxPointerTokenLength.
+type PointerTokenLengthError struct {
+	limit  int
+	length int
+}
+
+// NewPointerTokenLengthError returns a PointerTokenLengthError.
+func NewPointerTokenLengthError(l, n int) *PointerTokenLengthError {
+	return &PointerTokenLengthError{limit: l, length: n}
+}
+
+// Error implements the error interface.
+func (p *PointerTokenLengthError) Error() string {
+	return fmt.Sprintf("Unable to accept a pointer token of length %d, limit is %d", p.length, p.limit)
+}
+
+// NestingDepthError is an error type returned when a document or a value is
+// nested deeper than ApplyOptions.MaxNestingDepth.
+type NestingDepthError struct {
+	limit int
+}
+
+// NewNestingDepthError returns a NestingDepthError.
+func NewNestingDepthError(l int) *NestingDepthError {
+	return &NestingDepthError{limit: l}
+}
+
+// Error implements the error interface.
+func (n *NestingDepthError) Error() string {
+	return fmt.Sprintf("Unable to accept a value nested deeper than %d", n.limit)
+}
+
+// DocumentSizeError is an error type returned when the document given to or
+// produced by a patch is larger than ApplyOptions.MaxDocumentSize.
+type DocumentSizeError struct {
+	limit int64
+	size  int64
+}
+
+// NewDocumentSizeError returns a DocumentSizeError.
+func NewDocumentSizeError(l, s int64) *DocumentSizeError {
+	return &DocumentSizeError{limit: l, size: s}
+}
+
+// Error implements the error interface.
+func (d *DocumentSizeError) Error() string {
+	return fmt.Sprintf("Unable to accept a document of %d bytes, limit is %d", d.size, d.limit)
+}
+
+// PatchSizeError is an error type returned when the patch given to
+// DecodePatchWithOptions is larger than ApplyOptions.MaxPatchSize.
+type PatchSizeError struct {
+	limit int64
+	size  int64
+}
+
+// NewPatchSizeError returns a PatchSizeError.
+func NewPatchSizeError(l, s int64) *PatchSizeError {
+	return &PatchSizeError{limit: l, size: s}
+}
+
+// Error implements the error interface.
+func (p *PatchSizeError) Error() string {
+	return fmt.Sprintf("Unable to accept a patch of %d bytes, limit is %d", p.size, p.limit)
+}
+
+// ValueSizeError is an error type returned when the "value" of an operation
+// is larger than ApplyOptions.MaxValueSize.
+type ValueSizeError struct {
+	limit int64
+	size  int64
+}
+
+// NewValueSizeError returns a ValueSizeError.
+func NewValueSizeError(l, s int64) *ValueSizeError {
+	return &ValueSizeError{limit: l, size: s}
+}
+
+// Error implements the error interface.
+func (v *ValueSizeError) Error() string {
+	return fmt.Sprintf("Unable to accept a value of %d bytes, limit is %d", v.size, v.limit)
+}
@@ -0,0 +1,218 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// The non-standard operations enabled with ApplyOptions.SupportExtensions.
+// Handlers registered with RegisterOperation take precedence over them.
+var extensionOperations = map[string]OperationHandler{
+	"inc":           incOperation{},
+	"merge":         mergeOperation{},
+	"append-unique": appendUniqueOperation{},
+}
+
+// handler returns the handler of a non-standard operation.
+func (o *ApplyOptions) handler(kind string) (OperationHandler, bool) {
+	if h, ok := o.operations[kind]; ok {
+		return h, true
+	}
+
+	if o.SupportExtensions {
+		h, ok := extensionOperations[kind]
+		return h, ok
+	}
+
+	return nil, false
+}
+
+func validateExtension(op Operation) error {
+	if _, err := op.Path(); err != nil {
+		return fmt.Errorf("failed to decode 'path': %w", err)
+	}
+
+	if _, err := op.ValueInterface(); err != nil {
+		return fmt.Errorf("failed to decode 'value': %w", err)
+	}
+
+	return nil
+}
+
+// incOperation adds the number in "value" to the number at "path". A missing
+// value counts as 0. Integers are added exactly, whatever their size.
+type incOperation struct{}
+
+func (incOperation) Validate(op Operation) error {
+	if err := validateExtension(op); err != nil {
+		return err
+	}
+
+	if _, err := extensionNumber(extensionValue(op)); err != nil {
+		return fmt.Errorf("inc operation 'value' must be a number: %w", ErrInvalid)
+	}
+
+	return nil
+}
+
+func (incOperation) Apply(view *DocumentView) error {
+	op := view.Operation()
+	path, _ := op.Path()
+	delta := extensionValue(op)
+
+	cur, err := view.Get(path)
+	if errors.Is(err, ErrMissing) {
+		return view.Add(path, delta)
+	}
+	if err != nil {
+		return fmt.Errorf("inc operation failed: %w", err)
+	}
+
+	sum, err := addNumbers(cur, delta)
+	if err != nil {
+		return fmt.Errorf("inc operation on %s failed: %w", path, err)
+	}
+
+	return view.Set(path, sum)
+}
+
+// extensionValue returns the raw "value" of an operation.
+func extensionValue(op Operation) []byte {
+	if raw := op["value"]; raw != nil {
+		return *raw
+	}
+	return rawJSONNull
+}
+
+func extensionNumber(buf []byte) (json.Number, error) {
+	var v interface{}
+	if err := unmarshal(buf, &v); err != nil {
+		return "", err
+	}
+
+	n, ok := v.(json.Number)
+	if !ok {
+		return "", ErrInvalid
+	}
+
+	return n, nil
+}
+
+func isInteger(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+// addNumbers returns the sum of two JSON numbers. When both are integers the
+// sum is exact, otherwise it is computed in float64.
+func addNumbers(a, b []byte) ([]byte, error) {
+	x, err := extensionNumber(a)
+	if err != nil {
+		return nil, fmt.Errorf("value is not a number: %w", ErrInvalid)
+	}
+
+	y, err := extensionNumber(b)
+	if err != nil {
+		return nil, fmt.Errorf("value is not a number: %w", ErrInvalid)
+	}
+
+	if isInteger(x) && isInteger(y) {
+		bx, okx := new(big.Int).SetString(string(x), 10)
+		by, oky := new(big.Int).SetString(string(y), 10)
+		if okx && oky {
+			return []byte(bx.Add(bx, by).String()), nil
+		}
+	}
+
+	fx, err := x.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("value is out of range: %w", ErrInvalid)
+	}
+
+	fy, err := y.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("value is out of range: %w", ErrInvalid)
+	}
+
+	sum := fx + fy
+	if math.IsInf(sum, 0) {
+		return nil, fmt.Errorf("sum is out of range: %w", ErrInvalid)
+	}
+
+	return []byte(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}
+
+// mergeOperation applies the RFC 7396 merge patch in "value" to the value at
+// "path". A missing value is added as the merge patch with its nulls removed.
+type mergeOperation struct{}
+
+func (mergeOperation) Validate(op Operation) error {
+	return validateExtension(op)
+}
+
+func (mergeOperation) Apply(view *DocumentView) error {
+	op := view.Operation()
+	path, _ := op.Path()
+	patch := extensionValue(op)
+
+	cur, err := view.Get(path)
+	missing := errors.Is(err, ErrMissing)
+	if err != nil && !missing {
+		return fmt.Errorf("merge operation failed: %w", err)
+	}
+
+	// As in RFC 7396, a target that is not an object is merged into as an
+	// empty object.
+	if missing || bytes.Equal(bytes.TrimSpace(cur), rawJSONNull) {
+		cur = []byte("{}")
+	}
+
+	merged, err := MergePatch(cur, patch)
+	if err != nil {
+		return fmt.Errorf("merge operation on %s failed: %w", path, err)
+	}
+
+	if missing {
+		return view.Add(path, merged)
+	}
+
+	return view.Set(path, merged)
+}
+
+// appendUniqueOperation appends "value" to the array at "path" unless an
+// equal element is already present.
+type appendUniqueOperation struct{}
+
+func (appendUniqueOperation) Validate(op Operation) error {
+	return validateExtension(op)
+}
+
+func (appendUniqueOperation) Apply(view *DocumentView) error {
+	op := view.Operation()
+	path, _ := op.Path()
+
+	cur, err := view.Get(path)
+	if err != nil {
+		return fmt.Errorf("append-unique operation failed: %w", err)
+	}
+
+	var elems []*lazyNode
+	if err := unmarshal(cur, &elems); err != nil {
+		return fmt.Errorf("append-unique operation on %s requires an array: %w", path, ErrInvalid)
+	}
+
+	value := op.value()
+	for _, e := range elems {
+		if nodeEqual(e, value) {
+			return nil
+		}
+	}
+
+	return view.Add(path+"/-", extensionValue(op))
+}
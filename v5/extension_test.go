@@ -0,0 +1,179 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func extensionOptions() *ApplyOptions {
+	options := NewApplyOptions()
+	options.SupportExtensions = true
+	return options
+}
+
+func TestExtensionOperations(t *testing.T) {
+	cases := []struct {
+		doc, patch, result string
+	}{
+		{
+			`{"count": 1}`,
+			`[{"op": "inc", "path": "/count", "value": 2}]`,
+			`{"count": 3}`,
+		},
+		{
+			`{"count": 9007199254740993}`,
+			`[{"op": "inc", "path": "/count", "value": 1}]`,
+			`{"count": 9007199254740994}`,
+		},
+		{
+			`{"count": 123456789012345678901234567890}`,
+			`[{"op": "inc", "path": "/count", "value": -1}]`,
+			`{"count": 123456789012345678901234567889}`,
+		},
+		{
+			`{"count": 1.5}`,
+			`[{"op": "inc", "path": "/count", "value": 1}]`,
+			`{"count": 2.5}`,
+		},
+		{
+			`{}`,
+			`[{"op": "inc", "path": "/count", "value": 5}]`,
+			`{"count": 5}`,
+		},
+		{
+			`{"counts": [1, 2]}`,
+			`[{"op": "inc", "path": "/counts/1", "value": 10}, {"op": "inc", "path": "/counts/1", "value": 10}]`,
+			`{"counts": [1, 22]}`,
+		},
+		{
+			`{"a": {"b": 1, "c": {"d": 2}}}`,
+			`[{"op": "merge", "path": "/a", "value": {"b": null, "c": {"e": 3}}}]`,
+			`{"a": {"c": {"d": 2, "e": 3}}}`,
+		},
+		{
+			`{"a": 1}`,
+			`[{"op": "merge", "path": "", "value": {"b": 2}}]`,
+			`{"a": 1, "b": 2}`,
+		},
+		{
+			`{}`,
+			`[{"op": "merge", "path": "/a", "value": {"b": 1, "c": null}}]`,
+			`{"a": {"b": 1}}`,
+		},
+		{
+			`{"a": null}`,
+			`[{"op": "merge", "path": "/a", "value": {"b": 1}}]`,
+			`{"a": {"b": 1}}`,
+		},
+		{
+			`{"a": [1]}`,
+			`[{"op": "merge", "path": "/a", "value": {"b": 1}}]`,
+			`{"a": {"b": 1}}`,
+		},
+		{
+			`{"tags": ["a", {"b": 1}, null]}`,
+			`[{"op": "append-unique", "path": "/tags", "value": "a"}, {"op": "append-unique", "path": "/tags", "value": {"b": 1}}, {"op": "append-unique", "path": "/tags", "value": null}]`,
+			`{"tags": ["a", {"b": 1}, null]}`,
+		},
+		{
+			`{"tags": ["a"]}`,
+			`[{"op": "append-unique", "path": "/tags", "value": "b"}, {"op": "append-unique", "path": "/tags", "value": "b"}]`,
+			`{"tags": ["a", "b"]}`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := extensionOptions()
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			out, err := p.ApplyWithOptions([]byte(c.doc), options)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+		})
+	}
+}
+
+func TestExtensionOperationErrors(t *testing.T) {
+	cases := []struct {
+		doc, patch string
+		err        error
+	}{
+		{`{"count": "1"}`, `[{"op": "inc", "path": "/count", "value": 1}]`, ErrInvalid},
+		{`{"count": null}`, `[{"op": "inc", "path": "/count", "value": 1}]`, ErrInvalid},
+		{`{"count": 1e308}`, `[{"op": "inc", "path": "/count", "value": 1e308}]`, ErrInvalid},
+		{`{}`, `[{"op": "inc", "path": "/a/count", "value": 1}]`, ErrMissing},
+		{`{"tags": {}}`, `[{"op": "append-unique", "path": "/tags", "value": 1}]`, ErrInvalid},
+		{`{}`, `[{"op": "append-unique", "path": "/tags", "value": 1}]`, ErrMissing},
+		{`{}`, `[{"op": "merge", "path": "/a/b", "value": {}}]`, ErrMissing},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := extensionOptions()
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			if _, err := p.ApplyWithOptions([]byte(c.doc), options); !errors.Is(err, c.err) {
+				t.Errorf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestExtensionOperationsDisabled(t *testing.T) {
+	patch := `[{"op": "inc", "path": "/count", "value": 1}]`
+
+	if _, err := DecodePatch([]byte(patch)); err == nil {
+		t.Error("expected DecodePatch to reject inc")
+	}
+
+	options := extensionOptions()
+	if _, err := DecodePatchWithOptions([]byte(`[{"op": "inc", "path": "/count", "value": "1"}]`), options); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a non-numeric inc to be rejected, got %v", err)
+	}
+
+	p, err := DecodePatchWithOptions([]byte(patch), options)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, err := p.Apply([]byte(`{"count": 1}`)); err == nil {
+		t.Error("expected an error applying inc without SupportExtensions")
+	}
+}
+
+func TestExtensionOperationsOverride(t *testing.T) {
+	options := extensionOptions()
+	if err := options.RegisterOperation("append-unique", sortHandler{}); err != nil {
+		t.Fatalf("unable to register: %s", err)
+	}
+
+	p, err := DecodePatchWithOptions([]byte(`[{"op": "append-unique", "path": "/tags"}]`), options)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	out, err := p.ApplyWithOptions([]byte(`{"tags": ["b", "a"]}`), options)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"tags": ["a", "b"]}`) {
+		t.Errorf("expected the registered handler to be used, got %s", out)
+	}
+}
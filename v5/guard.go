@@ -0,0 +1,81 @@
+package jsonpatch
+
+import (
+	"fmt"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// operationGuard is the "if" member of an operation, enabled with
+// ApplyOptions.SupportConditions. The operation only applies when the value
+// at path equals value, or when it exists or not, as set by exists.
+type operationGuard struct {
+	path   string
+	value  *lazyNode
+	exists *bool
+}
+
+// guard decodes the "if" member of an operation. It returns nil if there is
+// none.
+func (o Operation) guard() (*operationGuard, error) {
+	raw, ok := o["if"]
+	if !ok {
+		return nil, nil
+	}
+
+	var members map[string]*json.RawMessage
+	if raw == nil || unmarshal(*raw, &members) != nil || members == nil {
+		return nil, fmt.Errorf("'if' must be an object: %w", ErrInvalid)
+	}
+
+	g := &operationGuard{}
+
+	path, ok := members["path"]
+	if !ok || path == nil || unmarshal(*path, &g.path) != nil {
+		return nil, fmt.Errorf("'if' is missing a path: %w", ErrMissing)
+	}
+
+	if _, err := splitPointer(g.path); err != nil {
+		return nil, fmt.Errorf("failed to decode 'if' path: %w", err)
+	}
+
+	value, hasValue := members["value"]
+	exists, hasExists := members["exists"]
+
+	switch {
+	case hasValue && hasExists:
+		return nil, fmt.Errorf("'if' must have either a value or exists, not both: %w", ErrInvalid)
+	case hasValue:
+		if value == nil {
+			value = newRawMessage(rawJSONNull)
+		}
+		g.value = newLazyNode(value)
+	case hasExists:
+		var b bool
+		if exists == nil || unmarshal(*exists, &b) != nil {
+			return nil, fmt.Errorf("'if' exists must be a boolean: %w", ErrInvalid)
+		}
+		g.exists = &b
+	default:
+		return nil, fmt.Errorf("'if' must have a value or exists: %w", ErrMissing)
+	}
+
+	for k := range members {
+		if k != "path" && k != "value" && k != "exists" {
+			return nil, fmt.Errorf("unknown 'if' member %q: %w", k, ErrInvalid)
+		}
+	}
+
+	return g, nil
+}
+
+// holds reports whether the guard is met by doc.
+func (g *operationGuard) holds(doc *container, options *ApplyOptions) bool {
+	val, found := valueAt(doc, g.path, options)
+
+	if g.exists != nil {
+		return found == *g.exists
+	}
+
+	return found && nodeEqual(val, g.value)
+}
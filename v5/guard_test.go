@@ -0,0 +1,131 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func conditionOptions() *ApplyOptions {
+	options := NewApplyOptions()
+	options.SupportConditions = true
+	return options
+}
+
+func TestOperationGuards(t *testing.T) {
+	cases := []struct {
+		doc, patch, result string
+		skipped            []bool
+	}{
+		{
+			`{"kind": "v1", "spec": {}}`,
+			`[
+			  {"op": "add", "path": "/spec/replicas", "value": 2, "if": {"path": "/kind", "value": "v1"}},
+			  {"op": "add", "path": "/spec/scale", "value": {"replicas": 2}, "if": {"path": "/kind", "value": "v2"}}
+			]`,
+			`{"kind": "v1", "spec": {"replicas": 2}}`,
+			[]bool{false, true},
+		},
+		{
+			`{"a": {"b": [1, null]}}`,
+			`[
+			  {"op": "remove", "path": "/a/b/1", "if": {"path": "/a/b/1", "value": null}},
+			  {"op": "remove", "path": "/a/c", "if": {"path": "/a/c", "exists": true}},
+			  {"op": "add", "path": "/a/c", "value": 1, "if": {"path": "/a/c", "exists": false}}
+			]`,
+			`{"a": {"b": [1], "c": 1}}`,
+			[]bool{false, true, false},
+		},
+		{
+			`{"a": {"x": [1, {"y": 2}]}}`,
+			`[
+			  {"op": "replace", "path": "/a/x", "value": [], "if": {"path": "/a", "value": {"x": [1, {"y": 2}]}}},
+			  {"op": "replace", "path": "/a/x", "value": [3], "if": {"path": "", "value": {"a": {"x": [1]}}}}
+			]`,
+			`{"a": {"x": []}}`,
+			[]bool{false, true},
+		},
+		{
+			`{"a": 1}`,
+			`[
+			  {"op": "add", "path": "/b", "value": 1, "if": {"path": "/b", "exists": false}},
+			  {"op": "add", "path": "/c", "value": 1, "if": {"path": "/b", "exists": true}}
+			]`,
+			`{"a": 1, "b": 1, "c": 1}`,
+			[]bool{false, false},
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := conditionOptions()
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			out, results, err := p.ApplyWithResults([]byte(c.doc), options)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+
+			skipped := make([]bool, len(results))
+			for j, r := range results {
+				skipped[j] = r.Status == OperationSkipped
+			}
+
+			if !reflect.DeepEqual(skipped, c.skipped) {
+				t.Errorf("expected skipped %v, got %v", c.skipped, skipped)
+			}
+		})
+	}
+}
+
+func TestOperationGuardErrors(t *testing.T) {
+	for _, patch := range []string{
+		`[{"op": "remove", "path": "/a", "if": 1}]`,
+		`[{"op": "remove", "path": "/a", "if": null}]`,
+		`[{"op": "remove", "path": "/a", "if": {"value": 1}}]`,
+		`[{"op": "remove", "path": "/a", "if": {"path": "a", "value": 1}}]`,
+		`[{"op": "remove", "path": "/a", "if": {"path": "/a"}}]`,
+		`[{"op": "remove", "path": "/a", "if": {"path": "/a", "value": 1, "exists": true}}]`,
+		`[{"op": "remove", "path": "/a", "if": {"path": "/a", "exists": "yes"}}]`,
+		`[{"op": "remove", "path": "/a", "if": {"path": "/a", "equals": 1}}]`,
+	} {
+		if _, err := DecodePatchWithOptions([]byte(patch), conditionOptions()); err == nil {
+			t.Errorf("expected %s to be rejected", patch)
+		}
+	}
+
+	// Without SupportConditions, "if" is ignored as RFC 6902 requires of
+	// unknown members.
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/b", "value": 1, "if": {"path": "/a", "value": 2}}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	out, err := p.Apply([]byte(`{"a": 1}`))
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"a": 1, "b": 1}`) {
+		t.Errorf("expected the guard to be ignored, got %s", out)
+	}
+
+	p, err = DecodePatch([]byte(`[{"op": "add", "path": "/b", "value": 1, "if": {"path": "/a"}}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, err := p.ApplyWithOptions([]byte(`{"a": 1}`), conditionOptions()); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected a malformed guard to fail the patch, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package jsonpatch
+
+import (
+	"fmt"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// OperationEvent describes an operation to the ApplyOptions.OnOperation and
+// ApplyOptions.OnOperationDone hooks.
+type OperationEvent struct {
+	// Index is the position of the operation in the patch.
+	Index int
+	// Operation is the operation being applied. Its path has wildcards
+	// resolved, with ApplyOptions.SupportWildcards.
+	Operation Operation
+	// Path is the path of the operation, with a trailing "-" replaced by the
+	// index the value is added at.
+	Path string
+	// Before is the JSON value at Path before the operation, or nil if there
+	// was none. It is nil for an operation that inserts into an array, as
+	// the element previously at Path is shifted rather than replaced.
+	Before []byte
+	// After is the JSON value at Path after the operation, or nil if there is
+	// none. It is only set for OnOperationDone.
+	After []byte
+}
+
+// applyHooked applies an operation, calling the hooks of options around it.
+func (p Patch) applyHooked(doc *container, index int, op Operation, path string, accumulatedCopySize *int64, options *ApplyOptions) error {
+	if options.OnOperation == nil && options.OnOperationDone == nil {
+		return p.applyOperation(doc, op, accumulatedCopySize, options)
+	}
+
+	event := &OperationEvent{
+		Index:     index,
+		Operation: op,
+		Path:      path,
+	}
+
+	if !inserts(doc, op, path, options) {
+		event.Before = rawValueAt(doc, path, options)
+	}
+
+	if options.OnOperation != nil {
+		if err := options.OnOperation(event); err != nil {
+			return fmt.Errorf("%s operation on %s was vetoed: %w", op.Kind(), path, err)
+		}
+	}
+
+	if err := p.applyOperation(doc, op, accumulatedCopySize, options); err != nil {
+		return err
+	}
+
+	if options.OnOperationDone != nil {
+		event.After = rawValueAt(doc, path, options)
+
+		if err := options.OnOperationDone(event); err != nil {
+			return fmt.Errorf("%s operation on %s was vetoed: %w", op.Kind(), path, err)
+		}
+	}
+
+	return nil
+}
+
+// inserts reports whether op adds its value into an array at path, rather
+// than setting the value there.
+func inserts(doc *container, op Operation, path string, options *ApplyOptions) bool {
+	switch op.Kind() {
+	case "add", "move", "copy":
+	default:
+		return false
+	}
+
+	if path == "" {
+		return false
+	}
+
+	parent, _ := findObject(doc, path, options)
+	_, ok := parent.(*partialArray)
+	return ok
+}
+
+// rawValueAt returns the JSON value at path, or nil if there is none.
+func rawValueAt(doc *container, path string, options *ApplyOptions) []byte {
+	node, found := valueAt(doc, path, options)
+	if !found {
+		return nil
+	}
+
+	buf, err := json.MarshalEscaped(node, options.EscapeHTML)
+	if err != nil {
+		return nil
+	}
+
+	return buf
+}
@@ -0,0 +1,137 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOperationHooks(t *testing.T) {
+	p, err := DecodePatch([]byte(`[
+		{"op": "replace", "path": "/a", "value": {"x": 2}},
+		{"op": "add", "path": "/list/-", "value": "c"},
+		{"op": "remove", "path": "/b"},
+		{"op": "move", "from": "/list/0", "path": "/first"},
+		{"op": "move", "from": "/list/0", "path": "/list/-"}
+	]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	var events []string
+
+	options := NewApplyOptions()
+	options.OnOperation = func(e *OperationEvent) error {
+		if e.After != nil {
+			t.Errorf("After is set before operation %d", e.Index)
+		}
+		events = append(events, fmt.Sprintf("before %d %s %s %s", e.Index, e.Operation.Kind(), e.Path, e.Before))
+		return nil
+	}
+	options.OnOperationDone = func(e *OperationEvent) error {
+		events = append(events, fmt.Sprintf("after %d %s %s -> %s", e.Index, e.Path, e.Before, e.After))
+		return nil
+	}
+
+	out, err := p.ApplyWithOptions([]byte(`{"a": {"x": 1}, "b": true, "list": ["a", "b"]}`), options)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"a": {"x": 2}, "list": ["c", "b"], "first": "a"}`) {
+		t.Errorf("unexpected result %s", out)
+	}
+
+	expected := []string{
+		`before 0 replace /a {"x":1}`,
+		`after 0 /a {"x":1} -> {"x":2}`,
+		`before 1 add /list/2 `,
+		`after 1 /list/2  -> "c"`,
+		`before 2 remove /b true`,
+		`after 2 /b true -> `,
+		`before 3 move /first `,
+		`after 3 /first  -> "a"`,
+		`before 4 move /list/1 `,
+		`after 4 /list/1  -> "b"`,
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("unexpected events:\n%s\nexpected:\n%s", strings.Join(events, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestOperationHooksVeto(t *testing.T) {
+	errProtected := errors.New("protected field")
+
+	protect := func(e *OperationEvent) error {
+		if strings.HasPrefix(e.Path, "/metadata") {
+			return errProtected
+		}
+		return nil
+	}
+
+	p, err := DecodePatch([]byte(`[
+		{"op": "replace", "path": "/spec", "value": 2},
+		{"op": "replace", "path": "/metadata/name", "value": "x"}
+	]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	doc := []byte(`{"spec": 1, "metadata": {"name": "a"}}`)
+
+	for _, done := range []bool{false, true} {
+		options := NewApplyOptions()
+		if done {
+			options.OnOperationDone = protect
+		} else {
+			options.OnOperation = protect
+		}
+
+		if _, err := p.ApplyWithOptions(doc, options); !errors.Is(err, errProtected) {
+			t.Errorf("expected the operation to be vetoed, got %v", err)
+		}
+
+		options.ContinueOnError = true
+
+		out, results, err := p.ApplyWithResults(doc, options)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !compareJSON(string(out), `{"spec": 2, "metadata": {"name": "a"}}`) {
+			t.Errorf("expected the vetoed operation to be left out, got %s", out)
+		}
+
+		if results[1].Status != OperationFailed || !errors.Is(results[1].Err, errProtected) {
+			t.Errorf("unexpected result %+v", results[1])
+		}
+	}
+}
+
+func TestOperationHooksWildcards(t *testing.T) {
+	options := NewApplyOptions()
+	options.SupportWildcards = true
+
+	var paths []string
+	options.OnOperation = func(e *OperationEvent) error {
+		paths = append(paths, e.Path)
+		return nil
+	}
+
+	p, err := DecodePatchWithOptions([]byte(`[{"op": "remove", "path": "/items/*/secret"}]`), options)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, err := p.ApplyWithOptions([]byte(`{"items": [{"secret": 1}, {"secret": 2}]}`), options); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	// Expanded operations are applied last to first.
+	if !reflect.DeepEqual(paths, []string{"/items/1/secret", "/items/0/secret"}) {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}
@@ -0,0 +1,339 @@
+package httppatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Resource is a JSON document fetched over HTTP, along with its ETag as sent
+// by the server, quotes included.
+type Resource struct {
+	Doc  []byte
+	ETag string
+}
+
+// Client sends the local changes of resources as conditional PATCH requests.
+// Use NewClient to obtain default values for Client.
+type Client struct {
+	// HTTPClient sends the requests.
+	// Default to http.DefaultClient.
+	HTTPClient *http.Client
+	// MediaType is the type of the patches sent, MergePatchType or
+	// JSONPatchType.
+	// Default to MergePatchType.
+	MediaType string
+	// MaxRetries limits how many times a JSON Patch is rebased onto the
+	// current version of a resource and sent again, after 412 Precondition
+	// Failed. Merge patches are not rebased.
+	// Default to 3.
+	MaxRetries int
+}
+
+// NewClient creates a Client with the default values.
+func NewClient() *Client {
+	return &Client{
+		HTTPClient: http.DefaultClient,
+		MediaType:  MergePatchType,
+		MaxRetries: 3,
+	}
+}
+
+// ResponseError is an error type returned by a Client when the server
+// answers with an unexpected status code.
+type ResponseError struct {
+	statusCode int
+	problem    *Problem
+}
+
+// NewResponseError returns a ResponseError. The problem may be nil.
+func NewResponseError(statusCode int, problem *Problem) *ResponseError {
+	return &ResponseError{statusCode: statusCode, problem: problem}
+}
+
+// Error implements the error interface.
+func (r *ResponseError) Error() string {
+	if r.problem != nil && r.problem.Detail != "" {
+		return fmt.Sprintf("Unexpected response %d %s: %s", r.statusCode, http.StatusText(r.statusCode), r.problem.Detail)
+	}
+
+	return fmt.Sprintf("Unexpected response %d %s", r.statusCode, http.StatusText(r.statusCode))
+}
+
+// StatusCode returns the status code of the response.
+func (r *ResponseError) StatusCode() int {
+	return r.statusCode
+}
+
+// Problem returns the problem details of the response, or nil when it has
+// none.
+func (r *ResponseError) Problem() *Problem {
+	return r.problem
+}
+
+// Unwrap returns ErrNotFound for 404 Not Found, and ErrConflict for 412
+// Precondition Failed.
+func (r *ResponseError) Unwrap() error {
+	switch r.statusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusPreconditionFailed:
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Get fetches the resource at url.
+func (c *Client) Get(ctx context.Context, url string) (*Resource, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	return c.do(req)
+}
+
+// Update fetches the resource at url, changes its document with modify, and
+// sends the changes with Patch.
+func (c *Client) Update(ctx context.Context, url string, modify func(doc []byte) ([]byte, error)) (*Resource, error) {
+	original, err := c.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	modified, err := modify(original.Doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Patch(ctx, url, original, modified)
+}
+
+// Patch sends the differences between the document of original and modified
+// as a PATCH request conditional on the ETag of original, and returns the
+// updated resource. ErrNoETag is returned when original has no ETag.
+//
+// When the resource changed in the meantime, a JSON Patch is rebased onto its
+// current version and sent again, up to MaxRetries times. The conflict is
+// returned instead, as a *ResponseError wrapping ErrConflict, for merge
+// patches, which would overwrite the concurrent changes, and for JSON Patches
+// that cannot be rebased without losing some of their changes.
+func (c *Client) Patch(ctx context.Context, url string, original *Resource, modified []byte) (*Resource, error) {
+	p, err := c.diff(original.Doc, modified)
+	if err != nil {
+		return nil, err
+	}
+
+	base := original
+
+	for attempt := 0; ; attempt++ {
+		if p.empty() {
+			return base, nil
+		}
+
+		if base.ETag == "" {
+			return nil, fmt.Errorf("unable to patch %s: %w", url, ErrNoETag)
+		}
+
+		expected, err := p.apply(base.Doc)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.send(ctx, url, base.ETag, p.data)
+		if err == nil {
+			if res.Doc == nil {
+				res.Doc = expected
+			}
+			return res, nil
+		}
+
+		if !errors.Is(err, ErrConflict) || attempt >= c.MaxRetries || p.mediaType == MergePatchType {
+			return nil, err
+		}
+
+		current, gerr := c.Get(ctx, url)
+		if gerr != nil {
+			return nil, gerr
+		}
+
+		rebased, rerr := p.rebase(base.Doc, current.Doc)
+		if rerr != nil {
+			return nil, fmt.Errorf("%w: %s", err, rerr)
+		}
+
+		p = rebased
+
+		base = current
+	}
+}
+
+// pendingPatch is a local change, encoded as MediaType.
+type pendingPatch struct {
+	mediaType string
+	data      []byte
+}
+
+func (c *Client) diff(original, modified []byte) (*pendingPatch, error) {
+	mediaType := c.MediaType
+	if mediaType == "" {
+		mediaType = MergePatchType
+	}
+
+	var data []byte
+	var err error
+
+	switch mediaType {
+	case MergePatchType:
+		data, err = jsonpatch.CreateMergePatch(original, modified)
+	case JSONPatchType:
+		var patch jsonpatch.Patch
+		if patch, err = jsonpatch.CreatePatch(original, modified); err == nil {
+			data, err = json.Marshal(patch)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported patch media type %q", mediaType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pendingPatch{mediaType: mediaType, data: data}, nil
+}
+
+func (p *pendingPatch) empty() bool {
+	data := bytes.TrimSpace(p.data)
+	return bytes.Equal(data, []byte("{}")) || bytes.Equal(data, []byte("[]")) || bytes.Equal(data, []byte("null"))
+}
+
+func (p *pendingPatch) apply(doc []byte) ([]byte, error) {
+	if p.mediaType == MergePatchType {
+		return jsonpatch.MergePatch(doc, p.data)
+	}
+
+	patch, err := jsonpatch.DecodePatch(p.data)
+	if err != nil {
+		return nil, err
+	}
+
+	return patch.Apply(doc)
+}
+
+// rebase rewrites the JSON Patch, made against original, to apply to
+// current. It is transformed against the changes from original to current,
+// its own changes winning over concurrent ones. It fails when some of its
+// operations would be dropped or turned into others, as they change values
+// that were concurrently removed or replaced.
+func (p *pendingPatch) rebase(original, current []byte) (*pendingPatch, error) {
+	if p.mediaType != JSONPatchType {
+		return nil, fmt.Errorf("unable to rebase a patch of type %q", p.mediaType)
+	}
+
+	local, err := jsonpatch.DecodePatch(p.data)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := jsonpatch.CreatePatch(original, current)
+	if err != nil {
+		return nil, err
+	}
+
+	_, rebased, err := jsonpatch.TransformWithOptions(remote, local, &jsonpatch.TransformOptions{TieBreak: jsonpatch.SecondWins})
+	if err != nil {
+		return nil, fmt.Errorf("unable to rebase patch: %w", err)
+	}
+
+	if lost(local, rebased) {
+		return nil, errors.New("unable to rebase patch: its changes to values concurrently removed or replaced would be lost")
+	}
+
+	data, err := json.Marshal(rebased)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pendingPatch{mediaType: p.mediaType, data: data}, nil
+}
+
+// lost reports whether rebasing local gave rebased at the cost of some of its
+// changes. Only adds may change kind, becoming replaces of array elements.
+func lost(local, rebased jsonpatch.Patch) bool {
+	if len(local) != len(rebased) {
+		return true
+	}
+
+	for i, op := range local {
+		if kind := rebased[i].Kind(); kind != op.Kind() && !(op.Kind() == "add" && kind == "replace") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Client) send(ctx context.Context, url, etag string, patch []byte) (*Resource, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(patch))
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := c.MediaType
+	if mediaType == "" {
+		mediaType = MergePatchType
+	}
+
+	req.Header.Set("Content-Type", mediaType)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("If-Match", etag)
+
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) (*Resource, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var problem *Problem
+
+		if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil && mediaType == ProblemType {
+			problem = &Problem{}
+			if err := json.Unmarshal(body, problem); err != nil {
+				problem = nil
+			}
+		}
+
+		return nil, NewResponseError(res.StatusCode, problem)
+	}
+
+	r := &Resource{ETag: res.Header.Get("ETag")}
+	if len(bytes.TrimSpace(body)) > 0 {
+		r.Doc = body
+	}
+
+	return r, nil
+}
@@ -0,0 +1,295 @@
+package httppatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// newServer serves the documents of store with GET, and patches them with a
+// Handler.
+func newServer(store Store) *httptest.Server {
+	h := &Handler{Store: store}
+
+	return httptest.NewServer(h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc, etag, err := store.Load(r.Context(), r.URL.Path)
+		if err != nil {
+			h.writeStoreError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", quoteETag(etag))
+		_, _ = w.Write(doc)
+	})))
+}
+
+func TestClientRebase(t *testing.T) {
+	cases := []struct {
+		mediaType string
+		original  string
+		local     string
+		remote    string
+		result    string
+	}{
+		// Merge patches are not rebased, as they would overwrite the
+		// concurrent changes to the members they set.
+		{
+			MergePatchType,
+			`{"name": "a", "tags": ["x"], "count": 1}`,
+			`{"name": "b", "tags": ["x"], "count": 1}`,
+			`{"name": "a", "tags": ["x"], "count": 2}`,
+			``,
+		},
+		{
+			JSONPatchType,
+			`{"list": ["a", "b"], "count": 1}`,
+			`{"list": ["a", "B"], "count": 1}`,
+			`{"list": ["z", "a", "b"], "count": 2}`,
+			`{"list": ["z", "a", "B"], "count": 2}`,
+		},
+		{
+			JSONPatchType,
+			`{"name": "a"}`,
+			`{"name": "local"}`,
+			`{"name": "remote"}`,
+			`{"name": "local"}`,
+		},
+		{
+			JSONPatchType,
+			`{"list": [1], "count": 1}`,
+			`{"list": [1, 2], "count": 1}`,
+			`{"list": [1, 3], "count": 2}`,
+			`{"list": [1, 2, 3], "count": 2}`,
+		},
+		// The change to /obj/x would be lost.
+		{
+			JSONPatchType,
+			`{"obj": {"x": 1}, "count": 1}`,
+			`{"obj": {"x": 2}, "count": 2}`,
+			`{"count": 1}`,
+			``,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			store := newMemoryStore(map[string]string{"/doc": c.original})
+			server := newServer(store)
+			defer server.Close()
+
+			client := NewClient()
+			client.MediaType = c.mediaType
+
+			ctx := context.Background()
+
+			original, err := client.Get(ctx, server.URL+"/doc")
+			if err != nil {
+				t.Fatalf("unable to get resource: %s", err)
+			}
+
+			// A concurrent change.
+			if _, err := store.Store(ctx, "/doc", []byte(c.remote), "1"); err != nil {
+				t.Fatalf("unable to store: %s", err)
+			}
+
+			res, err := client.Patch(ctx, server.URL+"/doc", original, []byte(c.local))
+			if c.result == "" {
+				if !errors.Is(err, ErrConflict) {
+					t.Fatalf("expected a conflict, got %v", err)
+				}
+
+				if stored, _, _ := store.Load(ctx, "/doc"); !jsonpatch.Equal(stored, []byte(c.remote)) {
+					t.Errorf("expected the concurrent change to be kept, got %s", stored)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to patch: %s", err)
+			}
+
+			if !jsonpatch.Equal(res.Doc, []byte(c.result)) {
+				t.Errorf("expected %s, got %s", c.result, res.Doc)
+			}
+
+			if res.ETag != `"3"` {
+				t.Errorf("unexpected ETag %s", res.ETag)
+			}
+
+			stored, _, _ := store.Load(ctx, "/doc")
+			if !jsonpatch.Equal(stored, []byte(c.result)) {
+				t.Errorf("expected %s to be stored, got %s", c.result, stored)
+			}
+		})
+	}
+}
+
+func TestPendingPatchRebase(t *testing.T) {
+	cases := []struct {
+		original, current string
+		local             string
+		result            string
+		indeterminate     bool
+		lost              bool
+	}{
+		{
+			`{"list": [1]}`,
+			`{"list": [1, 3]}`,
+			`[{"op": "add", "path": "/list/-", "value": 2}]`,
+			`{"list": [1, 3, 2]}`,
+			false,
+			false,
+		},
+		{
+			`{"list": [{"k": 1}, {"k": 2}], "obj": {}}`,
+			`{"list": [{"k": 2}], "obj": {}}`,
+			`[{"op": "move", "from": "/list/1", "path": "/obj/k"}]`,
+			`{"list": [], "obj": {"k": {"k": 2}}}`,
+			false,
+			false,
+		},
+		{
+			`{"list": [1, 2], "obj": {"x": 1}}`,
+			`{"list": [1, 2], "obj": {"x": 2}}`,
+			`[{"op": "move", "from": "/list/0", "path": "/list/-"}]`,
+			`{"list": [2, 1], "obj": {"x": 2}}`,
+			false,
+			false,
+		},
+		{
+			`{"obj": {"x": 1, "y": 2}, "n": 1}`,
+			`{"n": 1}`,
+			`[{"op": "replace", "path": "/obj/x", "value": 3}, {"op": "replace", "path": "/n", "value": 2}]`,
+			``,
+			false,
+			true,
+		},
+		{
+			`{"obj": {"x": 1, "y": 2}, "n": 1}`,
+			`{"n": 1}`,
+			`[{"op": "move", "from": "/obj/x", "path": "/n"}]`,
+			``,
+			true,
+			false,
+		},
+		{
+			`{"list": [{"k": 1}, 2]}`,
+			`{"list": [{"k": 5}, 2]}`,
+			`[{"op": "move", "from": "/list/0", "path": "/list/-"}]`,
+			``,
+			true,
+			false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p := &pendingPatch{mediaType: JSONPatchType, data: []byte(c.local)}
+
+			rebased, err := p.rebase([]byte(c.original), []byte(c.current))
+			if c.indeterminate {
+				if !errors.Is(err, jsonpatch.ErrIndeterminate) {
+					t.Fatalf("expected ErrIndeterminate, got %v", err)
+				}
+				return
+			}
+			if c.lost {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", rebased.data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to rebase patch: %s", err)
+			}
+
+			out, err := rebased.apply([]byte(c.current))
+			if err != nil {
+				t.Fatalf("unable to apply rebased patch %s: %s", rebased.data, err)
+			}
+
+			if !jsonpatch.Equal(out, []byte(c.result)) {
+				t.Errorf("expected %s, got %s", c.result, out)
+			}
+		})
+	}
+}
+
+// conflictingStore changes the resource before every write.
+type conflictingStore struct {
+	*memoryStore
+	writes int32
+}
+
+func (s *conflictingStore) Store(ctx context.Context, key string, doc []byte, etag string) (string, error) {
+	atomic.AddInt32(&s.writes, 1)
+
+	current, currentETag, _ := s.memoryStore.Load(ctx, key)
+	_, _ = s.memoryStore.Store(ctx, key, current, currentETag)
+
+	return s.memoryStore.Store(ctx, key, doc, etag)
+}
+
+func TestClientMaxRetries(t *testing.T) {
+	store := &conflictingStore{memoryStore: newMemoryStore(map[string]string{"/doc": `{"n": 1}`})}
+	server := newServer(store)
+	defer server.Close()
+
+	client := NewClient()
+	client.MediaType = JSONPatchType
+	client.MaxRetries = 2
+
+	_, err := client.Update(context.Background(), server.URL+"/doc", func(doc []byte) ([]byte, error) {
+		return []byte(`{"n": 2}`), nil
+	})
+
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) || resErr.StatusCode() != http.StatusPreconditionFailed || !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	if resErr.Problem() == nil || resErr.Problem().Status != http.StatusPreconditionFailed {
+		t.Errorf("expected problem details, got %v", resErr.Problem())
+	}
+
+	if store.writes != 3 {
+		t.Errorf("expected 3 attempts, got %d", store.writes)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	store := newMemoryStore(map[string]string{"/doc": `{"n": 1}`})
+	server := newServer(store)
+	defer server.Close()
+
+	client := NewClient()
+	ctx := context.Background()
+
+	if _, err := client.Get(ctx, server.URL+"/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	// Unchanged documents are not sent.
+	res, err := client.Update(ctx, server.URL+"/doc", func(doc []byte) ([]byte, error) {
+		return doc, nil
+	})
+	if err != nil || res.ETag != `"1"` {
+		t.Errorf("unexpected result %v, %v", res, err)
+	}
+
+	// Without an ETag, the patch could not be made conditional.
+	_, err = client.Patch(ctx, server.URL+"/doc", &Resource{Doc: []byte(`{"n": 1}`)}, []byte(`{"n": 2}`))
+	if !errors.Is(err, ErrNoETag) {
+		t.Errorf("expected ErrNoETag, got %v", err)
+	}
+
+	if stored, _, _ := store.Load(ctx, "/doc"); !jsonpatch.Equal(stored, []byte(`{"n": 1}`)) {
+		t.Errorf("expected the resource to be unchanged, got %s", stored)
+	}
+}
@@ -0,0 +1,369 @@
+// Package httppatch implements HTTP PATCH for JSON documents, accepting JSON
+// Patch (RFC 6902) and JSON Merge Patch (RFC 7396) request bodies.
+package httppatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+const (
+	// JSONPatchType is the media type of JSON Patch documents.
+	JSONPatchType = "application/json-patch+json"
+	// MergePatchType is the media type of JSON Merge Patch documents.
+	MergePatchType = "application/merge-patch+json"
+	// ProblemType is the media type of the problem details of failed
+	// requests.
+	ProblemType = "application/problem+json"
+)
+
+var (
+	// ErrNotFound is returned by a Store when there is no resource for a
+	// key.
+	ErrNotFound = errors.New("resource not found")
+	// ErrConflict is returned by a Store when the resource changed since it
+	// was loaded.
+	ErrConflict = errors.New("resource was modified")
+	// ErrNoETag is returned by a Client for resources without an ETag, which
+	// it cannot patch conditionally.
+	ErrNoETag = errors.New("resource has no ETag")
+)
+
+// Store loads and stores the resources patched by a Handler. ETags are
+// opaque values, without the quotes of HTTP entity tags.
+type Store interface {
+	// Load returns the document stored at key and its ETag, or ErrNotFound.
+	Load(ctx context.Context, key string) (doc []byte, etag string, err error)
+	// Store replaces the document stored at key if its ETag is still etag,
+	// and returns the new ETag. It returns ErrConflict otherwise.
+	Store(ctx context.Context, key string, doc []byte, etag string) (string, error)
+}
+
+// Handler is an http.Handler applying PATCH requests to the resources of a
+// Store.
+type Handler struct {
+	// Store holds the resources.
+	Store Store
+	// Key returns the key of the resource a request applies to.
+	// Default to the path of the request URL.
+	Key func(r *http.Request) string
+	// Options are used to decode and apply JSON Patch documents, including
+	// their limits.
+	// Default to jsonpatch.NewApplyOptions().
+	Options *jsonpatch.ApplyOptions
+	// MaxBodySize limits the size in bytes of request bodies.
+	// Default to 0, which means no limit.
+	MaxBodySize int64
+	// RequireIfMatch rejects requests without an If-Match header with 428
+	// Precondition Required.
+	// Default to false.
+	RequireIfMatch bool
+	// ErrorLog is given the errors answered with 500 Internal Server Error,
+	// whose details are not sent to the client.
+	// Default to nil, which logs them with the standard logger of the log
+	// package.
+	ErrorLog func(err error)
+}
+
+// Problem holds the details of a failed request, as defined by RFC 7807.
+type Problem struct {
+	Type   string `json:"type,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// AcceptPatch is the value of the Accept-Patch header advertising the
+// supported media types.
+var AcceptPatch = JSONPatchType + ", " + MergePatchType
+
+// Middleware returns a handler applying PATCH requests with h, and passing
+// other requests to next. The responses of next advertise Accept-Patch.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Accept-Patch", AcceptPatch)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP implements http.Handler. It answers with the patched document
+// and its ETag.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", AcceptPatch)
+
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", http.MethodPatch)
+		writeProblem(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not supported", r.Method))
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != JSONPatchType && mediaType != MergePatchType) {
+		writeProblem(w, http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported patch document type %q", r.Header.Get("Content-Type")))
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" && h.RequireIfMatch {
+		writeProblem(w, http.StatusPreconditionRequired, "the request must be conditional with If-Match")
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if h.MaxBodySize > 0 {
+		body = io.LimitReader(r.Body, h.MaxBodySize+1)
+	}
+
+	patch, err := io.ReadAll(body)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if h.MaxBodySize > 0 && int64(len(patch)) > h.MaxBodySize {
+		writeProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body exceeds %d bytes", h.MaxBodySize))
+		return
+	}
+
+	key := r.URL.Path
+	if h.Key != nil {
+		key = h.Key(r)
+	}
+
+	doc, etag, err := h.load(r.Context(), key)
+	if err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	if ifMatch != "" && !matchETag(ifMatch, etag) {
+		writeProblem(w, http.StatusPreconditionFailed, "the resource does not match If-Match")
+		return
+	}
+
+	options := h.Options
+	if options == nil {
+		options = jsonpatch.NewApplyOptions()
+	}
+
+	var out []byte
+
+	if mediaType == JSONPatchType {
+		out, err = applyPatch(doc, patch, options)
+	} else {
+		out, err = applyMergePatch(doc, patch, options)
+	}
+
+	if err != nil {
+		if status := statusOf(err); status == http.StatusInternalServerError {
+			h.writeInternalError(w, err)
+		} else {
+			writeProblem(w, status, err.Error())
+		}
+		return
+	}
+
+	etag, err = h.Store.Store(r.Context(), key, out, etag)
+	if err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", quoteETag(etag))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// internalError is returned for failures of the server rather than of the
+// request, such as stored documents that cannot be decoded.
+type internalError struct {
+	err error
+}
+
+func (i *internalError) Error() string {
+	return i.err.Error()
+}
+
+func (i *internalError) Unwrap() error {
+	return i.err
+}
+
+// load returns the document stored at key and its ETag. Stored documents that
+// are not valid JSON are reported as an internalError, whatever the type of
+// the patch.
+func (h *Handler) load(ctx context.Context, key string) ([]byte, string, error) {
+	doc, etag, err := h.Store.Load(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !json.Valid(doc) {
+		return nil, "", &internalError{err: fmt.Errorf("stored document %q: %w", key, jsonpatch.ErrBadJSONDoc)}
+	}
+
+	return doc, etag, nil
+}
+
+// malformedError is returned for patch documents that cannot be decoded.
+type malformedError struct {
+	err error
+}
+
+func (m *malformedError) Error() string {
+	return fmt.Sprintf("malformed patch document: %s", m.err)
+}
+
+func (m *malformedError) Unwrap() error {
+	return m.err
+}
+
+func applyPatch(doc, data []byte, options *jsonpatch.ApplyOptions) ([]byte, error) {
+	patch, err := jsonpatch.DecodePatchWithOptions(data, options)
+	if err != nil {
+		return nil, &malformedError{err: err}
+	}
+
+	return patch.ApplyWithOptions(doc, options)
+}
+
+func applyMergePatch(doc, data []byte, options *jsonpatch.ApplyOptions) ([]byte, error) {
+	// The sizes are checked before merging, and before comparing the result
+	// with doc, as both cost more for larger documents.
+	if options.MaxPatchSize > 0 && int64(len(data)) > options.MaxPatchSize {
+		return nil, jsonpatch.NewPatchSizeError(options.MaxPatchSize, int64(len(data)))
+	}
+
+	out, err := jsonpatch.MergePatch(doc, data)
+	if errors.Is(err, jsonpatch.ErrBadJSONPatch) {
+		return nil, &malformedError{err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if options.MaxDocumentSize > 0 && int64(len(out)) > options.MaxDocumentSize {
+		return nil, jsonpatch.NewDocumentSizeError(options.MaxDocumentSize, int64(len(out)))
+	}
+
+	// The merge patch is applied as the equivalent JSON Patch, so that the
+	// policy and the limits of options apply to it as well.
+	patch, err := jsonpatch.CreatePatch(doc, out)
+	if err != nil {
+		return nil, err
+	}
+
+	return patch.ApplyWithOptions(doc, options)
+}
+
+func isLimitError(err error) bool {
+	var (
+		count  *jsonpatch.OperationCountError
+		depth  *jsonpatch.PointerDepthError
+		token  *jsonpatch.PointerTokenLengthError
+		nest   *jsonpatch.NestingDepthError
+		doc    *jsonpatch.DocumentSizeError
+		patch  *jsonpatch.PatchSizeError
+		value  *jsonpatch.ValueSizeError
+		copied *jsonpatch.AccumulatedCopySizeError
+	)
+
+	return errors.As(err, &count) || errors.As(err, &depth) || errors.As(err, &token) ||
+		errors.As(err, &nest) || errors.As(err, &doc) || errors.As(err, &patch) ||
+		errors.As(err, &value) || errors.As(err, &copied)
+}
+
+// statusOf returns the status code of a failed patch, following RFC 5789.
+func statusOf(err error) int {
+	var (
+		policy    *jsonpatch.PolicyError
+		malformed *malformedError
+	)
+
+	switch {
+	case errors.As(err, &policy):
+		return http.StatusForbidden
+	case isLimitError(err):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &malformed):
+		return http.StatusBadRequest
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return http.StatusConflict
+	case errors.Is(err, jsonpatch.ErrBadJSONDoc):
+		return http.StatusInternalServerError
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+func (h *Handler) writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeProblem(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrConflict):
+		writeProblem(w, http.StatusPreconditionFailed, err.Error())
+	default:
+		h.writeInternalError(w, err)
+	}
+}
+
+// writeInternalError answers with 500 Internal Server Error, and passes err
+// to ErrorLog rather than to the client.
+func (h *Handler) writeInternalError(w http.ResponseWriter, err error) {
+	if h.ErrorLog != nil {
+		h.ErrorLog(err)
+	} else {
+		log.Printf("httppatch: %s", err)
+	}
+
+	writeProblem(w, http.StatusInternalServerError, "the request could not be completed")
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	data, err := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+	if err != nil {
+		http.Error(w, detail, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemType)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// matchETag reports whether an If-Match header matches etag. Weak entity
+// tags never match, as If-Match uses the strong comparison.
+func matchETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || tag == quoteETag(etag) {
+			return true
+		}
+	}
+
+	return false
+}
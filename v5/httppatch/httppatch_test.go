@@ -0,0 +1,310 @@
+package httppatch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	docs    map[string][]byte
+	version map[string]int
+}
+
+func newMemoryStore(docs map[string]string) *memoryStore {
+	s := &memoryStore{docs: map[string][]byte{}, version: map[string]int{}}
+	for k, v := range docs {
+		s.docs[k] = []byte(v)
+		s.version[k] = 1
+	}
+	return s
+}
+
+func (s *memoryStore) Load(ctx context.Context, key string) ([]byte, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.docs[key]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	return doc, strconv.Itoa(s.version[key]), nil
+}
+
+func (s *memoryStore) Store(ctx context.Context, key string, doc []byte, etag string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strconv.Itoa(s.version[key]) != etag {
+		return "", ErrConflict
+	}
+
+	s.docs[key] = doc
+	s.version[key]++
+
+	return strconv.Itoa(s.version[key]), nil
+}
+
+func TestHandler(t *testing.T) {
+	cases := []struct {
+		method      string
+		path        string
+		contentType string
+		ifMatch     string
+		body        string
+		status      int
+		result      string
+	}{
+		{"PATCH", "/doc", JSONPatchType, "", `[{"op": "replace", "path": "/a", "value": 2}]`, 200, `{"a": 2, "b": {"c": 1}}`},
+		{"PATCH", "/doc", MergePatchType + "; charset=utf-8", `"1"`, `{"b": {"c": null, "d": true}}`, 200, `{"a": 1, "b": {"d": true}}`},
+		{"PATCH", "/doc", JSONPatchType, `W/"1"`, `[]`, 412, ""},
+		{"PATCH", "/doc", JSONPatchType, `"2", "3"`, `[]`, 412, ""},
+		{"PATCH", "/doc", JSONPatchType, `*`, `[]`, 200, `{"a": 1, "b": {"c": 1}}`},
+		{"PATCH", "/missing", JSONPatchType, "", `[]`, 404, ""},
+		{"PATCH", "/doc", "application/json", "", `[]`, 415, ""},
+		{"PUT", "/doc", JSONPatchType, "", `[]`, 405, ""},
+		{"PATCH", "/doc", JSONPatchType, "", `[{"op": "explode"}]`, 400, ""},
+		{"PATCH", "/doc", JSONPatchType, "", `[{"op": "test", "path": "/a", "value": 2}]`, 409, ""},
+		{"PATCH", "/doc", JSONPatchType, "", `[{"op": "remove", "path": "/x"}]`, 422, ""},
+		{"PATCH", "/doc", JSONPatchType, "", `[{"op": "replace", "path": "/id", "value": 2}]`, 403, ""},
+		{"PATCH", "/doc", MergePatchType, "", `{"id": 2}`, 403, ""},
+		{"PATCH", "/doc", JSONPatchType, "", `[{"op": "add", "path": "/x", "value": "` + strings.Repeat("x", 32) + `"}]`, 413, ""},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := jsonpatch.NewApplyOptions()
+			options.MaxValueSize = 16
+			options.Policy = &jsonpatch.Policy{
+				Rules: []jsonpatch.PolicyRule{{Effect: jsonpatch.PolicyDeny, Path: "/id"}},
+			}
+
+			h := &Handler{
+				Store:   newMemoryStore(map[string]string{"/doc": `{"a": 1, "b": {"c": 1}}`}),
+				Options: options,
+			}
+
+			r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			r.Header.Set("Content-Type", c.contentType)
+			if c.ifMatch != "" {
+				r.Header.Set("If-Match", c.ifMatch)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("expected status %d, got %d: %s", c.status, w.Code, w.Body)
+			}
+
+			if got := w.Header().Get("Accept-Patch"); got != AcceptPatch {
+				t.Errorf("unexpected Accept-Patch %q", got)
+			}
+
+			if c.status != http.StatusOK {
+				if ct := w.Header().Get("Content-Type"); ct != ProblemType {
+					t.Errorf("unexpected content type %q", ct)
+				}
+
+				var p Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Status != c.status || p.Detail == "" {
+					t.Errorf("unexpected problem %s", w.Body)
+				}
+				return
+			}
+
+			if etag := w.Header().Get("ETag"); etag != `"2"` {
+				t.Errorf("unexpected ETag %q", etag)
+			}
+
+			if !jsonpatch.Equal(w.Body.Bytes(), []byte(c.result)) {
+				t.Errorf("expected %s, got %s", c.result, w.Body)
+			}
+		})
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	h := &Handler{
+		Store:          newMemoryStore(map[string]string{"/doc": `{}`}),
+		MaxBodySize:    8,
+		RequireIfMatch: true,
+	}
+
+	r := httptest.NewRequest("PATCH", "/doc", strings.NewReader(`[]`))
+	r.Header.Set("Content-Type", JSONPatchType)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected status 428, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("PATCH", "/doc", strings.NewReader(`[{"op": "remove", "path": "/a"}]`))
+	r.Header.Set("Content-Type", JSONPatchType)
+	r.Header.Set("If-Match", `"1"`)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", w.Code)
+	}
+
+	// Merge patches and their results are held to the limits of Options.
+	options := jsonpatch.NewApplyOptions()
+	options.MaxPatchSize = 32
+	options.MaxDocumentSize = 64
+
+	h = &Handler{
+		Store:   newMemoryStore(map[string]string{"/doc": `{"a": "` + strings.Repeat("x", 40) + `"}`}),
+		Options: options,
+	}
+
+	for _, c := range []struct{ body, detail string }{
+		{`{"b": "` + strings.Repeat("x", 32) + `"}`, "Unable to accept a patch of 41 bytes"},
+		{`{"b": "` + strings.Repeat("x", 20) + `"}`, "Unable to accept a document of 75 bytes"},
+	} {
+		r = httptest.NewRequest("PATCH", "/doc", strings.NewReader(c.body))
+		r.Header.Set("Content-Type", MergePatchType)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		var p Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || w.Code != http.StatusRequestEntityTooLarge || !strings.HasPrefix(p.Detail, c.detail) {
+			t.Errorf("%s: expected status 413 for %q, got %d: %s", c.body, c.detail, w.Code, w.Body)
+		}
+	}
+}
+
+func TestHandlerConflict(t *testing.T) {
+	store := newMemoryStore(map[string]string{"/doc": `{"n": 0}`})
+
+	// A concurrent write between Load and Store.
+	racing := &racingStore{memoryStore: store}
+
+	h := &Handler{Store: racing}
+
+	r := httptest.NewRequest("PATCH", "/doc", strings.NewReader(`{"n": 1}`))
+	r.Header.Set("Content-Type", MergePatchType)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412, got %d", w.Code)
+	}
+}
+
+type racingStore struct {
+	*memoryStore
+}
+
+func (s *racingStore) Load(ctx context.Context, key string) ([]byte, string, error) {
+	doc, etag, err := s.memoryStore.Load(ctx, key)
+	if err == nil {
+		_, _ = s.memoryStore.Store(ctx, key, []byte(`{"n": 2}`), etag)
+	}
+	return doc, etag, err
+}
+
+// failingStore fails to store documents.
+type failingStore struct {
+	*memoryStore
+	err error
+}
+
+func (s *failingStore) Store(ctx context.Context, key string, doc []byte, etag string) (string, error) {
+	return "", s.err
+}
+
+func TestHandlerInternalError(t *testing.T) {
+	storeErr := errors.New("connection to db.internal:5432 refused")
+
+	var logged []error
+	h := &Handler{
+		Store:    &failingStore{memoryStore: newMemoryStore(map[string]string{"/doc": `{}`}), err: storeErr},
+		ErrorLog: func(err error) { logged = append(logged, err) },
+	}
+
+	r := httptest.NewRequest("PATCH", "/doc", strings.NewReader(`{"a": 1}`))
+	r.Header.Set("Content-Type", MergePatchType)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+
+	if strings.Contains(w.Body.String(), "db.internal") {
+		t.Errorf("the error is exposed to the client: %s", w.Body.String())
+	}
+
+	if len(logged) != 1 || !errors.Is(logged[0], storeErr) {
+		t.Errorf("expected the error to be logged, got %v", logged)
+	}
+
+	// A stored document that cannot be decoded is a server error as well,
+	// whatever the type of the patch.
+	h.Store = newMemoryStore(map[string]string{"/doc": `{"a": `})
+
+	for _, c := range []struct{ contentType, body string }{
+		{MergePatchType, `{"a": 1}`},
+		{JSONPatchType, `[{"op": "add", "path": "/a", "value": 1}]`},
+	} {
+		logged = nil
+
+		r = httptest.NewRequest("PATCH", "/doc", strings.NewReader(c.body))
+		r.Header.Set("Content-Type", c.contentType)
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusInternalServerError || len(logged) != 1 || !errors.Is(logged[0], jsonpatch.ErrBadJSONDoc) {
+			t.Errorf("%s: expected status 500 and the error to be logged, got %d and %v", c.contentType, w.Code, logged)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	h := &Handler{Store: newMemoryStore(map[string]string{"/doc": `{}`})}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, method := range []string{"GET", "PATCH"} {
+		r := httptest.NewRequest(method, "/doc", strings.NewReader(`{"a": 1}`))
+		r.Header.Set("Content-Type", MergePatchType)
+
+		w := httptest.NewRecorder()
+		h.Middleware(next).ServeHTTP(w, r)
+
+		expected := http.StatusNoContent
+		if method == "PATCH" {
+			expected = http.StatusOK
+		}
+
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", method, expected, w.Code)
+		}
+
+		if w.Header().Get("Accept-Patch") != AcceptPatch {
+			t.Errorf("%s: missing Accept-Patch", method)
+		}
+	}
+}
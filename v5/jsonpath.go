@@ -0,0 +1,1244 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// EvaluateJSONPath evaluates a JSONPath query (RFC 9535) against doc, and
+// returns the JSON Pointers of the nodes it selects, in the order of the
+// resulting nodelist. A query may select the same node more than once.
+func EvaluateJSONPath(doc []byte, query string) ([]string, error) {
+	q, err := parseJSONPath(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(doc) {
+		return nil, ErrInvalid
+	}
+
+	root := jpNode{node: newLazyNode(newRawMessage(doc))}
+
+	nodes := q.eval(root, root)
+
+	pointers := make([]string, len(nodes))
+	for i, n := range nodes {
+		pointers[i] = joinPointer(n.path)
+	}
+
+	return pointers, nil
+}
+
+// The kinds of selector of a JSONPath segment.
+const (
+	jpName = iota
+	jpWildcard
+	jpIndex
+	jpSlice
+	jpFilter
+)
+
+type jsonPath struct {
+	relative bool
+	segments []jpSegment
+}
+
+type jpSegment struct {
+	descendant bool
+	selectors  []jpSelector
+}
+
+type jpSelector struct {
+	kind             int
+	name             string
+	index            int64
+	start, end, step *int64
+	filter           jpExpr
+}
+
+// jpNode is a node of the document along with its location.
+type jpNode struct {
+	path []string
+	node *lazyNode
+}
+
+// singular reports whether a query selects at most one node.
+func (q *jsonPath) singular() bool {
+	for _, seg := range q.segments {
+		if seg.descendant || len(seg.selectors) != 1 {
+			return false
+		}
+
+		if k := seg.selectors[0].kind; k != jpName && k != jpIndex {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q *jsonPath) eval(root, cur jpNode) []jpNode {
+	nodes := []jpNode{root}
+	if q.relative {
+		nodes = []jpNode{cur}
+	}
+
+	for _, seg := range q.segments {
+		var next []jpNode
+
+		for _, n := range nodes {
+			if !seg.descendant {
+				next = seg.selectFrom(n, root, next)
+				continue
+			}
+
+			for _, d := range jpDescendants(n, nil) {
+				next = seg.selectFrom(d, root, next)
+			}
+		}
+
+		nodes = next
+	}
+
+	return nodes
+}
+
+func (seg *jpSegment) selectFrom(n, root jpNode, out []jpNode) []jpNode {
+	for _, sel := range seg.selectors {
+		switch sel.kind {
+		case jpName:
+			if nodeType(n.node) != nodeObject {
+				continue
+			}
+
+			doc, err := n.node.intoDoc(NewApplyOptions())
+			if err != nil {
+				continue
+			}
+
+			if child, ok := doc.obj[sel.name]; ok {
+				out = append(out, jpNode{appendPointer(n.path, sel.name), child})
+			}
+		case jpWildcard:
+			out = append(out, jpChildren(n)...)
+		case jpIndex:
+			elems := jpElements(n)
+
+			idx := sel.index
+			if idx < 0 {
+				idx += int64(len(elems))
+			}
+
+			if idx >= 0 && idx < int64(len(elems)) {
+				out = append(out, elems[idx])
+			}
+		case jpSlice:
+			elems := jpElements(n)
+			for _, i := range jpSliceIndices(sel, int64(len(elems))) {
+				out = append(out, elems[i])
+			}
+		case jpFilter:
+			for _, child := range jpChildren(n) {
+				if sel.filter.eval(root, child) {
+					out = append(out, child)
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+// jpChildren returns the member values of an object, or the elements of an
+// array, in document order.
+func jpChildren(n jpNode) []jpNode {
+	if nodeType(n.node) == nodeArray {
+		return jpElements(n)
+	}
+
+	if nodeType(n.node) != nodeObject {
+		return nil
+	}
+
+	doc, err := n.node.intoDoc(NewApplyOptions())
+	if err != nil {
+		return nil
+	}
+
+	var out []jpNode
+	seen := map[string]bool{}
+
+	for _, k := range doc.keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		out = append(out, jpNode{appendPointer(n.path, k), doc.obj[k]})
+	}
+
+	return out
+}
+
+func jpElements(n jpNode) []jpNode {
+	if nodeType(n.node) != nodeArray {
+		return nil
+	}
+
+	ary, err := n.node.intoAry()
+	if err != nil {
+		return nil
+	}
+
+	out := make([]jpNode, len(ary.nodes))
+	for i, e := range ary.nodes {
+		out[i] = jpNode{appendPointer(n.path, strconv.Itoa(i)), e}
+	}
+
+	return out
+}
+
+// jpDescendants returns n and all of its descendants, parents before their
+// children.
+func jpDescendants(n jpNode, out []jpNode) []jpNode {
+	out = append(out, n)
+
+	for _, child := range jpChildren(n) {
+		out = jpDescendants(child, out)
+	}
+
+	return out
+}
+
+// jpSliceIndices returns the indices selected by a slice selector from an
+// array of length n, as described in RFC 9535 section 2.3.4.2.
+func jpSliceIndices(sel jpSelector, n int64) []int64 {
+	step := int64(1)
+	if sel.step != nil {
+		step = *sel.step
+	}
+
+	if step == 0 {
+		return nil
+	}
+
+	normalize := func(i int64) int64 {
+		if i < 0 {
+			return n + i
+		}
+		return i
+	}
+
+	clamp := func(i, lo, hi int64) int64 {
+		if i < lo {
+			return lo
+		}
+		if i > hi {
+			return hi
+		}
+		return i
+	}
+
+	var out []int64
+
+	if step > 0 {
+		start, end := int64(0), n
+		if sel.start != nil {
+			start = normalize(*sel.start)
+		}
+		if sel.end != nil {
+			end = normalize(*sel.end)
+		}
+
+		lower, upper := clamp(start, 0, n), clamp(end, 0, n)
+		for i := lower; i < upper; i += step {
+			out = append(out, i)
+		}
+
+		return out
+	}
+
+	start, end := n-1, -n-1
+	if sel.start != nil {
+		start = normalize(*sel.start)
+	}
+	if sel.end != nil {
+		end = normalize(*sel.end)
+	}
+
+	upper, lower := clamp(start, -1, n-1), clamp(end, -1, n-1)
+	for i := upper; lower < i; i += step {
+		out = append(out, i)
+	}
+
+	return out
+}
+
+// jpValue is the value of a comparable, which may be Nothing.
+type jpValue struct {
+	v       interface{}
+	nothing bool
+}
+
+var jpNothing = jpValue{nothing: true}
+
+func jpNodeValue(n jpNode) jpValue {
+	v, err := decodeNode(n.node)
+	if err != nil {
+		return jpNothing
+	}
+
+	return jpValue{v: v}
+}
+
+// jpExpr is a logical expression of a filter selector.
+type jpExpr interface {
+	eval(root, cur jpNode) bool
+}
+
+// jpOperand is a comparable: a literal, a singular query or a function
+// returning a value.
+type jpOperand interface {
+	value(root, cur jpNode) jpValue
+}
+
+type jpOr []jpExpr
+
+func (e jpOr) eval(root, cur jpNode) bool {
+	for _, x := range e {
+		if x.eval(root, cur) {
+			return true
+		}
+	}
+	return false
+}
+
+type jpAnd []jpExpr
+
+func (e jpAnd) eval(root, cur jpNode) bool {
+	for _, x := range e {
+		if !x.eval(root, cur) {
+			return false
+		}
+	}
+	return true
+}
+
+type jpNot struct {
+	expr jpExpr
+}
+
+func (e jpNot) eval(root, cur jpNode) bool {
+	return !e.expr.eval(root, cur)
+}
+
+// jpExists tests whether a query selects any node.
+type jpExists struct {
+	query *jsonPath
+}
+
+func (e jpExists) eval(root, cur jpNode) bool {
+	return len(e.query.eval(root, cur)) > 0
+}
+
+type jpComparison struct {
+	op          string
+	left, right jpOperand
+}
+
+func (e jpComparison) eval(root, cur jpNode) bool {
+	a, b := e.left.value(root, cur), e.right.value(root, cur)
+
+	switch e.op {
+	case "==":
+		return jpValueEqual(a, b)
+	case "!=":
+		return !jpValueEqual(a, b)
+	case "<":
+		return jpValueLess(a, b)
+	case ">":
+		return jpValueLess(b, a)
+	case "<=":
+		return jpValueLess(a, b) || jpValueEqual(a, b)
+	default:
+		return jpValueLess(b, a) || jpValueEqual(a, b)
+	}
+}
+
+type jpLiteral struct {
+	v interface{}
+}
+
+func (l jpLiteral) value(root, cur jpNode) jpValue {
+	return jpValue{v: l.v}
+}
+
+// jpSingular is a singular query used as a comparable.
+type jpSingular struct {
+	query *jsonPath
+}
+
+func (s jpSingular) value(root, cur jpNode) jpValue {
+	nodes := s.query.eval(root, cur)
+	if len(nodes) != 1 {
+		return jpNothing
+	}
+
+	return jpNodeValue(nodes[0])
+}
+
+func jpValueEqual(a, b jpValue) bool {
+	if a.nothing || b.nothing {
+		return a.nothing && b.nothing
+	}
+
+	return jpEqual(a.v, b.v)
+}
+
+func jpEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		bv, ok := b.(bool)
+		return ok && a == bv
+	case string:
+		bv, ok := b.(string)
+		return ok && a == bv
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+
+		c, ok := jpCompareNumbers(a, bv)
+		return ok && c == 0
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(a) != len(bv) {
+			return false
+		}
+
+		for i := range a {
+			if !jpEqual(a[i], bv[i]) {
+				return false
+			}
+		}
+
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(bv) {
+			return false
+		}
+
+		for k, v := range a {
+			ov, ok := bv[k]
+			if !ok || !jpEqual(v, ov) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// jpValueLess orders numbers and strings. Other values are not ordered.
+func jpValueLess(a, b jpValue) bool {
+	if a.nothing || b.nothing {
+		return false
+	}
+
+	switch av := a.v.(type) {
+	case json.Number:
+		bv, ok := b.v.(json.Number)
+		if !ok {
+			return false
+		}
+
+		c, ok := jpCompareNumbers(av, bv)
+		return ok && c < 0
+	case string:
+		// Comparing UTF-8 bytes orders strings by their Unicode scalar values.
+		bv, ok := b.v.(string)
+		return ok && av < bv
+	}
+
+	return false
+}
+
+func jpCompareNumbers(a, b json.Number) (int, bool) {
+	x, ok := new(big.Rat).SetString(string(a))
+	if !ok {
+		return 0, false
+	}
+
+	y, ok := new(big.Rat).SetString(string(b))
+	if !ok {
+		return 0, false
+	}
+
+	return x.Cmp(y), true
+}
+
+// The types of the function extensions of RFC 9535 section 2.4.
+const (
+	jpValueType = iota
+	jpLogicalType
+	jpNodesType
+)
+
+type jpFunctionType struct {
+	params []int
+	result int
+}
+
+var jpFunctions = map[string]jpFunctionType{
+	"length": {[]int{jpValueType}, jpValueType},
+	"count":  {[]int{jpNodesType}, jpValueType},
+	"match":  {[]int{jpValueType, jpValueType}, jpLogicalType},
+	"search": {[]int{jpValueType, jpValueType}, jpLogicalType},
+	"value":  {[]int{jpNodesType}, jpValueType},
+}
+
+// jpArgument is the argument of a function: a query for a parameter of
+// NodesType, or a comparable for one of ValueType.
+type jpArgument struct {
+	query   *jsonPath
+	operand jpOperand
+}
+
+type jpFunction struct {
+	name string
+	args []jpArgument
+}
+
+func (f *jpFunction) value(root, cur jpNode) jpValue {
+	switch f.name {
+	case "length":
+		switch v := f.args[0].operand.value(root, cur).v; v := v.(type) {
+		case string:
+			return jpValue{v: json.Number(strconv.Itoa(utf8.RuneCountInString(v)))}
+		case []interface{}:
+			return jpValue{v: json.Number(strconv.Itoa(len(v)))}
+		case map[string]interface{}:
+			return jpValue{v: json.Number(strconv.Itoa(len(v)))}
+		}
+
+		return jpNothing
+	case "count":
+		return jpValue{v: json.Number(strconv.Itoa(len(f.args[0].query.eval(root, cur))))}
+	case "value":
+		nodes := f.args[0].query.eval(root, cur)
+		if len(nodes) != 1 {
+			return jpNothing
+		}
+
+		return jpNodeValue(nodes[0])
+	}
+
+	return jpNothing
+}
+
+func (f *jpFunction) eval(root, cur jpNode) bool {
+	s, ok := f.args[0].operand.value(root, cur).v.(string)
+	if !ok {
+		return false
+	}
+
+	pattern, ok := f.args[1].operand.value(root, cur).v.(string)
+	if !ok {
+		return false
+	}
+
+	re, err := jpRegexp(pattern, f.name == "match")
+	if err != nil {
+		return false
+	}
+
+	return re.MatchString(s)
+}
+
+// jpRegexp compiles an I-Regexp (RFC 9485). Outside of character classes,
+// "." matches any character but line terminators.
+func jpRegexp(pattern string, anchored bool) (*regexp.Regexp, error) {
+	var sb strings.Builder
+
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			sb.WriteByte(c)
+			i++
+			sb.WriteByte(pattern[i])
+			continue
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '.' && !inClass:
+			sb.WriteString(`[^\n\r]`)
+			continue
+		}
+
+		sb.WriteByte(c)
+	}
+
+	if anchored {
+		return regexp.Compile(`^(?:` + sb.String() + `)$`)
+	}
+
+	return regexp.Compile(sb.String())
+}
+
+// jpParser parses JSONPath queries, following the ABNF of RFC 9535.
+type jpParser struct {
+	query string
+	pos   int
+}
+
+func parseJSONPath(query string) (*jsonPath, error) {
+	p := &jpParser{query: query}
+
+	if !p.consume("$") {
+		return nil, p.errorf("expected '$'")
+	}
+
+	q, err := p.segments(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.query) {
+		return nil, p.errorf("unexpected %q", p.query[p.pos:])
+	}
+
+	return q, nil
+}
+
+func (p *jpParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid JSONPath %q at offset %d: %s: %w", p.query, p.pos, fmt.Sprintf(format, args...), ErrInvalid)
+}
+
+func (p *jpParser) peek() byte {
+	if p.pos < len(p.query) {
+		return p.query[p.pos]
+	}
+	return 0
+}
+
+func (p *jpParser) consume(s string) bool {
+	if strings.HasPrefix(p.query[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *jpParser) blank() {
+	for p.pos < len(p.query) {
+		switch p.query[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jpParser) segments(relative bool) (*jsonPath, error) {
+	q := &jsonPath{relative: relative}
+
+	for {
+		start := p.pos
+		p.blank()
+
+		var seg jpSegment
+		var err error
+
+		switch {
+		case p.consume(".."):
+			seg.descendant = true
+			if p.peek() == '[' {
+				seg.selectors, err = p.bracketed()
+			} else {
+				seg.selectors, err = p.shorthand()
+			}
+		case p.consume("."):
+			seg.selectors, err = p.shorthand()
+		case p.peek() == '[':
+			seg.selectors, err = p.bracketed()
+		default:
+			p.pos = start
+			return q, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		q.segments = append(q.segments, seg)
+	}
+}
+
+func isNameFirst(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r >= 0x80
+}
+
+func (p *jpParser) shorthand() ([]jpSelector, error) {
+	if p.consume("*") {
+		return []jpSelector{{kind: jpWildcard}}, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.query) {
+		r, size := utf8.DecodeRuneInString(p.query[p.pos:])
+		if !isNameFirst(r) && !(p.pos > start && r >= '0' && r <= '9') {
+			break
+		}
+		p.pos += size
+	}
+
+	if p.pos == start {
+		return nil, p.errorf("expected a member name")
+	}
+
+	return []jpSelector{{kind: jpName, name: p.query[start:p.pos]}}, nil
+}
+
+func (p *jpParser) bracketed() ([]jpSelector, error) {
+	p.consume("[")
+
+	var selectors []jpSelector
+
+	for {
+		p.blank()
+
+		sel, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, sel)
+
+		p.blank()
+
+		if p.consume("]") {
+			return selectors, nil
+		}
+
+		if !p.consume(",") {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *jpParser) selector() (jpSelector, error) {
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+		name, err := p.stringLiteral()
+		return jpSelector{kind: jpName, name: name}, err
+	case c == '*':
+		p.pos++
+		return jpSelector{kind: jpWildcard}, nil
+	case c == '?':
+		p.pos++
+		p.blank()
+
+		expr, err := p.logicalOr()
+		return jpSelector{kind: jpFilter, filter: expr}, err
+	}
+
+	var sel jpSelector
+	var err error
+
+	if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+		if sel.start, err = p.integer(); err != nil {
+			return sel, err
+		}
+		p.blank()
+	}
+
+	if !p.consume(":") {
+		if sel.start == nil {
+			return sel, p.errorf("expected a selector")
+		}
+
+		return jpSelector{kind: jpIndex, index: *sel.start}, nil
+	}
+
+	sel.kind = jpSlice
+	p.blank()
+
+	if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+		if sel.end, err = p.integer(); err != nil {
+			return sel, err
+		}
+		p.blank()
+	}
+
+	if p.consume(":") {
+		p.blank()
+
+		if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+			if sel.step, err = p.integer(); err != nil {
+				return sel, err
+			}
+		}
+	}
+
+	return sel, nil
+}
+
+// The range of integers exactly representable in I-JSON.
+const jpMaxInt = 1<<53 - 1
+
+func (p *jpParser) integer() (*int64, error) {
+	start := p.pos
+
+	p.consume("-")
+	digits := p.pos
+
+	for p.pos < len(p.query) && p.query[p.pos] >= '0' && p.query[p.pos] <= '9' {
+		p.pos++
+	}
+
+	s := p.query[start:p.pos]
+
+	if p.pos == digits || (p.query[digits] == '0' && (p.pos-digits > 1 || digits > start)) {
+		return nil, p.errorf("invalid integer %q", s)
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n > jpMaxInt || n < -jpMaxInt {
+		return nil, p.errorf("integer %q out of range", s)
+	}
+
+	return &n, nil
+}
+
+func (p *jpParser) stringLiteral() (string, error) {
+	quote := p.query[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+
+	for {
+		if p.pos >= len(p.query) {
+			return "", p.errorf("unterminated string")
+		}
+
+		c := p.query[p.pos]
+
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c < 0x20:
+			return "", p.errorf("control character in string")
+		case c != '\\':
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		p.pos++
+		if p.pos >= len(p.query) {
+			return "", p.errorf("unterminated string")
+		}
+
+		esc := p.query[p.pos]
+		p.pos++
+
+		switch esc {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '/', '\\':
+			sb.WriteByte(esc)
+		case '\'', '"':
+			if esc != quote {
+				return "", p.errorf("invalid escape \\%c", esc)
+			}
+			sb.WriteByte(esc)
+		case 'u':
+			r, err := p.unicodeEscape()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteRune(r)
+		default:
+			return "", p.errorf("invalid escape \\%c", esc)
+		}
+	}
+}
+
+func (p *jpParser) hex4() (rune, error) {
+	if p.pos+4 > len(p.query) {
+		return 0, p.errorf("invalid unicode escape")
+	}
+
+	n, err := strconv.ParseUint(p.query[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid unicode escape")
+	}
+
+	p.pos += 4
+	return rune(n), nil
+}
+
+func (p *jpParser) unicodeEscape() (rune, error) {
+	r, err := p.hex4()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case r >= 0xDC00 && r <= 0xDFFF:
+		return 0, p.errorf("unpaired low surrogate")
+	case r >= 0xD800 && r <= 0xDBFF:
+		if !p.consume(`\u`) {
+			return 0, p.errorf("unpaired high surrogate")
+		}
+
+		low, err := p.hex4()
+		if err != nil {
+			return 0, err
+		}
+
+		if low < 0xDC00 || low > 0xDFFF {
+			return 0, p.errorf("unpaired high surrogate")
+		}
+
+		return utf16.DecodeRune(r, low), nil
+	}
+
+	return r, nil
+}
+
+func (p *jpParser) logicalOr() (jpExpr, error) {
+	var or jpOr
+
+	for {
+		expr, err := p.logicalAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, expr)
+
+		start := p.pos
+		p.blank()
+
+		if !p.consume("||") {
+			p.pos = start
+			break
+		}
+		p.blank()
+	}
+
+	if len(or) == 1 {
+		return or[0], nil
+	}
+
+	return or, nil
+}
+
+func (p *jpParser) logicalAnd() (jpExpr, error) {
+	var and jpAnd
+
+	for {
+		expr, err := p.basic()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, expr)
+
+		start := p.pos
+		p.blank()
+
+		if !p.consume("&&") {
+			p.pos = start
+			break
+		}
+		p.blank()
+	}
+
+	if len(and) == 1 {
+		return and[0], nil
+	}
+
+	return and, nil
+}
+
+func (p *jpParser) basic() (jpExpr, error) {
+	if p.consume("!") {
+		p.blank()
+
+		if p.peek() == '(' {
+			expr, err := p.paren()
+			return jpNot{expr}, err
+		}
+
+		expr, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := expr.(jpComparison); ok {
+			return nil, p.errorf("a comparison cannot be negated without parentheses")
+		}
+
+		return jpNot{expr}, nil
+	}
+
+	if p.peek() == '(' {
+		return p.paren()
+	}
+
+	return p.test()
+}
+
+func (p *jpParser) paren() (jpExpr, error) {
+	p.consume("(")
+	p.blank()
+
+	expr, err := p.logicalOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.blank()
+	if !p.consume(")") {
+		return nil, p.errorf("expected ')'")
+	}
+
+	return expr, nil
+}
+
+// test parses a comparison, or a test of a query or of a function returning
+// a logical value.
+func (p *jpParser) test() (jpExpr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	start := p.pos
+	p.blank()
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.consume(op) {
+			continue
+		}
+		p.blank()
+
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := p.comparable(left)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := p.comparable(right)
+		if err != nil {
+			return nil, err
+		}
+
+		return jpComparison{op: op, left: l, right: r}, nil
+	}
+
+	p.pos = start
+
+	switch v := left.(type) {
+	case *jsonPath:
+		return jpExists{v}, nil
+	case *jpFunction:
+		if jpFunctions[v.name].result != jpLogicalType {
+			return nil, p.errorf("function %s() must be compared", v.name)
+		}
+		return v, nil
+	}
+
+	return nil, p.errorf("a literal must be compared")
+}
+
+// comparable checks that a parsed operand can be compared.
+func (p *jpParser) comparable(v interface{}) (jpOperand, error) {
+	switch v := v.(type) {
+	case *jsonPath:
+		if !v.singular() {
+			return nil, p.errorf("only singular queries can be compared")
+		}
+		return jpSingular{v}, nil
+	case *jpFunction:
+		if jpFunctions[v.name].result != jpValueType {
+			return nil, p.errorf("function %s() cannot be compared", v.name)
+		}
+		return v, nil
+	}
+
+	return v.(jpLiteral), nil
+}
+
+// operand parses a query, a function call or a literal.
+func (p *jpParser) operand() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		p.pos++
+		return p.segments(c == '@')
+	case c == '\'' || c == '"':
+		s, err := p.stringLiteral()
+		return jpLiteral{s}, err
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.query) {
+			c := p.query[p.pos]
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '_' {
+				break
+			}
+			p.pos++
+		}
+
+		name := p.query[start:p.pos]
+
+		if p.peek() == '(' {
+			return p.function(name)
+		}
+
+		switch name {
+		case "true":
+			return jpLiteral{true}, nil
+		case "false":
+			return jpLiteral{false}, nil
+		case "null":
+			return jpLiteral{nil}, nil
+		}
+
+		p.pos = start
+		return nil, p.errorf("unexpected %q", name)
+	}
+
+	return nil, p.errorf("expected a query, function or literal")
+}
+
+func (p *jpParser) number() (jpLiteral, error) {
+	start := p.pos
+
+	p.consume("-")
+	for p.pos < len(p.query) && strings.IndexByte("0123456789.eE+-", p.query[p.pos]) >= 0 {
+		p.pos++
+	}
+
+	s := p.query[start:p.pos]
+
+	if !json.Valid([]byte(s)) {
+		p.pos = start
+		return jpLiteral{}, p.errorf("invalid number %q", s)
+	}
+
+	return jpLiteral{json.Number(s)}, nil
+}
+
+func (p *jpParser) function(name string) (*jpFunction, error) {
+	typ, ok := jpFunctions[name]
+	if !ok {
+		return nil, p.errorf("unknown function %s()", name)
+	}
+
+	p.consume("(")
+
+	f := &jpFunction{name: name}
+
+	for i, param := range typ.params {
+		p.blank()
+
+		if i > 0 {
+			if !p.consume(",") {
+				return nil, p.errorf("function %s() expects %d arguments", name, len(typ.params))
+			}
+			p.blank()
+		}
+
+		arg, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+
+		if param == jpNodesType {
+			q, ok := arg.(*jsonPath)
+			if !ok {
+				return nil, p.errorf("function %s() expects a query", name)
+			}
+
+			f.args = append(f.args, jpArgument{query: q})
+			continue
+		}
+
+		operand, err := p.comparable(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		f.args = append(f.args, jpArgument{operand: operand})
+	}
+
+	p.blank()
+	if !p.consume(")") {
+		return nil, p.errorf("function %s() expects %d arguments", name, len(typ.params))
+	}
+
+	return f, nil
+}
+
+// sortPointers orders JSON Pointers so that children come after their parent
+// and array elements are in index order.
+func sortPointers(pointers []string) {
+	split := make(map[string][]string, len(pointers))
+	for _, p := range pointers {
+		split[p], _ = splitPointer(p)
+	}
+
+	sort.SliceStable(pointers, func(i, j int) bool {
+		a, b := split[pointers[i]], split[pointers[j]]
+
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] == b[k] {
+				continue
+			}
+
+			x, xok := arrayIndex(a[k])
+			y, yok := arrayIndex(b[k])
+			if xok && yok {
+				return x < y
+			}
+
+			return a[k] < b[k]
+		}
+
+		return len(a) < len(b)
+	})
+}
@@ -0,0 +1,109 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const jsonPathStore = `{"store": {
+  "book": [
+    {"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+    {"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+    {"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+    {"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+  ],
+  "bicycle": {"color": "red", "price": 399}
+}}`
+
+const jsonPathFilter = `{
+  "a": [3, 5, 1, 2, 4, 6, {"b": "j"}, {"b": "k"}, {"b": {}}, {"b": "kilo"}],
+  "o": {"p": 1, "q": 2, "r": 3, "s": 5, "t": {"u": 6}},
+  "e": "f"
+}`
+
+func TestEvaluateJSONPath(t *testing.T) {
+	cases := []struct {
+		doc, query string
+		pointers   []string
+	}{
+		{jsonPathStore, `$.store.book[*].author`, []string{"/store/book/0/author", "/store/book/1/author", "/store/book/2/author", "/store/book/3/author"}},
+		{jsonPathStore, `$..author`, []string{"/store/book/0/author", "/store/book/1/author", "/store/book/2/author", "/store/book/3/author"}},
+		{jsonPathStore, `$.store.*`, []string{"/store/book", "/store/bicycle"}},
+		{jsonPathStore, `$.store..price`, []string{"/store/book/0/price", "/store/book/1/price", "/store/book/2/price", "/store/book/3/price", "/store/bicycle/price"}},
+		{jsonPathStore, `$..book[-1]`, []string{"/store/book/3"}},
+		{jsonPathStore, `$..book[0,1]`, []string{"/store/book/0", "/store/book/1"}},
+		{jsonPathStore, `$..book[:2]`, []string{"/store/book/0", "/store/book/1"}},
+		{jsonPathStore, `$..book[?@.isbn]`, []string{"/store/book/2", "/store/book/3"}},
+		{jsonPathStore, `$..book[?@.price<10]`, []string{"/store/book/0", "/store/book/2"}},
+		{jsonPathStore, `$.store.book[?@.price > $.store.bicycle.price]`, []string{}},
+		{`["a", "b", "c", "d", "e", "f", "g"]`, `$[1:3]`, []string{"/1", "/2"}},
+		{`["a", "b", "c", "d", "e", "f", "g"]`, `$[5:]`, []string{"/5", "/6"}},
+		{`["a", "b", "c", "d", "e", "f", "g"]`, `$[1:5:2]`, []string{"/1", "/3"}},
+		{`["a", "b", "c", "d", "e", "f", "g"]`, `$[5:1:-2]`, []string{"/5", "/3"}},
+		{`["a", "b", "c"]`, `$[::-1]`, []string{"/2", "/1", "/0"}},
+		{`["a", "b", "c"]`, `$[0 , 0]`, []string{"/0", "/0"}},
+		{jsonPathFilter, `$.a[?@.b == 'kilo']`, []string{"/a/9"}},
+		{jsonPathFilter, `$.a[?(@.b == 'kilo')]`, []string{"/a/9"}},
+		{jsonPathFilter, `$.a[?@>3.5]`, []string{"/a/1", "/a/4", "/a/5"}},
+		{jsonPathFilter, `$.a[?@.b]`, []string{"/a/6", "/a/7", "/a/8", "/a/9"}},
+		{jsonPathFilter, `$[?@.*]`, []string{"/a", "/o"}},
+		{jsonPathFilter, `$[?@[?@.b]]`, []string{"/a"}},
+		{jsonPathFilter, `$.o[?@<3, ?@<3]`, []string{"/o/p", "/o/q", "/o/p", "/o/q"}},
+		{jsonPathFilter, `$.a[?@<2 || @.b == "k"]`, []string{"/a/2", "/a/7"}},
+		{jsonPathFilter, `$.a[?match(@.b, "[jk]")]`, []string{"/a/6", "/a/7"}},
+		{jsonPathFilter, `$.a[?search(@.b, "[jk]")]`, []string{"/a/6", "/a/7", "/a/9"}},
+		{jsonPathFilter, `$.o[?@>1 && @<4]`, []string{"/o/q", "/o/r"}},
+		{jsonPathFilter, `$.o[?@.u || @.x]`, []string{"/o/t"}},
+		{jsonPathFilter, `$.a[?@.b == $.x]`, []string{"/a/0", "/a/1", "/a/2", "/a/3", "/a/4", "/a/5"}},
+		{jsonPathFilter, `$.a[?!(@ < 3)]`, []string{"/a/0", "/a/1", "/a/4", "/a/5", "/a/6", "/a/7", "/a/8", "/a/9"}},
+		{jsonPathFilter, `$[?length(@) < 3]`, []string{"/e"}},
+		{jsonPathFilter, `$[?count(@.*) == 5]`, []string{"/o"}},
+		{jsonPathFilter, `$[?value(@..u) == 6]`, []string{"/o"}},
+		{`{"a": {"b~c/d": 1}}`, `$['a']["b~c/d"]`, []string{"/a/b~0c~1d"}},
+		{`{"a": null, "b": 1}`, `$[?@ == null]`, []string{"/a"}},
+		{`{"a": [1.0, 10e-1, 2]}`, `$.a[?@ == 1]`, []string{"/a/0", "/a/1"}},
+		{`{"a": 1}`, `$`, []string{""}},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			pointers, err := EvaluateJSONPath([]byte(c.doc), c.query)
+			if err != nil {
+				t.Fatalf("unable to evaluate %s: %s", c.query, err)
+			}
+
+			if !reflect.DeepEqual(pointers, c.pointers) {
+				t.Errorf("%s: expected %v, got %v", c.query, c.pointers, pointers)
+			}
+		})
+	}
+}
+
+func TestEvaluateJSONPathInvalid(t *testing.T) {
+	for _, query := range []string{
+		`a`,
+		`$.a `,
+		`$.1`,
+		`$['a'`,
+		`$['\x']`,
+		`$[01]`,
+		`$[-0]`,
+		`$[9007199254740992]`,
+		`$[?1]`,
+		`$[?@.*==1]`,
+		`$[?@.a==01]`,
+		`$[?length(@.*)<1]`,
+		`$[?length(@)]`,
+		`$[?count(1)==1]`,
+		`$[?match(@.a)]`,
+		`$[?match(@.a, 'a')==true]`,
+		`$[?!@.a == 1]`,
+		`$[?unknown(@)]`,
+	} {
+		if _, err := EvaluateJSONPath([]byte(`{}`), query); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected %s to be invalid, got %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package jsonpatch
+
+// checkPatchLimits checks the number of operations of a patch, and the
+// limits of each operation.
+func checkPatchLimits(p Patch, options *ApplyOptions) error {
+	if options.MaxOperations > 0 && len(p) > options.MaxOperations {
+		return NewOperationCountError(options.MaxOperations, len(p))
+	}
+
+	for _, op := range p {
+		if err := checkOperationLimits(op, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkOperationLimits checks the pointers and the value of an operation
+// against the limits of options.
+func checkOperationLimits(op Operation, options *ApplyOptions) error {
+	for _, member := range []string{"path", "from"} {
+		raw := op[member]
+		if raw == nil {
+			continue
+		}
+
+		var path string
+		if err := unmarshal(*raw, &path); err != nil {
+			continue
+		}
+
+		if err := checkPointerLimits(path, options); err != nil {
+			return err
+		}
+	}
+
+	if raw := op["value"]; raw != nil {
+		if options.MaxValueSize > 0 && int64(len(*raw)) > options.MaxValueSize {
+			return NewValueSizeError(options.MaxValueSize, int64(len(*raw)))
+		}
+
+		if err := checkNestingDepth(*raw, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkPointerLimits(path string, options *ApplyOptions) error {
+	if options.MaxPointerDepth <= 0 && options.MaxPointerTokenLength <= 0 {
+		return nil
+	}
+
+	tokens, err := splitPointer(path)
+	if err != nil {
+		// Malformed pointers are reported when they are used.
+		return nil
+	}
+
+	if options.MaxPointerDepth > 0 && len(tokens) > options.MaxPointerDepth {
+		return NewPointerDepthError(options.MaxPointerDepth, len(tokens))
+	}
+
+	if options.MaxPointerTokenLength > 0 {
+		for _, tok := range tokens {
+			if len(tok) > options.MaxPointerTokenLength {
+				return NewPointerTokenLengthError(options.MaxPointerTokenLength, len(tok))
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkDocumentLimits checks the size and the nesting depth of a document.
+func checkDocumentLimits(doc []byte, options *ApplyOptions) error {
+	if err := checkDocumentSize(doc, options); err != nil {
+		return err
+	}
+
+	return checkNestingDepth(doc, options)
+}
+
+// checkDocumentSize checks the size of a document. It is checked before the
+// document is validated, so that oversized input is refused without being
+// scanned.
+func checkDocumentSize(doc []byte, options *ApplyOptions) error {
+	if options.MaxDocumentSize > 0 && int64(len(doc)) > options.MaxDocumentSize {
+		return NewDocumentSizeError(options.MaxDocumentSize, int64(len(doc)))
+	}
+
+	return nil
+}
+
+// checkNestingDepth checks the nesting depth of a valid JSON value.
+func checkNestingDepth(data []byte, options *ApplyOptions) error {
+	if options.MaxNestingDepth > 0 && !nestedWithin(data, options.MaxNestingDepth) {
+		return NewNestingDepthError(options.MaxNestingDepth)
+	}
+
+	return nil
+}
+
+// checkPatchBuffer checks the size and the nesting depth of an encoded patch,
+// before it is decoded.
+func checkPatchBuffer(buf []byte, options *ApplyOptions) error {
+	if options.MaxPatchSize > 0 && int64(len(buf)) > options.MaxPatchSize {
+		return NewPatchSizeError(options.MaxPatchSize, int64(len(buf)))
+	}
+
+	// The values of operations are nested in their operation, itself in the
+	// array of the patch.
+	if options.MaxNestingDepth > 0 && !nestedWithin(buf, options.MaxNestingDepth+2) {
+		return NewNestingDepthError(options.MaxNestingDepth)
+	}
+
+	return nil
+}
+
+// nestedWithin reports whether arrays and objects are nested at most limit
+// deep in data.
+func nestedWithin(data []byte, limit int) bool {
+	depth := 0
+	inString := false
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > limit {
+				return false
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+
+	return true
+}
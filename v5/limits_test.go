@@ -0,0 +1,212 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodePatchLimits(t *testing.T) {
+	cases := []struct {
+		patch   string
+		options func(o *ApplyOptions)
+		err     interface{}
+	}{
+		{
+			`[{"op": "remove", "path": "/a"}, {"op": "remove", "path": "/b"}]`,
+			func(o *ApplyOptions) { o.MaxOperations = 1 },
+			new(*OperationCountError),
+		},
+		{
+			`[{"op": "remove", "path": "/a/b/c"}]`,
+			func(o *ApplyOptions) { o.MaxPointerDepth = 2 },
+			new(*PointerDepthError),
+		},
+		{
+			`[{"op": "move", "from": "/a/b/c", "path": "/d"}]`,
+			func(o *ApplyOptions) { o.MaxPointerDepth = 2 },
+			new(*PointerDepthError),
+		},
+		{
+			`[{"op": "remove", "path": "/abcdef"}]`,
+			func(o *ApplyOptions) { o.MaxPointerTokenLength = 5 },
+			new(*PointerTokenLengthError),
+		},
+		{
+			`[{"op": "add", "path": "/a", "value": [[[["x"]]]]}]`,
+			func(o *ApplyOptions) { o.MaxNestingDepth = 3 },
+			new(*NestingDepthError),
+		},
+		{
+			`[{"op": "add", "path": "/a", "value": "abcdefgh"}]`,
+			func(o *ApplyOptions) { o.MaxValueSize = 8 },
+			new(*ValueSizeError),
+		},
+		{
+			`[{"op": "remove", "path": "/a"}]`,
+			func(o *ApplyOptions) { o.MaxPatchSize = 16 },
+			new(*PatchSizeError),
+		},
+		{
+			// The patch is rejected before it is decoded.
+			`[{"op": "test", "path": "/a", "if": [[[[1]]]]}]`,
+			func(o *ApplyOptions) { o.MaxNestingDepth = 3 },
+			new(*NestingDepthError),
+		},
+		{
+			`[{"op": "add", "path": "/a", "value": ["[[[", "]]]"]}, {"op": "remove", "path": "/abcde"}]`,
+			func(o *ApplyOptions) {
+				o.MaxOperations = 2
+				o.MaxPointerDepth = 1
+				o.MaxPointerTokenLength = 5
+				o.MaxNestingDepth = 2
+				o.MaxValueSize = 16
+				o.MaxPatchSize = 96
+			},
+			nil,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := NewApplyOptions()
+			c.options(options)
+
+			_, err := DecodePatchWithOptions([]byte(c.patch), options)
+
+			if c.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			if !errors.As(err, c.err) {
+				t.Fatalf("expected %T, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestApplyDocumentLimits(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/b", "value": {"c": [1]}}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	doc := []byte(`{"a": "x"}`)
+
+	options := NewApplyOptions()
+	options.MaxDocumentSize = 9
+
+	var sizeErr *DocumentSizeError
+	if _, err := p.ApplyWithOptions(doc, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError for the input, got %v", err)
+	}
+
+	options.MaxDocumentSize = 20
+	if _, err := p.ApplyWithOptions(doc, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError for the output, got %v", err)
+	}
+
+	options = NewApplyOptions()
+	options.MaxNestingDepth = 2
+
+	var depthErr *NestingDepthError
+	if _, err := p.ApplyWithOptions(doc, options); !errors.As(err, &depthErr) {
+		t.Errorf("expected a NestingDepthError for the output, got %v", err)
+	}
+
+	if _, err := p.ApplyWithOptions([]byte(`{"a": [[1]]}`), options); !errors.As(err, &depthErr) {
+		t.Errorf("expected a NestingDepthError for the input, got %v", err)
+	}
+
+	options.MaxNestingDepth = 3
+	options.MaxDocumentSize = 30
+
+	out, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"a": "x", "b": {"c": [1]}}`) {
+		t.Errorf("unexpected result %s", out)
+	}
+
+	// Oversized documents are refused before they are validated.
+	invalid := []byte(`{"a": "` + strings.Repeat("x", 40))
+
+	if _, err := p.ApplyWithOptions(invalid, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError from Apply, got %v", err)
+	}
+
+	if _, err := NewEditor(invalid, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError from NewEditor, got %v", err)
+	}
+
+	if _, err := NewImmutableDocument(invalid, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError from NewImmutableDocument, got %v", err)
+	}
+
+	if _, err := NewEditor([]byte(`{"a": [[[1]]]}`), options); !errors.As(err, &depthErr) {
+		t.Errorf("expected a NestingDepthError from NewEditor, got %v", err)
+	}
+}
+
+func TestCheckDocumentLimits(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/b", "value": {"c": [1]}}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	doc := []byte(`{"a": "x"}`)
+
+	// The patched document is checked without being returned, so that
+	// CanApply and Check agree with Apply.
+	options := NewApplyOptions()
+	options.MaxDocumentSize = 20
+
+	var sizeErr *DocumentSizeError
+	if err := p.CanApply(doc, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError from CanApply, got %v", err)
+	}
+
+	if _, err := p.Check(doc, options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected a DocumentSizeError from Check, got %v", err)
+	}
+
+	options = NewApplyOptions()
+	options.MaxNestingDepth = 2
+
+	var depthErr *NestingDepthError
+	if err := p.CanApply(doc, options); !errors.As(err, &depthErr) {
+		t.Errorf("expected a NestingDepthError from CanApply, got %v", err)
+	}
+
+	if _, err := p.Check(doc, options); !errors.As(err, &depthErr) {
+		t.Errorf("expected a NestingDepthError from Check, got %v", err)
+	}
+
+	options.MaxNestingDepth = 3
+	options.MaxDocumentSize = 30
+
+	if err := p.CanApply(doc, options); err != nil {
+		t.Errorf("unexpected error from CanApply: %s", err)
+	}
+}
+
+func TestApplyPatchLimits(t *testing.T) {
+	// Patches built without DecodePatchWithOptions are checked when applied.
+	p := Patch{
+		{"op": rawString("add"), "path": rawString("/a"), "value": rawString(strings.Repeat("x", 10))},
+	}
+
+	options := NewApplyOptions()
+	options.MaxValueSize = 8
+
+	var valueErr *ValueSizeError
+	if _, err := p.ApplyWithOptions([]byte(`{}`), options); !errors.As(err, &valueErr) {
+		t.Errorf("expected a ValueSizeError, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// Change notifies a subscriber of an Observable that the value at its prefix
+// changed.
+type Change struct {
+	// Prefix is the pointer the subscription was made for.
+	Prefix string
+	// Version is the version of the document after the change.
+	Version uint64
+	// Patch turns the previous value at Prefix into the current one, with
+	// pointers relative to Prefix. A value that appeared is added at "", and
+	// one that disappeared is removed from "".
+	Patch Patch
+}
+
+// Observable is a document that notifies subscribers of the changes made by
+// the patches applied to it. It is safe for concurrent use, although patches
+// are applied one at a time.
+type Observable struct {
+	options *ApplyOptions
+
+	// writeMu serializes Apply, including the notifications.
+	writeMu sync.Mutex
+
+	mu      sync.RWMutex
+	doc     []byte
+	version uint64
+	subs    map[uint64]*subscription
+	nextID  uint64
+}
+
+type subscription struct {
+	id     uint64
+	prefix string
+	tokens []string
+	fn     func(Change)
+}
+
+// NewObservable returns an Observable for doc, applying patches with the
+// passed in ApplyOptions.
+func NewObservable(doc []byte, options *ApplyOptions) (*Observable, error) {
+	if !json.Valid(doc) {
+		return nil, ErrInvalid
+	}
+
+	if _, err := newContainer(doc, options); err != nil {
+		return nil, err
+	}
+
+	return &Observable{
+		options: options,
+		doc:     append([]byte(nil), doc...),
+		subs:    map[uint64]*subscription{},
+	}, nil
+}
+
+// Document returns the current document and its version, which counts the
+// patches applied.
+func (o *Observable) Document() ([]byte, uint64) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return append([]byte(nil), o.doc...), o.version
+}
+
+// Get returns the value at path in the current document.
+func (o *Observable) Get(path string) ([]byte, error) {
+	doc, _ := o.Document()
+
+	pd, err := newContainer(doc, o.options)
+	if err != nil {
+		return nil, err
+	}
+
+	node, found := valueAt(&pd, path, o.options)
+	if !found {
+		return nil, fmt.Errorf("unable to get %s: %w", path, ErrMissing)
+	}
+
+	return json.Marshal(node)
+}
+
+// Subscribe calls fn after each patch that changes the value at prefix,
+// including by adding or removing it. The calls are made one at a time, in
+// the order of the patches, by the goroutine applying them; fn must not call
+// Apply. The returned function cancels the subscription.
+func (o *Observable) Subscribe(prefix string, fn func(Change)) (func(), error) {
+	tokens, err := splitPointer(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	id := o.nextID
+	o.nextID++
+	o.subs[id] = &subscription{id: id, prefix: prefix, tokens: tokens, fn: fn}
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		delete(o.subs, id)
+	}, nil
+}
+
+// Apply applies the patch to the document, and notifies the subscribers
+// whose values changed. The document is left unchanged when the patch fails.
+func (o *Observable) Apply(p Patch) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	o.mu.RLock()
+	doc := o.doc
+	subs := make([]*subscription, 0, len(o.subs))
+	for _, s := range o.subs {
+		subs = append(subs, s)
+	}
+	o.mu.RUnlock()
+
+	pd, err := newContainer(doc, o.options)
+	if err != nil {
+		return err
+	}
+
+	// Only the subscriptions above or within the part of the document the
+	// patch may change are compared.
+	scope := patchScope(&pd, p, o.options)
+
+	var affected []*subscription
+	var before []*lazyNode
+
+	for _, s := range subs {
+		if !hasPointerPrefix(s.tokens, scope) && !hasPointerPrefix(scope, s.tokens) {
+			continue
+		}
+
+		value, err := o.snapshot(&pd, s.prefix)
+		if err != nil {
+			return err
+		}
+
+		affected = append(affected, s)
+		before = append(before, value)
+	}
+
+	if _, err := p.applyTo(&pd, o.options); err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(pd)
+	if err != nil {
+		return err
+	}
+
+	type notification struct {
+		sub    *subscription
+		change Change
+	}
+
+	var notifications []notification
+
+	for i, s := range affected {
+		after, err := o.snapshot(&pd, s.prefix)
+		if err != nil {
+			return err
+		}
+
+		patch, err := diffValues(before[i], after)
+		if err != nil {
+			return err
+		}
+
+		if len(patch) > 0 {
+			notifications = append(notifications, notification{sub: s, change: Change{Prefix: s.prefix, Patch: patch}})
+		}
+	}
+
+	o.mu.Lock()
+	o.doc = out
+	o.version++
+	version := o.version
+	o.mu.Unlock()
+
+	for _, n := range notifications {
+		// Subscriptions may be cancelled by earlier notifications.
+		o.mu.RLock()
+		_, active := o.subs[n.sub.id]
+		o.mu.RUnlock()
+
+		if active {
+			n.change.Version = version
+			n.sub.fn(n.change)
+		}
+	}
+
+	return nil
+}
+
+// snapshot returns a copy of the value at path, or nil if there is none.
+func (o *Observable) snapshot(pd *container, path string) (*lazyNode, error) {
+	node, found := valueAt(pd, path, o.options)
+	if !found {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLazyNode(newRawMessage(data)), nil
+}
+
+// diffValues returns a patch turning a into b, where nil stands for a
+// missing value.
+func diffValues(a, b *lazyNode) (Patch, error) {
+	switch {
+	case a == nil && b == nil:
+		return nil, nil
+	case a == nil:
+		p := Patch{}
+		return p, appendOperation(&p, "add", "", b)
+	case b == nil:
+		p := Patch{}
+		return p, appendOperation(&p, "remove", "", nil)
+	}
+
+	p := Patch{}
+	if err := diffNodes(&p, "", a, b); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,201 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+func TestObservable(t *testing.T) {
+	o, err := NewObservable([]byte(`{"settings": {"theme": {"color": "red"}, "lang": "en"}, "items": [1, 2]}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create observable: %s", err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+
+	for _, prefix := range []string{"/settings/theme", "/settings", "/items/0", "/missing", ""} {
+		_, err := o.Subscribe(prefix, func(c Change) {
+			data, err := json.Marshal(c.Patch)
+			if err != nil {
+				t.Errorf("unable to encode patch: %s", err)
+			}
+
+			mu.Lock()
+			got = append(got, fmt.Sprintf("%d %q %s", c.Version, c.Prefix, data))
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatalf("unable to subscribe: %s", err)
+		}
+	}
+
+	cases := []struct {
+		patch    string
+		expected []string
+	}{
+		{
+			`[{"op": "replace", "path": "/settings/theme/color", "value": "blue"}]`,
+			[]string{
+				`1 "" [{"op":"replace","path":"/settings/theme/color","value":"blue"}]`,
+				`1 "/settings" [{"op":"replace","path":"/theme/color","value":"blue"}]`,
+				`1 "/settings/theme" [{"op":"replace","path":"/color","value":"blue"}]`,
+			},
+		},
+		{
+			`[{"op": "replace", "path": "/settings/lang", "value": "fr"}]`,
+			[]string{
+				`2 "" [{"op":"replace","path":"/settings/lang","value":"fr"}]`,
+				`2 "/settings" [{"op":"replace","path":"/lang","value":"fr"}]`,
+			},
+		},
+		{
+			`[{"op": "add", "path": "/items/0", "value": 0}, {"op": "add", "path": "/missing", "value": {"a": 1}}]`,
+			[]string{
+				`3 "" [{"op":"add","path":"/items/0","value":0},{"op":"add","path":"/missing","value":{"a":1}}]`,
+				`3 "/items/0" [{"op":"replace","path":"","value":0}]`,
+				`3 "/missing" [{"op":"add","path":"","value":{"a":1}}]`,
+			},
+		},
+		{
+			`[{"op": "test", "path": "/items/0", "value": 0}, {"op": "replace", "path": "/settings/theme", "value": {"color": "blue"}}]`,
+			nil,
+		},
+		{
+			`[{"op": "remove", "path": "/settings"}]`,
+			[]string{
+				`5 "" [{"op":"remove","path":"/settings"}]`,
+				`5 "/settings" [{"op":"remove","path":""}]`,
+				`5 "/settings/theme" [{"op":"remove","path":""}]`,
+			},
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			got = nil
+
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			if err := o.Apply(p); err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			sort.Strings(got)
+
+			if fmt.Sprint(got) != fmt.Sprint(c.expected) {
+				t.Errorf("unexpected changes:\n%q\nexpected:\n%q", got, c.expected)
+			}
+		})
+	}
+}
+
+func TestObservableUnsubscribe(t *testing.T) {
+	o, err := NewObservable([]byte(`{"a": 1}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create observable: %s", err)
+	}
+
+	calls := 0
+	cancel, err := o.Subscribe("/a", func(c Change) { calls++ })
+	if err != nil {
+		t.Fatalf("unable to subscribe: %s", err)
+	}
+
+	if _, err := o.Subscribe("a", func(c Change) {}); err == nil {
+		t.Errorf("expected an invalid prefix to be rejected")
+	}
+
+	p, _ := DecodePatch([]byte(`[{"op": "replace", "path": "/a", "value": 2}]`))
+	if err := o.Apply(p); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	cancel()
+
+	p, _ = DecodePatch([]byte(`[{"op": "replace", "path": "/a", "value": 3}]`))
+	if err := o.Apply(p); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	// Failed patches leave the document unchanged.
+	p, _ = DecodePatch([]byte(`[{"op": "replace", "path": "/a", "value": 4}, {"op": "remove", "path": "/b"}]`))
+	if err := o.Apply(p); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+
+	doc, version := o.Document()
+	if !compareJSON(string(doc), `{"a": 3}`) || version != 2 {
+		t.Errorf("unexpected document %s at version %d", doc, version)
+	}
+
+	value, err := o.Get("/a")
+	if err != nil || string(value) != "3" {
+		t.Errorf("unexpected value %s, %v", value, err)
+	}
+}
+
+func TestObservableConcurrentReaders(t *testing.T) {
+	o, err := NewObservable([]byte(`{"n": 0}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create observable: %s", err)
+	}
+
+	var last uint64
+	if _, err := o.Subscribe("/n", func(c Change) {
+		if c.Version != last+1 {
+			t.Errorf("expected version %d, got %d", last+1, c.Version)
+		}
+		last = c.Version
+	}); err != nil {
+		t.Fatalf("unable to subscribe: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if _, err := o.Get("/n"); err != nil {
+					t.Errorf("unable to get value: %s", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 50; i++ {
+		p, _ := DecodePatch([]byte(fmt.Sprintf(`[{"op": "replace", "path": "/n", "value": %d}]`, i)))
+		if err := o.Apply(p); err != nil {
+			t.Fatalf("unable to apply patch: %s", err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	if last != 50 {
+		t.Errorf("expected 50 changes, got %d", last)
+	}
+}
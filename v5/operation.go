@@ -0,0 +1,162 @@
+package jsonpatch
+
+import (
+	"fmt"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// OperationHandler implements a custom operation, registered on ApplyOptions
+// with RegisterOperation.
+type OperationHandler interface {
+	// Validate checks the members of an operation when a patch is decoded
+	// with DecodePatchWithOptions.
+	Validate(op Operation) error
+	// Apply applies the operation of view to its document.
+	Apply(view *DocumentView) error
+}
+
+var builtinOperations = map[string]bool{
+	"add":     true,
+	"remove":  true,
+	"replace": true,
+	"move":    true,
+	"copy":    true,
+	"test":    true,
+}
+
+// RegisterOperation registers a handler for operations named name, for
+// patches decoded with DecodePatchWithOptions and applied with these options.
+// The operations of RFC 6902 and the predicate operations cannot be
+// replaced.
+func (o *ApplyOptions) RegisterOperation(name string, handler OperationHandler) error {
+	if name == "" || builtinOperations[name] || isPredicate(name) {
+		return fmt.Errorf("unable to register operation %q: %w", name, ErrInvalid)
+	}
+
+	if handler == nil {
+		return fmt.Errorf("unable to register operation %q without a handler: %w", name, ErrInvalid)
+	}
+
+	if o.operations == nil {
+		o.operations = map[string]OperationHandler{}
+	}
+
+	o.operations[name] = handler
+	return nil
+}
+
+// DocumentView gives an OperationHandler access to the document a patch is
+// applied to. Values are passed in and out as JSON, so handlers never share
+// memory with the document.
+type DocumentView struct {
+	doc                 *container
+	op                  Operation
+	options             *ApplyOptions
+	accumulatedCopySize *int64
+}
+
+// Operation returns the operation being applied.
+func (v *DocumentView) Operation() Operation {
+	return v.op
+}
+
+// Options returns the options the patch is applied with.
+func (v *DocumentView) Options() *ApplyOptions {
+	return v.options
+}
+
+// Get returns the value at path. An error wrapping ErrMissing is returned if
+// there is no value at path.
+func (v *DocumentView) Get(path string) ([]byte, error) {
+	var node *lazyNode
+
+	if path == "" {
+		node, _ = valueAt(v.doc, path, v.options)
+	} else {
+		con, key := findObject(v.doc, path, v.options)
+		if con == nil {
+			return nil, fmt.Errorf("unable to get value: doc is missing path: %s: %w", path, ErrMissing)
+		}
+
+		var err error
+		node, err = con.get(key, v.options)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get value at %s: %w", path, err)
+		}
+
+		if node == nil {
+			return append([]byte(nil), rawJSONNull...), nil
+		}
+	}
+
+	return json.MarshalEscaped(node, v.options.EscapeHTML)
+}
+
+// Set replaces the value at path, which must exist, like a "replace"
+// operation.
+func (v *DocumentView) Set(path string, value []byte) error {
+	op, err := v.operation("replace", path, value)
+	if err != nil {
+		return err
+	}
+
+	return Patch{}.replace(v.doc, op, v.options)
+}
+
+// Add adds a value at path like an "add" operation.
+func (v *DocumentView) Add(path string, value []byte) error {
+	op, err := v.operation("add", path, value)
+	if err != nil {
+		return err
+	}
+
+	return Patch{}.add(v.doc, op, v.options)
+}
+
+// Remove removes the value at path like a "remove" operation.
+func (v *DocumentView) Remove(path string) error {
+	op, err := v.operation("remove", path, nil)
+	if err != nil {
+		return err
+	}
+
+	return Patch{}.remove(v.doc, op, v.options)
+}
+
+// AddCopySize records that the operation grew the document by n bytes by
+// duplicating existing values, which counts towards
+// ApplyOptions.AccumulatedCopySizeLimit along with "copy" operations.
+func (v *DocumentView) AddCopySize(n int64) error {
+	*v.accumulatedCopySize += n
+
+	if v.options.AccumulatedCopySizeLimit > 0 && *v.accumulatedCopySize > v.options.AccumulatedCopySizeLimit {
+		return NewAccumulatedCopySizeError(v.options.AccumulatedCopySizeLimit, *v.accumulatedCopySize)
+	}
+
+	return nil
+}
+
+func (v *DocumentView) operation(kind, path string, value []byte) (Operation, error) {
+	// Changes made by handlers are subject to the policy as the operation
+	// they implement.
+	if v.options.Policy != nil && v.op != nil {
+		if err := v.options.Policy.checkPath(v.doc, v.op.Kind(), path, kind == "add", v.options); err != nil {
+			return nil, err
+		}
+	}
+
+	op := Operation{
+		"op":   rawString(kind),
+		"path": rawString(path),
+	}
+
+	if kind != "remove" {
+		if !json.Valid(value) {
+			return nil, fmt.Errorf("invalid value for %s at %s: %w", kind, path, ErrInvalid)
+		}
+		op["value"] = newRawMessage(value)
+	}
+
+	return op, nil
+}
@@ -0,0 +1,234 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// appendUniqueHandler appends "value" to the array at "path" unless it is
+// already there.
+type appendUniqueHandler struct{}
+
+func (appendUniqueHandler) Validate(op Operation) error {
+	if _, err := op.Path(); err != nil {
+		return err
+	}
+	_, err := op.ValueInterface()
+	return err
+}
+
+func (appendUniqueHandler) Apply(view *DocumentView) error {
+	op := view.Operation()
+	path, _ := op.Path()
+
+	buf, err := view.Get(path)
+	if err != nil {
+		return err
+	}
+
+	var elems []*lazyNode
+	if err := unmarshal(buf, &elems); err != nil {
+		return err
+	}
+
+	value := op.value()
+	for _, e := range elems {
+		if nodeEqual(e, value) {
+			return nil
+		}
+	}
+
+	return view.Add(path+"/-", *op["value"])
+}
+
+// sortHandler sorts the array of strings at "path".
+type sortHandler struct{}
+
+func (sortHandler) Validate(op Operation) error {
+	_, err := op.Path()
+	return err
+}
+
+func (sortHandler) Apply(view *DocumentView) error {
+	path, _ := view.Operation().Path()
+
+	buf, err := view.Get(path)
+	if err != nil {
+		return err
+	}
+
+	var elems []string
+	if err := unmarshal(buf, &elems); err != nil {
+		return err
+	}
+
+	sort.Strings(elems)
+
+	sorted, err := json.Marshal(elems)
+	if err != nil {
+		return err
+	}
+
+	return view.Set(path, sorted)
+}
+
+// duplicateHandler appends a copy of the array at "path" to itself.
+type duplicateHandler struct{}
+
+func (duplicateHandler) Validate(op Operation) error {
+	_, err := op.Path()
+	return err
+}
+
+func (duplicateHandler) Apply(view *DocumentView) error {
+	path, _ := view.Operation().Path()
+
+	buf, err := view.Get(path)
+	if err != nil {
+		return err
+	}
+
+	var elems []*lazyNode
+	if err := unmarshal(buf, &elems); err != nil {
+		return err
+	}
+
+	for _, e := range elems {
+		v, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		if err := view.AddCopySize(int64(len(v))); err != nil {
+			return err
+		}
+
+		if err := view.Add(path+"/-", v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func customOptions(t *testing.T) *ApplyOptions {
+	t.Helper()
+
+	options := NewApplyOptions()
+	for name, h := range map[string]OperationHandler{
+		"append-unique": appendUniqueHandler{},
+		"sort":          sortHandler{},
+		"duplicate":     duplicateHandler{},
+	} {
+		if err := options.RegisterOperation(name, h); err != nil {
+			t.Fatalf("unable to register %s: %s", name, err)
+		}
+	}
+
+	return options
+}
+
+func TestCustomOperations(t *testing.T) {
+	cases := []struct {
+		doc, patch, result string
+	}{
+		{
+			`{"tags": ["b", "a"]}`,
+			`[{"op": "append-unique", "path": "/tags", "value": "a"}, {"op": "append-unique", "path": "/tags", "value": "c"}]`,
+			`{"tags": ["b", "a", "c"]}`,
+		},
+		{
+			`{"tags": ["b", "c", "a"]}`,
+			`[{"op": "sort", "path": "/tags"}]`,
+			`{"tags": ["a", "b", "c"]}`,
+		},
+		{
+			`{"tags": ["b", "a"]}`,
+			`[{"op": "append-unique", "path": "/tags", "value": "c"}, {"op": "sort", "path": "/tags"}, {"op": "remove", "path": "/tags/0"}]`,
+			`{"tags": ["b", "c"]}`,
+		},
+		{
+			`{"list": [1, {"a": 2}]}`,
+			`[{"op": "duplicate", "path": "/list"}]`,
+			`{"list": [1, {"a": 2}, 1, {"a": 2}]}`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := customOptions(t)
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			out, err := p.ApplyWithOptions([]byte(c.doc), options)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+		})
+	}
+}
+
+func TestCustomOperationErrors(t *testing.T) {
+	options := customOptions(t)
+
+	if _, err := DecodePatch([]byte(`[{"op": "sort", "path": "/tags"}]`)); err == nil {
+		t.Error("expected DecodePatch to reject an unregistered operation")
+	}
+
+	if _, err := DecodePatchWithOptions([]byte(`[{"op": "sort"}]`), options); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected the validation hook to reject a missing path, got %v", err)
+	}
+
+	p, err := DecodePatchWithOptions([]byte(`[{"op": "sort", "path": "/missing"}]`), options)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, err := p.ApplyWithOptions([]byte(`{}`), options); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected a missing value, got %v", err)
+	}
+
+	if _, err := p.Apply([]byte(`{"missing": []}`)); err == nil {
+		t.Error("expected an error applying an unregistered operation")
+	}
+
+	options.AccumulatedCopySizeLimit = 5
+
+	p, err = DecodePatchWithOptions([]byte(`[{"op": "duplicate", "path": "/list"}]`), options)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	_, err = p.ApplyWithOptions([]byte(`{"list": ["abc", "def"]}`), options)
+
+	var sizeErr *AccumulatedCopySizeError
+	if !errors.As(err, &sizeErr) {
+		t.Errorf("expected the copy size limit to be enforced, got %v", err)
+	}
+}
+
+func TestRegisterOperationBuiltin(t *testing.T) {
+	options := NewApplyOptions()
+
+	for _, name := range []string{"", "add", "test", "contains"} {
+		if err := options.RegisterOperation(name, sortHandler{}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected registering %q to fail, got %v", name, err)
+		}
+	}
+
+	if err := options.RegisterOperation("sort", nil); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected registering a nil handler to fail, got %v", err)
+	}
+}
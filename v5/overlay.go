@@ -0,0 +1,136 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+type overlayDocument struct {
+	Overlay string          `json:"overlay"`
+	Actions []overlayAction `json:"actions"`
+}
+
+type overlayAction struct {
+	Target string           `json:"target"`
+	Update *json.RawMessage `json:"update"`
+	Remove bool             `json:"remove"`
+}
+
+// ApplyOverlay applies an OpenAPI Overlay document (version 1.x, in its JSON
+// form) to doc. The actions of the overlay are applied in order, each to the
+// nodes its JSONPath target selects in the document as left by the previous
+// actions:
+//
+//   - "remove": true removes the selected nodes.
+//   - "update" is merged into selected objects as an RFC 7396 merge patch,
+//     appended to selected arrays, and replaces other selected values.
+//
+// A target that selects no nodes leaves the document unchanged.
+func ApplyOverlay(doc, overlay []byte) ([]byte, error) {
+	if !json.Valid(doc) {
+		return nil, ErrBadJSONDoc
+	}
+
+	var o overlayDocument
+	if err := unmarshal(overlay, &o); err != nil {
+		return nil, fmt.Errorf("unable to decode overlay: %w", err)
+	}
+
+	if !strings.HasPrefix(o.Overlay, "1.") {
+		return nil, fmt.Errorf("unsupported overlay version %q: %w", o.Overlay, ErrInvalid)
+	}
+
+	options := NewApplyOptions()
+
+	pd, err := newContainer(doc, options)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &DocumentView{
+		doc:                 &pd,
+		options:             options,
+		accumulatedCopySize: new(int64),
+	}
+
+	for i, action := range o.Actions {
+		if err := applyOverlayAction(view, action); err != nil {
+			return nil, fmt.Errorf("overlay action %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(pd)
+}
+
+func applyOverlayAction(view *DocumentView, action overlayAction) error {
+	q, err := parseJSONPath(action.Target)
+	if err != nil {
+		return err
+	}
+
+	root, _ := valueAt(view.doc, "", view.options)
+	nodes := q.eval(jpNode{node: root}, jpNode{node: root})
+
+	seen := map[string]bool{}
+	var pointers []string
+
+	for _, n := range nodes {
+		p := joinPointer(n.path)
+		if !seen[p] {
+			seen[p] = true
+			pointers = append(pointers, p)
+		}
+	}
+
+	sortPointers(pointers)
+
+	if action.Remove {
+		// Children and later array elements go first, so that the remaining
+		// pointers stay valid.
+		for i := len(pointers) - 1; i >= 0; i-- {
+			if pointers[i] == "" {
+				return fmt.Errorf("unable to remove the root of the document: %w", ErrInvalid)
+			}
+
+			if err := view.Remove(pointers[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if action.Update == nil {
+		return nil
+	}
+
+	update := *action.Update
+
+	for _, p := range pointers {
+		cur, err := view.Get(p)
+		if err != nil {
+			return err
+		}
+
+		switch nodeType(newLazyNode(newRawMessage(cur))) {
+		case nodeObject:
+			merged, err := MergePatch(cur, update)
+			if err != nil {
+				return err
+			}
+			err = view.Set(p, merged)
+		case nodeArray:
+			err = view.Add(p+"/-", update)
+		default:
+			err = view.Set(p, update)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
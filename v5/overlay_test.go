@@ -0,0 +1,131 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const overlaySpec = `{
+  "openapi": "3.1.0",
+  "info": {"title": "Pets", "version": "1.0.0"},
+  "servers": [{"url": "https://dev.example.com"}],
+  "tags": [{"name": "pets"}],
+  "paths": {
+    "/pets": {
+      "get": {"summary": "List pets", "x-internal": true},
+      "post": {"summary": "Create a pet"}
+    },
+    "/admin": {
+      "get": {"summary": "Admin", "x-internal": true}
+    }
+  }
+}`
+
+func TestApplyOverlay(t *testing.T) {
+	cases := []struct {
+		overlay, result string
+	}{
+		{
+			`{"overlay": "1.0.0", "actions": [
+			  {"target": "$.info", "update": {"title": "Pets (production)", "x-env": "prod"}},
+			  {"target": "$.servers[0].url", "update": "https://api.example.com"}
+			]}`,
+			`{
+			  "openapi": "3.1.0",
+			  "info": {"title": "Pets (production)", "version": "1.0.0", "x-env": "prod"},
+			  "servers": [{"url": "https://api.example.com"}],
+			  "tags": [{"name": "pets"}],
+			  "paths": {
+			    "/pets": {"get": {"summary": "List pets", "x-internal": true}, "post": {"summary": "Create a pet"}},
+			    "/admin": {"get": {"summary": "Admin", "x-internal": true}}
+			  }
+			}`,
+		},
+		{
+			`{"overlay": "1.0.0", "actions": [
+			  {"target": "$.paths.*[?@['x-internal'] == true]", "remove": true},
+			  {"target": "$.paths[?length(@) == 0]", "remove": true},
+			  {"target": "$.tags", "update": {"name": "public"}},
+			  {"target": "$.nothing", "remove": true}
+			]}`,
+			`{
+			  "openapi": "3.1.0",
+			  "info": {"title": "Pets", "version": "1.0.0"},
+			  "servers": [{"url": "https://dev.example.com"}],
+			  "tags": [{"name": "pets"}, {"name": "public"}],
+			  "paths": {"/pets": {"post": {"summary": "Create a pet"}}}
+			}`,
+		},
+		{
+			`{"overlay": "1.0.0", "actions": [
+			  {"target": "$..[?@.summary]", "update": {"x-owner": "team", "x-internal": null}},
+			  {"target": "$.servers[*]", "remove": true}
+			]}`,
+			`{
+			  "openapi": "3.1.0",
+			  "info": {"title": "Pets", "version": "1.0.0"},
+			  "servers": [],
+			  "tags": [{"name": "pets"}],
+			  "paths": {
+			    "/pets": {"get": {"summary": "List pets", "x-owner": "team"}, "post": {"summary": "Create a pet", "x-owner": "team"}},
+			    "/admin": {"get": {"summary": "Admin", "x-owner": "team"}}
+			  }
+			}`,
+		},
+		{
+			`{"overlay": "1.0.0", "actions": [
+			  {"target": "$.paths", "update": {"/admin": {"get": {"x-internal": false}}}},
+			  {"target": "$.paths['/admin'].get", "update": {"summary": "Administration"}}
+			]}`,
+			`{
+			  "openapi": "3.1.0",
+			  "info": {"title": "Pets", "version": "1.0.0"},
+			  "servers": [{"url": "https://dev.example.com"}],
+			  "tags": [{"name": "pets"}],
+			  "paths": {
+			    "/pets": {"get": {"summary": "List pets", "x-internal": true}, "post": {"summary": "Create a pet"}},
+			    "/admin": {"get": {"summary": "Administration", "x-internal": false}}
+			  }
+			}`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			out, err := ApplyOverlay([]byte(overlaySpec), []byte(c.overlay))
+			if err != nil {
+				t.Fatalf("unable to apply overlay: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+		})
+	}
+}
+
+func TestApplyOverlayErrors(t *testing.T) {
+	cases := []struct {
+		overlay string
+		err     error
+	}{
+		{`{"actions": []}`, ErrInvalid},
+		{`{"overlay": "2.0.0", "actions": []}`, ErrInvalid},
+		{`{"overlay": "1.0.0", "actions": [{"target": "paths", "remove": true}]}`, ErrInvalid},
+		{`{"overlay": "1.0.0", "actions": [{"target": "$", "remove": true}]}`, ErrInvalid},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			if _, err := ApplyOverlay([]byte(overlaySpec), []byte(c.overlay)); !errors.Is(err, c.err) {
+				t.Errorf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+
+	if _, err := ApplyOverlay([]byte(`{`), []byte(`{"overlay": "1.0.0"}`)); !errors.Is(err, ErrBadJSONDoc) {
+		t.Errorf("expected ErrBadJSONDoc, got %v", err)
+	}
+}
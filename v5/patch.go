@@ -94,8 +94,72 @@ type ApplyOptions struct {
 	// EnsurePathExistsOnAdd instructs json-patch to recursively create the missing parts of path on "add" operation.
 	// Default to false.
 	EnsurePathExistsOnAdd bool
+	// SupportPredicates enables the predicate operations of the JSON Patch
+	// predicates draft, such as "contains", "type" and "and".
+	// Default to false.
+	SupportPredicates bool
+	// SupportExtensions enables the non-standard "inc", "merge" and
+	// "append-unique" operations.
+	// Default to false.
+	SupportExtensions bool
+	// SupportWildcards enables wildcard reference tokens in the "path" of
+	// operations: "*" matches every member of an object or element of an
+	// array, and "[key=value]" matches those that are objects whose "key"
+	// member equals value.
+	// Default to false.
+	SupportWildcards bool
+	// SupportConditions enables the "if" member of operations, which skips
+	// the operation unless the value at a path equals a value, or exists or
+	// not: {"path": "/a", "value": 1} or {"path": "/a", "exists": true}.
+	// Default to false.
+	SupportConditions bool
+	// ContinueOnError skips the operations that fail instead of failing the
+	// patch, leaving the document as it was before each of them. Use
+	// ApplyWithResults to learn which operations failed.
+	// Default to false.
+	ContinueOnError bool
+	// OnOperation is called before each operation is applied, and
+	// OnOperationDone after. An error returned by either fails the
+	// operation, so that OnOperation can veto it. With
+	// ApplyOptions.SupportWildcards they are called for each concrete path.
+	OnOperation     func(event *OperationEvent) error
+	OnOperationDone func(event *OperationEvent) error
+	// Policy restricts the paths operations may apply to.
+	// Default to nil, which allows all of them.
+	Policy *Policy
+	// MaxOperations limits the number of operations of a patch.
+	MaxOperations int
+	// MaxPointerDepth limits the number of reference tokens of the "path"
+	// and "from" of operations, and MaxPointerTokenLength their length.
+	MaxPointerDepth       int
+	MaxPointerTokenLength int
+	// MaxNestingDepth limits how deep arrays and objects may be nested in
+	// documents and in the values of operations.
+	MaxNestingDepth int
+	// MaxDocumentSize limits the size in bytes of documents, both before and
+	// after the patch is applied.
+	MaxDocumentSize int64
+	// MaxPatchSize limits the size in bytes of the patch given to
+	// DecodePatchWithOptions.
+	MaxPatchSize int64
+	// MaxValueSize limits the size in bytes of the "value" of operations.
+	// All of the limits above default to 0, which means no limit. The patch
+	// limits are enforced by DecodePatchWithOptions as well.
+	MaxValueSize int64
 
 	EscapeHTML bool
+
+	// operations holds the handlers of custom operations, registered with
+	// RegisterOperation.
+	operations map[string]OperationHandler
+
+	// checkOnly is set by CanApply and Check, whose documents are thrown
+	// away.
+	checkOnly bool
+
+	// patchChecked is set by ApplyBatchFunc, which checks the limits of the
+	// patch once for all the documents.
+	patchChecked bool
 }
 
 // NewApplyOptions creates a default set of options for calls to ApplyWithOptions.
@@ -105,6 +169,11 @@ func NewApplyOptions() *ApplyOptions {
 		AccumulatedCopySizeLimit: AccumulatedCopySizeLimit,
 		AllowMissingPathOnRemove: false,
 		EnsurePathExistsOnAdd:    false,
+		SupportPredicates:        false,
+		SupportExtensions:        false,
+		SupportWildcards:         false,
+		SupportConditions:        false,
+		ContinueOnError:          false,
 		EscapeHTML:               true,
 	}
 }
@@ -225,6 +294,48 @@ func deepCopy(src *lazyNode, options *ApplyOptions) (*lazyNode, int, error) {
 	return newLazyNode(newRawMessage(a)), sz, nil
 }
 
+// encodedSize returns the size of a valid JSON value once encoded, compacted
+// and with HTML characters escaped if escapeHTML is set, as deepCopy does.
+func encodedSize(raw []byte, escapeHTML bool) int {
+	sz := 0
+	inString := false
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+
+		if !inString {
+			switch c {
+			case ' ', '\t', '\n', '\r':
+				continue
+			case '"':
+				inString = true
+			}
+			sz++
+			continue
+		}
+
+		switch {
+		case c == '\\':
+			sz++
+			i++
+		case c == '"':
+			inString = false
+		case !escapeHTML:
+		case c == '<', c == '>', c == '&':
+			// Escaped as \u00XX.
+			sz += 5
+		case c == 0xE2 && i+2 < len(raw) && raw[i+1] == 0x80 && raw[i+2]&^1 == 0xA8:
+			// U+2028 and U+2029 are escaped as \u202X.
+			sz += 6
+			i += 2
+			continue
+		}
+		sz++
+	}
+
+	return sz
+}
+
 func (n *lazyNode) nextByte() byte {
 	s := []byte(*n.raw)
 
@@ -338,7 +449,8 @@ func (n *lazyNode) isNull() bool {
 	}
 
 	if n.raw == nil {
-		return true
+		// Objects and arrays built in memory have no raw value.
+		return n.which == eRaw
 	}
 
 	return bytes.Equal(n.compact(), rawJSONNull)
@@ -914,7 +1026,30 @@ func ensurePathExists(pd *container, path string, options *ApplyOptions) error {
 	return nil
 }
 
-func validateOperation(op Operation) error {
+func validateOperation(op Operation, options *ApplyOptions) error {
+	// Paths with wildcards are checked once expanded.
+	if options.Policy != nil && !options.SupportWildcards {
+		if err := options.Policy.checkOperation(nil, op, options); err != nil {
+			return err
+		}
+	}
+
+	if options.SupportConditions {
+		if _, err := op.guard(); err != nil {
+			return err
+		}
+	}
+
+	if options.SupportWildcards {
+		if err := validateWildcards(op); err != nil {
+			return err
+		}
+	}
+
+	if options.SupportPredicates && isPredicate(op.Kind()) {
+		return validatePredicate(op, false)
+	}
+
 	switch op.Kind() {
 	case "add", "replace":
 		if _, err := op.ValueInterface(); err != nil {
@@ -926,6 +1061,9 @@ func validateOperation(op Operation) error {
 		}
 	case "remove", "test":
 	default:
+		if h, ok := options.handler(op.Kind()); ok {
+			return h.Validate(op)
+		}
 		return fmt.Errorf("unsupported operation")
 	}
 
@@ -936,9 +1074,9 @@ func validateOperation(op Operation) error {
 	return nil
 }
 
-func validatePatch(p Patch) error {
+func validatePatch(p Patch, options *ApplyOptions) error {
 	for _, op := range p {
-		if err := validateOperation(op); err != nil {
+		if err := validateOperation(op, options); err != nil {
 			opData, infoErr := json.Marshal(op)
 			if infoErr != nil {
 				return fmt.Errorf("invalid operation: %w", err)
@@ -1151,9 +1289,19 @@ func (p Patch) copy(doc *container, op Operation, accumulatedCopySize *int64, op
 		return fmt.Errorf("copy operation does not apply: doc is missing destination path: %s: %w", path, ErrMissing)
 	}
 
-	valCopy, sz, err := deepCopy(val, options)
-	if err != nil {
-		return fmt.Errorf("error while performing deep copy: %w", err)
+	var valCopy *lazyNode
+	var sz int
+
+	if options.checkOnly && val != nil && val.which == eRaw && val.raw != nil {
+		// The document is thrown away, so a value that was not decoded yet
+		// can share its bytes. Its size is the size it would be copied at.
+		valCopy = newLazyNode(val.raw)
+		sz = encodedSize(*val.raw, options.EscapeHTML)
+	} else {
+		valCopy, sz, err = deepCopy(val, options)
+		if err != nil {
+			return fmt.Errorf("error while performing deep copy: %w", err)
+		}
 	}
 
 	(*accumulatedCopySize) += int64(sz)
@@ -1179,6 +1327,16 @@ func Equal(a, b []byte) bool {
 
 // DecodePatch decodes the passed JSON document as an RFC 6902 patch.
 func DecodePatch(buf []byte) (Patch, error) {
+	return DecodePatchWithOptions(buf, NewApplyOptions())
+}
+
+// DecodePatchWithOptions decodes the passed JSON document as an RFC 6902
+// patch, accepting the operations enabled by the passed in ApplyOptions.
+func DecodePatchWithOptions(buf []byte, options *ApplyOptions) (Patch, error) {
+	if err := checkPatchBuffer(buf, options); err != nil {
+		return nil, err
+	}
+
 	if !json.Valid(buf) {
 		return nil, ErrInvalid
 	}
@@ -1191,7 +1349,11 @@ func DecodePatch(buf []byte) (Patch, error) {
 		return nil, err
 	}
 
-	if err := validatePatch(p); err != nil {
+	if err := checkPatchLimits(p, options); err != nil {
+		return nil, err
+	}
+
+	if err := validatePatch(p, options); err != nil {
 		return nil, err
 	}
 
@@ -1219,74 +1381,404 @@ func (p Patch) ApplyIndent(doc []byte, indent string) ([]byte, error) {
 // ApplyIndentWithOptions mutates a JSON document according to the patch and the passed in ApplyOptions.
 // It returns the new document indented.
 func (p Patch) ApplyIndentWithOptions(doc []byte, indent string, options *ApplyOptions) ([]byte, error) {
+	data, _, err := p.apply(doc, indent, options)
+	return data, err
+}
+
+// OperationStatus is the outcome of an operation of a patch.
+type OperationStatus int
+
+const (
+	// OperationApplied is the status of an operation that was applied.
+	OperationApplied OperationStatus = iota
+	// OperationSkipped is the status of an operation whose "if" member did
+	// not hold, with ApplyOptions.SupportConditions.
+	OperationSkipped
+	// OperationFailed is the status of an operation that failed. Unless
+	// ApplyOptions.ContinueOnError is set, it is the last one attempted.
+	OperationFailed
+)
+
+func (s OperationStatus) String() string {
+	switch s {
+	case OperationApplied:
+		return "applied"
+	case OperationSkipped:
+		return "skipped"
+	case OperationFailed:
+		return "failed"
+	}
+
+	return fmt.Sprintf("OperationStatus(%d)", int(s))
+}
+
+// OperationResult describes how one operation of a patch was applied.
+type OperationResult struct {
+	// Index is the position of the operation in the patch.
+	Index int
+	// Operation is the operation as it appears in the patch.
+	Operation Operation
+	// Status tells whether the operation was applied, skipped or failed.
+	Status OperationStatus
+	// Err is the error of a failed operation.
+	Err error
+	// Paths holds the concrete paths the operation was applied to, in
+	// document order. A trailing "-" is replaced with the index the value
+	// was added at. With ApplyOptions.SupportWildcards a path with
+	// wildcards may match any number of values, including none.
+	Paths []string
+}
+
+// ApplyWithResults mutates a JSON document according to the patch and the passed in ApplyOptions.
+// It returns the new document, and the result of each operation of the patch. With
+// ApplyOptions.ContinueOnError, failed operations are reported in the results instead of
+// failing the patch.
+func (p Patch) ApplyWithResults(doc []byte, options *ApplyOptions) ([]byte, []OperationResult, error) {
+	return p.apply(doc, "", options)
+}
+
+func (p Patch) apply(doc []byte, indent string, options *ApplyOptions) ([]byte, []OperationResult, error) {
 	if len(doc) == 0 {
-		return doc, nil
+		return doc, nil, nil
+	}
+
+	data, results, err := p.run(doc, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if indent == "" {
+		return data, results, nil
+	}
+
+	var buf bytes.Buffer
+	json.Indent(&buf, data, "", indent)
+	return buf.Bytes(), results, nil
+}
+
+// run applies the patch to the decoded doc, and returns the patched document.
+// On failure, the results up to the failed operation are returned along with
+// the error. With checkOnly, the patched document is only produced to check
+// it against the limits of options, and nil is returned instead.
+func (p Patch) run(doc []byte, options *ApplyOptions) ([]byte, []OperationResult, error) {
+	if err := checkDocumentSize(doc, options); err != nil {
+		return nil, nil, err
 	}
 
 	if !json.Valid(doc) {
-		return nil, ErrInvalid
+		return nil, nil, ErrInvalid
 	}
 
-	raw := json.RawMessage(doc)
-	self := newLazyNode(&raw)
+	if err := checkNestingDepth(doc, options); err != nil {
+		return nil, nil, err
+	}
 
-	var pd container
-	if doc[0] == '[' {
-		pd = &partialArray{
-			self: self,
-		}
-	} else {
-		pd = &partialDoc{
-			self: self,
-			opts: options,
+	if !options.patchChecked {
+		if err := checkPatchLimits(p, options); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	err := unmarshal(doc, pd)
+	pd, err := newContainer(doc, options)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	results, err := p.applyTo(&pd, options)
 	if err != nil {
-		return nil, err
+		return nil, results, err
 	}
 
-	err = nil
+	limited := options.MaxDocumentSize > 0 || options.MaxNestingDepth > 0
+	if options.checkOnly && !limited {
+		return nil, results, nil
+	}
 
+	data, err := json.MarshalEscaped(pd, options.EscapeHTML)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkDocumentLimits(data, options); err != nil {
+		return nil, nil, err
+	}
+
+	if options.checkOnly {
+		return nil, results, nil
+	}
+
+	return data, results, nil
+}
+
+// applyTo applies the patch to a decoded document. On failure, the results
+// up to the failed operation are returned along with the error, and the
+// document is left as it was after the last operation that succeeded.
+func (p Patch) applyTo(pd *container, options *ApplyOptions) ([]OperationResult, error) {
 	var accumulatedCopySize int64
 
-	for _, op := range p {
-		switch op.Kind() {
-		case "add":
-			err = p.add(&pd, op, options)
-		case "remove":
-			err = p.remove(&pd, op, options)
-		case "replace":
-			err = p.replace(&pd, op, options)
-		case "move":
-			err = p.move(&pd, op, options)
-		case "test":
-			err = p.test(&pd, op, options)
-		case "copy":
-			err = p.copy(&pd, op, &accumulatedCopySize, options)
-		default:
-			err = fmt.Errorf("Unexpected kind: %s", op.Kind())
+	results := make([]OperationResult, 0, len(p))
+
+	for i, op := range p {
+		// Operations that fail half way are rolled back when continuing
+		// past errors.
+		var snapshot []byte
+		if options.ContinueOnError && mayFailPartially(op, options) {
+			var err error
+			if snapshot, err = json.Marshal(*pd); err != nil {
+				return nil, err
+			}
 		}
 
-		if err != nil {
-			return nil, err
+		result := p.applyResult(pd, i, op, &accumulatedCopySize, options)
+		results = append(results, result)
+
+		if result.Status != OperationFailed {
+			continue
+		}
+
+		if !options.ContinueOnError {
+			return results, result.Err
+		}
+
+		if snapshot != nil {
+			restored, err := newContainer(snapshot, options)
+			if err != nil {
+				return nil, err
+			}
+			*pd = restored
 		}
 	}
 
-	data, err := json.MarshalEscaped(pd, options.EscapeHTML)
+	return results, nil
+}
+
+// CanApply reports whether the patch applies to doc with the passed in ApplyOptions,
+// without producing the patched document. The first operation that fails is described
+// by an *OperationError. Like with Apply, the patched document must be within
+// ApplyOptions.MaxDocumentSize and ApplyOptions.MaxNestingDepth.
+func (p Patch) CanApply(doc []byte, options *ApplyOptions) error {
+	o := *options
+	o.ContinueOnError = false
+	o.checkOnly = true
+
+	if len(doc) == 0 {
+		return nil
+	}
+
+	_, results, err := p.run(doc, &o)
+	if err != nil && len(results) > 0 {
+		last := results[len(results)-1]
+		return NewOperationError(last.Index, last.Operation, err)
+	}
+
+	return err
+}
+
+// Check runs the patch against doc with the passed in ApplyOptions, without producing
+// the patched document, and returns an *OperationError for each operation that fails.
+// Like with ApplyOptions.ContinueOnError, the operations after a failed one are checked
+// against the document as it was before it. An error is returned if doc cannot be decoded.
+func (p Patch) Check(doc []byte, options *ApplyOptions) ([]*OperationError, error) {
+	o := *options
+	o.ContinueOnError = true
+	o.checkOnly = true
+
+	if len(doc) == 0 {
+		return nil, nil
+	}
+
+	_, results, err := p.run(doc, &o)
 	if err != nil {
 		return nil, err
 	}
 
-	if indent == "" {
-		return data, nil
+	var errs []*OperationError
+	for _, r := range results {
+		if r.Status == OperationFailed {
+			errs = append(errs, NewOperationError(r.Index, r.Operation, r.Err))
+		}
 	}
 
-	var buf bytes.Buffer
-	json.Indent(&buf, data, "", indent)
-	return buf.Bytes(), nil
+	return errs, nil
+}
+
+// applyResult applies an operation of the patch, and describes how it went.
+func (p Patch) applyResult(doc *container, index int, op Operation, accumulatedCopySize *int64, options *ApplyOptions) OperationResult {
+	result := OperationResult{
+		Index:     index,
+		Operation: op,
+	}
+
+	fail := func(err error) OperationResult {
+		result.Status = OperationFailed
+		result.Err = err
+		return result
+	}
+
+	if options.SupportConditions {
+		g, err := op.guard()
+		if err != nil {
+			return fail(err)
+		}
+
+		if g != nil && !g.holds(doc, options) {
+			result.Status = OperationSkipped
+			return result
+		}
+	}
+
+	ops := []Operation{op}
+	if options.SupportWildcards {
+		var err error
+		if ops, err = expandOperation(doc, op, options); err != nil {
+			return fail(err)
+		}
+	}
+
+	if options.Policy != nil {
+		for _, o := range ops {
+			if err := options.Policy.checkOperation(doc, o, options); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	resolved := make([]string, len(ops))
+	for i, o := range ops {
+		if path, err := o.Path(); err == nil {
+			resolved[i] = resolveAppend(doc, o, path, options)
+			result.Paths = append(result.Paths, resolved[i])
+		}
+	}
+
+	// Expanded operations are applied last to first, so that changes to an
+	// array do not shift the elements still to be visited.
+	for i := len(ops) - 1; i >= 0; i-- {
+		if err := p.applyHooked(doc, index, ops[i], resolved[i], accumulatedCopySize, options); err != nil {
+			return fail(err)
+		}
+	}
+
+	return result
+}
+
+// mayFailPartially reports whether an operation may leave some of its changes
+// behind when it fails.
+func mayFailPartially(op Operation, options *ApplyOptions) bool {
+	if options.OnOperationDone != nil {
+		return true
+	}
+
+	switch op.Kind() {
+	case "test", "replace", "remove", "copy":
+	case "add":
+		if options.EnsurePathExistsOnAdd {
+			return true
+		}
+	default:
+		// "move" removes its source before adding it, and custom operations
+		// may make any number of changes.
+		return !(options.SupportPredicates && isPredicate(op.Kind()))
+	}
+
+	if path, err := op.Path(); err == nil && options.SupportWildcards {
+		return hasWildcard(path)
+	}
+
+	return false
+}
+
+// resolveAppend replaces the trailing "-" of the path of an "add", "move" or
+// "copy" into an array with the index the value lands at, the last index of
+// the array once the operation is applied.
+func resolveAppend(doc *container, op Operation, path string, options *ApplyOptions) string {
+	kind := op.Kind()
+	if kind != "add" && kind != "move" && kind != "copy" {
+		return path
+	}
+
+	if !strings.HasSuffix(path, "/-") {
+		return path
+	}
+
+	parent := path[:len(path)-2]
+
+	node, found := valueAt(doc, parent, options)
+	if !found || nodeType(node) != nodeArray {
+		return path
+	}
+
+	ary, err := node.intoAry()
+	if err != nil {
+		return path
+	}
+
+	n := len(ary.nodes)
+
+	// A move within the array takes its value out before adding it back.
+	if kind == "move" {
+		if from, err := op.From(); err == nil && from != path && strings.LastIndex(from, "/") == len(parent) && strings.HasPrefix(from, parent) {
+			n--
+		}
+	}
+
+	return parent + "/" + strconv.Itoa(n)
+}
+
+// newContainer decodes a JSON object or array.
+func newContainer(doc []byte, options *ApplyOptions) (container, error) {
+	raw := json.RawMessage(doc)
+	self := newLazyNode(&raw)
+
+	var pd container
+	if doc[0] == '[' {
+		pd = &partialArray{
+			self: self,
+		}
+	} else {
+		pd = &partialDoc{
+			self: self,
+			opts: options,
+		}
+	}
+
+	if err := unmarshal(doc, pd); err != nil {
+		return nil, err
+	}
+
+	return pd, nil
+}
+
+func (p Patch) applyOperation(doc *container, op Operation, accumulatedCopySize *int64, options *ApplyOptions) error {
+	switch op.Kind() {
+	case "add":
+		return p.add(doc, op, options)
+	case "remove":
+		return p.remove(doc, op, options)
+	case "replace":
+		return p.replace(doc, op, options)
+	case "move":
+		return p.move(doc, op, options)
+	case "test":
+		return p.test(doc, op, options)
+	case "copy":
+		return p.copy(doc, op, accumulatedCopySize, options)
+	}
+
+	if options.SupportPredicates && isPredicate(op.Kind()) {
+		return p.predicate(doc, op, options)
+	}
+
+	if h, ok := options.handler(op.Kind()); ok {
+		return h.Apply(&DocumentView{
+			doc:                 doc,
+			op:                  op,
+			options:             options,
+			accumulatedCopySize: accumulatedCopySize,
+		})
+	}
+
+	return fmt.Errorf("Unexpected kind: %s", op.Kind())
 }
 
 // From http://tools.ietf.org/html/rfc6901#section-4 :
@@ -1298,8 +1790,13 @@ func (p Patch) ApplyIndentWithOptions(doc []byte, indent string, options *ApplyO
 
 var (
 	rfc6901Decoder = strings.NewReplacer("~1", "/", "~0", "~")
+	rfc6901Encoder = strings.NewReplacer("~", "~0", "/", "~1")
 )
 
 func decodePatchKey(k string) string {
 	return rfc6901Decoder.Replace(k)
 }
+
+func encodePatchKey(k string) string {
+	return rfc6901Encoder.Replace(k)
+}
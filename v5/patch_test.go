@@ -3,6 +3,7 @@ package jsonpatch
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -1237,6 +1238,268 @@ func TestMaintainOrderingIndented(t *testing.T) {
 	}
 }
 
+func TestApplyWithResults(t *testing.T) {
+	p, err := DecodePatch([]byte(`[
+		{"op": "add", "path": "/list/-", "value": 3},
+		{"op": "copy", "from": "/list/0", "path": "/list/-"},
+		{"op": "move", "from": "/list/0", "path": "/list/-"},
+		{"op": "move", "from": "/other/0", "path": "/list/-"},
+		{"op": "replace", "path": "/a", "value": 2}
+	]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	out, results, err := p.ApplyWithResults([]byte(`{"list": [1, 2], "other": [5], "a": 1}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"list": [2, 3, 1, 1, 5], "other": [], "a": 2}`) {
+		t.Errorf("unexpected result %s", out)
+	}
+
+	expected := [][]string{{"/list/2"}, {"/list/3"}, {"/list/3"}, {"/list/4"}, {"/a"}}
+	for i, r := range results {
+		if r.Index != i || r.Status != OperationApplied || r.Err != nil {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+
+		if !reflect.DeepEqual(r.Paths, expected[i]) {
+			t.Errorf("operation %d: expected paths %v, got %v", i, expected[i], r.Paths)
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	cases := []struct {
+		doc, patch, result string
+		failed             []int
+	}{
+		{
+			`{"a": 1, "list": [1]}`,
+			`[
+			  {"op": "remove", "path": "/missing"},
+			  {"op": "replace", "path": "/a", "value": 2},
+			  {"op": "test", "path": "/a", "value": 1},
+			  {"op": "add", "path": "/list/5", "value": 1},
+			  {"op": "add", "path": "/list/-", "value": 2}
+			]`,
+			`{"a": 2, "list": [1, 2]}`,
+			[]int{0, 2, 3},
+		},
+		{
+			`{"a": {"b": 1}}`,
+			`[
+			  {"op": "move", "from": "/a/b", "path": "/missing/b"},
+			  {"op": "copy", "from": "/a", "path": "/c"}
+			]`,
+			`{"a": {"b": 1}, "c": {"b": 1}}`,
+			[]int{0},
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			options := NewApplyOptions()
+			options.ContinueOnError = true
+
+			out, results, err := p.ApplyWithResults([]byte(c.doc), options)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+
+			var failed []int
+			for _, r := range results {
+				if r.Status == OperationFailed {
+					if r.Err == nil {
+						t.Errorf("operation %d failed without an error", r.Index)
+					}
+					failed = append(failed, r.Index)
+				}
+			}
+
+			if !reflect.DeepEqual(failed, c.failed) {
+				t.Errorf("expected failed operations %v, got %v", c.failed, failed)
+			}
+		})
+	}
+}
+
+func TestContinueOnErrorRollsBack(t *testing.T) {
+	options := NewApplyOptions()
+	options.ContinueOnError = true
+	options.SupportWildcards = true
+
+	p, err := DecodePatchWithOptions([]byte(`[
+		{"op": "add", "path": "/items/*/tags/-", "value": "x"},
+		{"op": "add", "path": "/done", "value": true}
+	]`), options)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	// The second item has no tags, so the first operation fails after the
+	// first item was changed.
+	doc := `{"items": [{"tags": []}, {}]}`
+
+	out, results, err := p.ApplyWithResults([]byte(doc), options)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"items": [{"tags": []}, {}], "done": true}`) {
+		t.Errorf("expected the failed operation to be rolled back, got %s", out)
+	}
+
+	if results[0].Status != OperationFailed || !errors.Is(results[0].Err, ErrMissing) {
+		t.Errorf("unexpected result %+v", results[0])
+	}
+
+	options.ContinueOnError = false
+	if _, _, err := p.ApplyWithResults([]byte(doc), options); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected the patch to fail, got %v", err)
+	}
+}
+
+func TestCanApply(t *testing.T) {
+	cases := []struct {
+		doc, patch string
+		index      int
+		err        error
+	}{
+		{`{"a": 1}`, `[{"op": "replace", "path": "/a", "value": 2}, {"op": "test", "path": "/a", "value": 2}]`, -1, nil},
+		{`{"a": 1}`, `[{"op": "replace", "path": "/a", "value": 2}, {"op": "test", "path": "/a", "value": 1}]`, 1, ErrTestFailed},
+		{`{"a": {"b": 1}}`, `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "remove", "path": "/c/b"}, {"op": "test", "path": "/a/b", "value": 1}]`, -1, nil},
+		{`{"a": {"b": 1}}`, `[{"op": "remove", "path": "/x"}, {"op": "remove", "path": "/y"}]`, 0, ErrMissing},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			err = p.CanApply([]byte(c.doc), NewApplyOptions())
+			if c.err == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			var opErr *OperationError
+			if !errors.As(err, &opErr) {
+				t.Fatalf("expected an OperationError, got %v", err)
+			}
+
+			if opErr.Index() != c.index || !errors.Is(err, c.err) {
+				t.Errorf("expected operation %d to fail with %v, got %v", c.index, c.err, err)
+			}
+		})
+	}
+
+	p, _ := DecodePatch([]byte(`[{"op": "copy", "from": "/a", "path": "/b"}]`))
+
+	options := NewApplyOptions()
+	options.AccumulatedCopySizeLimit = 3
+
+	var sizeErr *AccumulatedCopySizeError
+	if err := p.CanApply([]byte(`{"a": "long"}`), options); !errors.As(err, &sizeErr) {
+		t.Errorf("expected the copy size limit to be checked, got %v", err)
+	}
+
+	if err := p.CanApply([]byte(`{`), options); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected an invalid document to be reported, got %v", err)
+	}
+}
+
+func TestCanApplyCopySize(t *testing.T) {
+	p, _ := DecodePatch([]byte(`[{"op": "copy", "from": "/a", "path": "/b"}, {"op": "copy", "from": "/a", "path": "/c"}]`))
+
+	values := []string{
+		`"long"`,
+		`{ "x" : [1, 2,
+			3] }`,
+		`"a \"<b>\" & \u00e9 \u2028"`,
+		"\"\u2028\u2029\"",
+		`[ ]`,
+	}
+
+	for i, v := range values {
+		for _, escapeHTML := range []bool{false, true} {
+			doc := []byte(`{"a": ` + v + `}`)
+
+			_, sz, err := deepCopy(newLazyNode(newRawMessage([]byte(v))), &ApplyOptions{EscapeHTML: escapeHTML})
+			if err != nil {
+				t.Fatalf("unable to copy value %d: %s", i, err)
+			}
+
+			if got := encodedSize([]byte(v), escapeHTML); got != sz {
+				t.Errorf("value %d: expected size %d, got %d", i, sz, got)
+			}
+
+			// CanApply shares the copied values, and must agree with Apply
+			// about the accumulated size.
+			for _, limit := range []int64{int64(2*sz - 1), int64(2 * sz)} {
+				options := NewApplyOptions()
+				options.EscapeHTML = escapeHTML
+				options.AccumulatedCopySizeLimit = limit
+
+				_, applyErr := p.ApplyWithOptions(doc, options)
+				canErr := p.CanApply(doc, options)
+
+				var sizeErr *AccumulatedCopySizeError
+				if errors.As(applyErr, &sizeErr) != errors.As(canErr, &sizeErr) {
+					t.Errorf("value %d, limit %d: Apply returned %v, CanApply returned %v", i, limit, applyErr, canErr)
+				}
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := DecodePatch([]byte(`[
+		{"op": "remove", "path": "/x"},
+		{"op": "add", "path": "/b", "value": 1},
+		{"op": "test", "path": "/b", "value": 2},
+		{"op": "move", "from": "/a", "path": "/missing/a"},
+		{"op": "test", "path": "/a", "value": 1}
+	]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	errs, err := p.Check([]byte(`{"a": 1}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var indices []int
+	for _, e := range errs {
+		indices = append(indices, e.Index())
+	}
+
+	if !reflect.DeepEqual(indices, []int{0, 2, 3}) {
+		t.Errorf("expected operations 0, 2 and 3 to fail, got %v", indices)
+	}
+
+	if !errors.Is(errs[1], ErrTestFailed) || errs[1].Operation().Kind() != "test" {
+		t.Errorf("unexpected error %v", errs[1])
+	}
+}
+
 // This is a compile time check that encoding/json's RawMessage can be used in Operation
 func init() {
 	msg := json.RawMessage([]byte(`1`))
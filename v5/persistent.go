@@ -0,0 +1,718 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// ImmutableDocument is a version of a JSON document that never changes.
+// Applying a patch returns a new version, which shares with the version it
+// was applied to every subtree the patch did not touch, so that keeping many
+// versions costs little more than the changes between them. Old versions
+// stay valid, and versions are safe for concurrent use.
+//
+// Only the operations of RFC 6902 are supported. Of the ApplyOptions, the
+// options of these operations, the Policy and the limits on patches apply.
+type ImmutableDocument struct {
+	root    *lazyNode
+	options *ApplyOptions
+}
+
+// NewImmutableDocument returns the first version of doc, which must be an
+// object or an array. Patches are applied with the passed in ApplyOptions.
+func NewImmutableDocument(doc []byte, options *ApplyOptions) (*ImmutableDocument, error) {
+	if err := checkDocumentSize(doc, options); err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(doc) {
+		return nil, ErrInvalid
+	}
+
+	if err := checkNestingDepth(doc, options); err != nil {
+		return nil, err
+	}
+
+	root := newLazyNode(newRawMessage(doc))
+	if nodeType(root) == nodeScalar {
+		return nil, ErrInvalid
+	}
+
+	// Nodes decode themselves on first use. The first version is decoded in
+	// full, so that all the versions derived from it share its nodes rather
+	// than decoding copies of them.
+	if err := decodeAll(root, options); err != nil {
+		return nil, err
+	}
+
+	return &ImmutableDocument{root: root, options: options}, nil
+}
+
+// Bytes returns the document.
+func (d *ImmutableDocument) Bytes() ([]byte, error) {
+	return json.MarshalEscaped(d.root, d.options.EscapeHTML)
+}
+
+// Get returns the value at path.
+func (d *ImmutableDocument) Get(path string) ([]byte, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := d.lookup(d.root, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get %s: %w", path, err)
+	}
+
+	if node == nil {
+		return append([]byte(nil), rawJSONNull...), nil
+	}
+
+	return json.MarshalEscaped(node, d.options.EscapeHTML)
+}
+
+// Apply applies the patch and returns the new version of the document. The
+// version it is applied to is left unchanged.
+func (d *ImmutableDocument) Apply(p Patch) (*ImmutableDocument, error) {
+	if err := checkPatchLimits(p, d.options); err != nil {
+		return nil, err
+	}
+
+	root := d.root
+
+	var accumulatedCopySize int64
+
+	for _, op := range p {
+		if d.options.Policy != nil {
+			doc := root.container()
+			if err := d.options.Policy.checkOperation(&doc, op, d.options); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+
+		switch op.Kind() {
+		case "add":
+			root, err = d.add(root, op)
+		case "remove":
+			root, err = d.remove(root, op)
+		case "replace":
+			root, err = d.replace(root, op)
+		case "move":
+			root, err = d.move(root, op)
+		case "copy":
+			root, err = d.copy(root, op, &accumulatedCopySize)
+		case "test":
+			err = d.test(root, op)
+		default:
+			err = fmt.Errorf("Unexpected kind: %s", op.Kind())
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ImmutableDocument{root: root, options: d.options}, nil
+}
+
+// Diff returns a patch that turns the document into to, as CreatePatch
+// does. Subtrees the two versions share are skipped without being compared.
+func (d *ImmutableDocument) Diff(to *ImmutableDocument) (Patch, error) {
+	p := Patch{}
+	if err := diffShared(&p, "", d.root, to.root); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (d *ImmutableDocument) add(root *lazyNode, op Operation) (*lazyNode, error) {
+	path, err := op.Path()
+	if err != nil {
+		return nil, fmt.Errorf("add operation failed to decode path: %w", ErrMissing)
+	}
+
+	if path == "" {
+		val := op.value()
+		if nodeType(val) == nodeScalar {
+			return nil, fmt.Errorf("add operation value must be object or array: %w", ErrInvalid)
+		}
+		return val, nil
+	}
+
+	tokens, err := targetPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	next, found, err := d.update(root, tokens, 0, d.options.EnsurePathExistsOnAdd, func(parent container, key string) error {
+		return parent.add(key, op.value(), d.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in add for path: '%s': %w", path, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("add operation does not apply: doc is missing path: \"%s\": %w", path, ErrMissing)
+	}
+
+	return next, nil
+}
+
+func (d *ImmutableDocument) remove(root *lazyNode, op Operation) (*lazyNode, error) {
+	path, err := op.Path()
+	if err != nil {
+		return nil, fmt.Errorf("remove operation failed to decode path: %w", ErrMissing)
+	}
+
+	tokens, err := targetPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	next, found, err := d.update(root, tokens, 0, false, func(parent container, key string) error {
+		return parent.remove(key, d.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in remove for path: '%s': %w", path, err)
+	}
+
+	if !found {
+		if d.options.AllowMissingPathOnRemove {
+			return root, nil
+		}
+		return nil, fmt.Errorf("remove operation does not apply: doc is missing path: \"%s\": %w", path, ErrMissing)
+	}
+
+	return next, nil
+}
+
+func (d *ImmutableDocument) replace(root *lazyNode, op Operation) (*lazyNode, error) {
+	path, err := op.Path()
+	if err != nil {
+		return nil, fmt.Errorf("replace operation failed to decode path: %w", err)
+	}
+
+	if path == "" {
+		val := op.value()
+		if nodeType(val) == nodeScalar && !val.isNull() {
+			return nil, fmt.Errorf("replace operation value must be object or array: %w", ErrInvalid)
+		}
+		return val, nil
+	}
+
+	tokens, err := targetPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	next, found, err := d.update(root, tokens, 0, false, func(parent container, key string) error {
+		if _, err := parent.get(key, d.options); err != nil {
+			return fmt.Errorf("replace operation does not apply: doc is missing key: %s: %w", path, ErrMissing)
+		}
+		return parent.set(key, op.value(), d.options)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("replace operation does not apply: doc is missing path: %s: %w", path, ErrMissing)
+	}
+
+	return next, nil
+}
+
+func (d *ImmutableDocument) move(root *lazyNode, op Operation) (*lazyNode, error) {
+	from, err := op.From()
+	if err != nil {
+		return nil, fmt.Errorf("move operation failed to decode from: %w", err)
+	}
+
+	if from == "" {
+		return nil, fmt.Errorf("unable to move entire document to another path: %w", ErrInvalid)
+	}
+
+	fromTokens, err := targetPointer(from)
+	if err != nil {
+		return nil, err
+	}
+
+	// The value moves as is, so that it stays shared with the previous
+	// version.
+	var val *lazyNode
+
+	next, found, err := d.update(root, fromTokens, 0, false, func(parent container, key string) error {
+		v, err := parent.get(key, d.options)
+		if err != nil {
+			return err
+		}
+		val = v
+		return parent.remove(key, d.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in move for path: '%s': %w", from, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("move operation does not apply: doc is missing from path: %s: %w", from, ErrMissing)
+	}
+
+	path, err := op.Path()
+	if err != nil {
+		return nil, fmt.Errorf("move operation failed to decode path: %w", err)
+	}
+
+	tokens, err := targetPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	next, found, err = d.update(next, tokens, 0, false, func(parent container, key string) error {
+		return parent.add(key, val, d.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in move for path: '%s': %w", path, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("move operation does not apply: doc is missing destination path: %s: %w", path, ErrMissing)
+	}
+
+	return next, nil
+}
+
+func (d *ImmutableDocument) copy(root *lazyNode, op Operation, accumulatedCopySize *int64) (*lazyNode, error) {
+	from, err := op.From()
+	if err != nil {
+		return nil, fmt.Errorf("copy operation failed to decode from: %w", err)
+	}
+
+	fromTokens, err := splitPointer(from)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := d.lookup(root, fromTokens)
+	if err != nil {
+		return nil, fmt.Errorf("error in copy for from: '%s': %w", from, err)
+	}
+
+	// Nodes are never changed, so the copy is the value itself. Its size
+	// is only needed to enforce the limit.
+	if d.options.AccumulatedCopySizeLimit > 0 {
+		data, err := json.MarshalEscaped(val, d.options.EscapeHTML)
+		if err != nil {
+			return nil, fmt.Errorf("error while performing deep copy: %w", err)
+		}
+
+		(*accumulatedCopySize) += int64(len(data))
+		if *accumulatedCopySize > d.options.AccumulatedCopySizeLimit {
+			return nil, NewAccumulatedCopySizeError(d.options.AccumulatedCopySizeLimit, *accumulatedCopySize)
+		}
+	}
+
+	path, err := op.Path()
+	if err != nil {
+		return nil, fmt.Errorf("copy operation failed to decode path: %w", ErrMissing)
+	}
+
+	tokens, err := targetPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	next, found, err := d.update(root, tokens, 0, false, func(parent container, key string) error {
+		return parent.add(key, val, d.options)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error in copy for path: '%s': %w", path, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("copy operation does not apply: doc is missing destination path: %s: %w", path, ErrMissing)
+	}
+
+	return next, nil
+}
+
+func (d *ImmutableDocument) test(root *lazyNode, op Operation) error {
+	path, err := op.Path()
+	if err != nil {
+		return fmt.Errorf("test operation failed to decode path: %w", err)
+	}
+
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return err
+	}
+
+	var val *lazyNode
+
+	if len(tokens) == 0 {
+		val = root
+	} else {
+		parent, err := d.lookup(root, tokens[:len(tokens)-1])
+		if err != nil {
+			return fmt.Errorf("test operation does not apply: is missing path: %s: %w", path, err)
+		}
+
+		// A missing member is tested as null.
+		val, err = d.lookup(parent, tokens[len(tokens)-1:])
+		if err != nil && !(nodeType(parent) == nodeObject && errors.Is(err, ErrMissing)) {
+			return fmt.Errorf("error in test for path: '%s': %w", path, err)
+		}
+	}
+
+	ov := op.value()
+
+	if val == nil {
+		if ov.isNull() {
+			return nil
+		}
+		return fmt.Errorf("testing value %s failed: %w", path, ErrTestFailed)
+	} else if ov.isNull() {
+		return fmt.Errorf("testing value %s failed: %w", path, ErrTestFailed)
+	}
+
+	// Comparing decodes the nodes, so a private copy of the value is
+	// compared.
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	if newLazyNode(newRawMessage(data)).equal(ov) {
+		return nil
+	}
+
+	return fmt.Errorf("testing value %s failed: %w", path, ErrTestFailed)
+}
+
+// targetPointer splits the pointer to the value an operation changes. As
+// with findObject, the empty pointer stands for the member "" of the
+// document.
+func targetPointer(path string) ([]string, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return []string{""}, nil
+	}
+
+	return tokens, nil
+}
+
+// update returns a copy of n in which the container holding the value at
+// tokens[depth:] was passed to fn, along with the last token. Only the
+// containers on the way are copied. found is false when a container on the
+// way is missing and, with ensure, could not be created.
+func (d *ImmutableDocument) update(n *lazyNode, tokens []string, depth int, ensure bool, fn func(parent container, key string) error) (*lazyNode, bool, error) {
+	c, ok := d.clone(n)
+	if !ok {
+		return nil, false, nil
+	}
+
+	parent := c.container()
+	key := tokens[depth]
+
+	if depth == len(tokens)-1 {
+		if err := fn(parent, key); err != nil {
+			return nil, true, err
+		}
+		return c, true, nil
+	}
+
+	child, idx, ok := d.child(c, key)
+	created := false
+
+	if !ok || child == nil {
+		if !ensure {
+			return nil, false, nil
+		}
+
+		// Missing containers are created as ensurePathExists does, and were
+		// checked against the Policy along with the operation.
+		var err error
+		if child, err = d.create(parent, key, tokens[depth+1]); err != nil {
+			return nil, true, err
+		}
+		created = true
+	}
+
+	next, found, err := d.update(child, tokens, depth+1, ensure, fn)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	switch {
+	case c.which == eDoc:
+		c.doc.obj[key] = next
+	case created:
+		// The created container is the only node that is not shared.
+		for i, v := range c.ary.nodes {
+			if v == child {
+				c.ary.nodes[i] = next
+			}
+		}
+	default:
+		c.ary.nodes[idx] = next
+	}
+
+	return c, true, nil
+}
+
+// create adds an empty container at key in parent, an array when next is an
+// array index and an object otherwise.
+func (d *ImmutableDocument) create(parent container, key, next string) (*lazyNode, error) {
+	if pa, ok := parent.(*partialArray); ok {
+		if idx, err := strconv.Atoi(key); err == nil {
+			// Pad the array with null values up to the required index.
+			for i := len(pa.nodes); i < idx; i++ {
+				pa.add("-", newLazyNode(newRawMessage(rawJSONNull)), d.options)
+			}
+		}
+	}
+
+	var node *lazyNode
+
+	if idx, err := strconv.Atoi(next); err == nil || next == "-" {
+		if idx < 0 {
+			if !d.options.SupportNegativeIndices {
+				return nil, fmt.Errorf("Unable to ensure path for invalid index: %d: %w", idx, ErrInvalidIndex)
+			}
+
+			if idx < -1 {
+				return nil, fmt.Errorf("Unable to ensure path for negative index other than -1: %d: %w", idx, ErrInvalidIndex)
+			}
+
+			idx = 0
+		}
+
+		node = &lazyNode{which: eAry}
+		node.ary = &partialArray{self: node, nodes: []*lazyNode{}}
+
+		// Pad the new array with null values up to the required index.
+		for i := 0; i < idx; i++ {
+			node.ary.nodes = append(node.ary.nodes, newLazyNode(newRawMessage(rawJSONNull)))
+		}
+	} else {
+		node = &lazyNode{which: eDoc}
+		node.doc = &partialDoc{self: node, obj: map[string]*lazyNode{}, opts: d.options}
+	}
+
+	if err := parent.add(key, node, d.options); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// child returns the member key of the decoded object or array c, and its
+// index in an array.
+func (d *ImmutableDocument) child(c *lazyNode, key string) (*lazyNode, int, bool) {
+	if c.which == eDoc {
+		v, ok := c.doc.obj[key]
+		return v, 0, ok
+	}
+
+	idx, err := strconv.Atoi(key)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	n := len(c.ary.nodes)
+	if idx < 0 && d.options.SupportNegativeIndices {
+		idx += n
+	}
+
+	if idx < 0 || idx >= n {
+		return nil, 0, false
+	}
+
+	return c.ary.nodes[idx], idx, true
+}
+
+// clone returns a shallow copy of the object or array n, which is not
+// shared and may be changed.
+func (d *ImmutableDocument) clone(n *lazyNode) (*lazyNode, bool) {
+	n, ok := d.open(n)
+	if !ok {
+		return nil, false
+	}
+
+	c := &lazyNode{which: n.which}
+
+	if n.which == eAry {
+		c.ary = &partialArray{self: c, nodes: append([]*lazyNode(nil), n.ary.nodes...)}
+		return c, true
+	}
+
+	c.doc = &partialDoc{
+		self: c,
+		keys: append([]string(nil), n.doc.keys...),
+		obj:  make(map[string]*lazyNode, len(n.doc.obj)),
+		opts: d.options,
+	}
+
+	for k, v := range n.doc.obj {
+		c.doc.obj[k] = v
+	}
+
+	return c, true
+}
+
+// open returns the object or array n decoded. A node that was not decoded
+// yet is decoded into a copy, leaving n unchanged.
+func (d *ImmutableDocument) open(n *lazyNode) (*lazyNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if n.which != eRaw {
+		return n, true
+	}
+
+	c := newLazyNode(n.raw)
+
+	var err error
+
+	switch nodeType(c) {
+	case nodeObject:
+		_, err = c.intoDoc(d.options)
+	case nodeArray:
+		_, err = c.intoAry()
+	default:
+		return nil, false
+	}
+
+	return c, err == nil
+}
+
+// lookup returns the node at tokens below n. A nil node stands for null.
+func (d *ImmutableDocument) lookup(n *lazyNode, tokens []string) (*lazyNode, error) {
+	for i, tok := range tokens {
+		c, ok := d.open(n)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an object or array: %w", joinPointer(tokens[:i]), ErrMissing)
+		}
+
+		if c.which == eDoc {
+			v, ok := c.doc.obj[tok]
+			if !ok {
+				return nil, fmt.Errorf("unable to get nonexistent key: %s: %w", tok, ErrMissing)
+			}
+			n = v
+			continue
+		}
+
+		v, err := c.ary.get(tok, d.options)
+		if err != nil {
+			return nil, err
+		}
+		n = v
+	}
+
+	return n, nil
+}
+
+// container returns the object or array of a decoded node.
+func (n *lazyNode) container() container {
+	if n.which == eAry {
+		return n.ary
+	}
+
+	return n.doc
+}
+
+// diffShared adds the operations turning a into b to the patch, as
+// diffNodes does, without comparing the subtrees a and b share.
+func diffShared(p *Patch, path string, a, b *lazyNode) error {
+	if a == b {
+		return nil
+	}
+
+	if a != nil && b != nil && a.which == eDoc && b.which == eDoc {
+		seen := map[string]bool{}
+
+		for _, k := range a.doc.keys {
+			if _, ok := b.doc.obj[k]; !ok && !seen[k] {
+				seen[k] = true
+				if err := appendOperation(p, "remove", path+"/"+encodePatchKey(k), nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, k := range b.doc.keys {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+
+			child := path + "/" + encodePatchKey(k)
+
+			av, ok := a.doc.obj[k]
+			if !ok {
+				if err := appendOperation(p, "add", child, b.doc.obj[k]); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := diffShared(p, child, av, b.doc.obj[k]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if a != nil && b != nil && a.which == eAry && b.which == eAry && len(a.ary.nodes) == len(b.ary.nodes) {
+		for i, v := range a.ary.nodes {
+			if err := diffShared(p, path+"/"+strconv.Itoa(i), v, b.ary.nodes[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// Comparing decodes the nodes, so private copies are compared.
+	ac, err := privateNode(a)
+	if err != nil {
+		return err
+	}
+
+	bc, err := privateNode(b)
+	if err != nil {
+		return err
+	}
+
+	return diffNodes(p, path, ac, bc)
+}
+
+func privateNode(n *lazyNode) (*lazyNode, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	if n.which == eRaw {
+		return newLazyNode(n.raw), nil
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLazyNode(newRawMessage(data)), nil
+}
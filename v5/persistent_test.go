@@ -0,0 +1,301 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+func immutablePatch(t *testing.T, s string) Patch {
+	p, err := DecodePatch([]byte(s))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+	return p
+}
+
+func TestImmutableDocumentCases(t *testing.T) {
+	defer configureGlobals(int64(100))()
+
+	for i, c := range Cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := NewApplyOptions()
+			options.AllowMissingPathOnRemove = c.allowMissingPathOnRemove
+			options.EnsurePathExistsOnAdd = c.ensurePathExistsOnAdd
+
+			d, err := NewImmutableDocument([]byte(c.doc), options)
+			if err != nil {
+				// Only objects and arrays are documents.
+				return
+			}
+
+			before, err := d.Bytes()
+			if err != nil {
+				t.Fatalf("unable to marshal document: %s", err)
+			}
+
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			next, err := d.Apply(p)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			out, err := next.Bytes()
+			if err != nil {
+				t.Fatalf("unable to marshal document: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("expected %s, got %s", reformatJSON(c.result), reformatJSON(string(out)))
+			}
+
+			after, err := d.Bytes()
+			if err != nil {
+				t.Fatalf("unable to marshal document: %s", err)
+			}
+
+			if string(before) != string(after) {
+				t.Errorf("previous version changed from %s to %s", before, after)
+			}
+		})
+	}
+
+	for i, c := range BadCases {
+		t.Run(fmt.Sprintf("bad case %d", i), func(t *testing.T) {
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				return
+			}
+
+			d, err := NewImmutableDocument([]byte(c.doc), NewApplyOptions())
+			if err != nil {
+				return
+			}
+
+			if _, err := d.Apply(p); err == nil {
+				t.Errorf("patch %s should have failed to apply", c.patch)
+			}
+		})
+	}
+}
+
+func TestImmutableDocumentSharing(t *testing.T) {
+	d, err := NewImmutableDocument([]byte(`{"a": {"b": [1, 2]}, "c": {"d": true}, "e": [{"f": 1}, {"g": 2}]}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create document: %s", err)
+	}
+
+	next, err := d.Apply(immutablePatch(t, `[{"op": "add", "path": "/a/b/-", "value": 3}, {"op": "replace", "path": "/e/1/g", "value": 3}]`))
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if d.root == next.root {
+		t.Fatal("expected a new root")
+	}
+
+	// Untouched subtrees are shared, and the paths to changes are copied.
+	shared := []struct {
+		tokens []string
+		shared bool
+	}{
+		{[]string{"c"}, true},
+		{[]string{"e", "0"}, true},
+		{[]string{"a"}, false},
+		{[]string{"a", "b"}, false},
+		{[]string{"e"}, false},
+		{[]string{"e", "1"}, false},
+	}
+
+	for i, c := range shared {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			a, err := d.lookup(d.root, c.tokens)
+			if err != nil {
+				t.Fatalf("unable to get %v: %s", c.tokens, err)
+			}
+
+			b, err := next.lookup(next.root, c.tokens)
+			if err != nil {
+				t.Fatalf("unable to get %v: %s", c.tokens, err)
+			}
+
+			if (a == b) != c.shared {
+				t.Errorf("expected %v to be shared: %v", c.tokens, c.shared)
+			}
+		})
+	}
+
+	got, err := d.Get("/a/b")
+	if err != nil {
+		t.Fatalf("unable to get /a/b: %s", err)
+	}
+
+	if !compareJSON(string(got), `[1, 2]`) {
+		t.Errorf("previous version changed: %s", got)
+	}
+
+	got, err = next.Get("/a/b")
+	if err != nil {
+		t.Fatalf("unable to get /a/b: %s", err)
+	}
+
+	if !compareJSON(string(got), `[1, 2, 3]`) {
+		t.Errorf("expected [1, 2, 3], got %s", got)
+	}
+}
+
+func TestImmutableDocumentErrors(t *testing.T) {
+	options := NewApplyOptions()
+	options.AccumulatedCopySizeLimit = 10
+
+	d, err := NewImmutableDocument([]byte(`{"a": "0123456789", "b": [1]}`), options)
+	if err != nil {
+		t.Fatalf("unable to create document: %s", err)
+	}
+
+	cases := []struct {
+		patch string
+		err   error
+	}{
+		{`[{"op": "test", "path": "/a", "value": "x"}]`, ErrTestFailed},
+		{`[{"op": "remove", "path": "/x"}]`, ErrMissing},
+		{`[{"op": "add", "path": "/x/y", "value": 1}]`, ErrMissing},
+		{`[{"op": "replace", "path": "/b/1", "value": 1}]`, ErrMissing},
+		{`[{"op": "move", "from": "", "path": "/x"}]`, ErrInvalid},
+		{`[{"op": "add", "path": "/b/3", "value": 1}]`, ErrInvalidIndex},
+		{`[{"op": "copy", "from": "/a", "path": "/c"}]`, &AccumulatedCopySizeError{}},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			_, err := d.Apply(immutablePatch(t, c.patch))
+
+			if e, ok := c.err.(*AccumulatedCopySizeError); ok {
+				if !errors.As(err, &e) {
+					t.Errorf("expected AccumulatedCopySizeError, got %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+
+	if _, err := NewImmutableDocument([]byte(`1`), options); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+}
+
+func TestImmutableDocumentDiff(t *testing.T) {
+	d, err := NewImmutableDocument([]byte(`{"a": {"b": [1, 2, 3]}, "c": {"d": true}, "e": "f"}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create document: %s", err)
+	}
+
+	cases := []struct {
+		patch string
+		diff  string
+	}{
+		{`[]`, `[]`},
+		{`[{"op": "test", "path": "/e", "value": "f"}]`, `[]`},
+		{`[{"op": "replace", "path": "/a/b/1", "value": 5}]`, `[{"op": "replace", "path": "/a/b/1", "value": 5}]`},
+		{`[{"op": "remove", "path": "/a/b/0"}]`, `[{"op": "remove", "path": "/a/b/0"}]`},
+		{`[{"op": "add", "path": "/x", "value": {"y": 1}}, {"op": "remove", "path": "/c"}]`, `[{"op": "remove", "path": "/c"}, {"op": "add", "path": "/x", "value": {"y": 1}}]`},
+		{`[{"op": "replace", "path": "/e", "value": "f"}]`, `[]`},
+		{`[{"op": "move", "from": "/c", "path": "/g"}]`, `[{"op": "remove", "path": "/c"}, {"op": "add", "path": "/g", "value": {"d": true}}]`},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			next, err := d.Apply(immutablePatch(t, c.patch))
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			p, err := d.Diff(next)
+			if err != nil {
+				t.Fatalf("unable to diff: %s", err)
+			}
+
+			got, err := json.Marshal(p)
+			if err != nil {
+				t.Fatalf("unable to marshal patch: %s", err)
+			}
+
+			if !compareJSON(string(got), c.diff) {
+				t.Errorf("expected %s, got %s", c.diff, got)
+			}
+
+			original, _ := d.Bytes()
+			modified, _ := next.Bytes()
+
+			out, err := p.Apply(original)
+			if err != nil {
+				t.Fatalf("unable to apply diff: %s", err)
+			}
+
+			if !compareJSON(string(out), string(modified)) {
+				t.Errorf("expected %s, got %s", modified, out)
+			}
+		})
+	}
+}
+
+func TestImmutableDocumentConcurrency(t *testing.T) {
+	d, err := NewImmutableDocument([]byte(`{"n": 0, "items": [{"a": 1}], "m": {"x": {"y": [1, 2]}}}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to create document: %s", err)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			patch := fmt.Sprintf(`[
+				{"op": "test", "path": "/m/x", "value": {"y": [1, 2]}},
+				{"op": "replace", "path": "/n", "value": %d},
+				{"op": "copy", "from": "/items/0", "path": "/items/-"},
+				{"op": "add", "path": "/items/1/b", "value": [%d]}
+			]`, i, i)
+
+			for j := 0; j < 50; j++ {
+				next, err := d.Apply(immutablePatch(t, patch))
+				if err != nil {
+					t.Errorf("unable to apply patch: %s", err)
+					return
+				}
+
+				if _, err := next.Get("/items/1/b/0"); err != nil {
+					t.Errorf("unable to get: %s", err)
+				}
+
+				if _, err := d.Diff(next); err != nil {
+					t.Errorf("unable to diff: %s", err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	got, err := d.Bytes()
+	if err != nil {
+		t.Fatalf("unable to marshal document: %s", err)
+	}
+
+	if !compareJSON(string(got), `{"n": 0, "items": [{"a": 1}], "m": {"x": {"y": [1, 2]}}}`) {
+		t.Errorf("document changed: %s", got)
+	}
+}
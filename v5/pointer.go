@@ -0,0 +1,96 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitPointer breaks a JSON Pointer into its decoded reference tokens. The
+// root pointer "" yields an empty, non-nil slice.
+func splitPointer(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+
+	if path[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q: %w", path, ErrInvalid)
+	}
+
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		parts[i] = decodePatchKey(part)
+	}
+
+	return parts, nil
+}
+
+// joinPointer is the inverse of splitPointer.
+func joinPointer(tokens []string) string {
+	var sb strings.Builder
+
+	for _, tok := range tokens {
+		sb.WriteByte('/')
+		sb.WriteString(encodePatchKey(tok))
+	}
+
+	return sb.String()
+}
+
+// appendPointer returns a new pointer with tokens appended to base, leaving
+// base untouched.
+func appendPointer(base []string, tokens ...string) []string {
+	out := make([]string, 0, len(base)+len(tokens))
+	out = append(out, base...)
+	return append(out, tokens...)
+}
+
+// hasPointerPrefix reports whether prefix is equal to, or an ancestor of, p.
+func hasPointerPrefix(p, prefix []string) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+
+	for i, tok := range prefix {
+		if p[i] != tok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pointerEqual(a, b []string) bool {
+	return len(a) == len(b) && hasPointerPrefix(a, b)
+}
+
+// arrayIndex parses a reference token as a non-negative array index.
+func arrayIndex(tok string) (int, bool) {
+	if tok == "" {
+		return 0, false
+	}
+
+	for _, c := range tok {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+
+	idx, err := strconv.Atoi(tok)
+	if err != nil {
+		return 0, false
+	}
+
+	return idx, true
+}
+
+// isNegativeIndex reports whether tok uses the non-standard negative index
+// notation enabled by SupportNegativeIndices.
+func isNegativeIndex(tok string) bool {
+	if len(tok) < 2 || tok[0] != '-' {
+		return false
+	}
+
+	_, ok := arrayIndex(tok[1:])
+	return ok
+}
@@ -0,0 +1,309 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PolicyEffect is the effect of a PolicyRule.
+type PolicyEffect int
+
+const (
+	// PolicyAllow lets matching operations through.
+	PolicyAllow PolicyEffect = iota
+	// PolicyDeny rejects matching operations.
+	PolicyDeny
+)
+
+// PolicyRule allows or denies operations on the paths matching a pointer
+// glob. In Path, a "*" reference token matches any one token, "*" within a
+// token matches any run of characters, and "**" matches any number of
+// tokens, including none.
+type PolicyRule struct {
+	// Name identifies the rule in a PolicyError. It defaults to a
+	// description of the rule.
+	Name string
+	// Effect tells whether the rule allows or denies the operations.
+	Effect PolicyEffect
+	// Ops lists the operation kinds the rule applies to. An empty list
+	// applies to all of them.
+	Ops []string
+	// Path is the pointer glob of the rule.
+	Path string
+}
+
+// String returns the name of the rule.
+func (r *PolicyRule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	effect := "allow"
+	if r.Effect == PolicyDeny {
+		effect = "deny"
+	}
+
+	ops := "*"
+	if len(r.Ops) > 0 {
+		ops = strings.Join(r.Ops, ",")
+	}
+
+	return fmt.Sprintf("%s %s %s", effect, ops, r.Path)
+}
+
+// Policy restricts the operations of patches applied with
+// ApplyOptions.Policy. Both "path" and "from" of an operation are checked,
+// along with the paths "add" creates with ApplyOptions.EnsurePathExistsOnAdd.
+//
+// Array indices are matched as the index they refer to in the document, so
+// that "-1", "01" or a final "-" match the same rules as that index. Tokens
+// of arrays that are not indices, and negative indices out of range, are
+// rejected.
+//
+// Deny rules win over allow rules. Since an operation on a value changes all
+// of its descendants, a deny rule also rejects operations on the ancestors of
+// the paths it matches: denying "/id" rejects replacing the whole document.
+// Paths no rule matches are subject to Default.
+type Policy struct {
+	Rules   []PolicyRule
+	Default PolicyEffect
+}
+
+// check checks that kind may be applied to paths, whose tokens are given in
+// the same order. Deny rules are checked for all of the paths first.
+func (p *Policy) check(kind string, paths []string, tokens [][]string) error {
+
+	patterns := make([][]string, len(p.Rules))
+	for i := range p.Rules {
+		var err error
+		if patterns[i], err = splitPointer(p.Rules[i].Path); err != nil {
+			return fmt.Errorf("invalid policy rule %s: %w", &p.Rules[i], err)
+		}
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Effect != PolicyDeny || !r.appliesTo(kind) {
+			continue
+		}
+
+		for j, path := range paths {
+			if globMatch(patterns[i], tokens[j], true) {
+				return NewPolicyError(r.String(), kind, path)
+			}
+		}
+	}
+
+	if p.Default != PolicyDeny {
+		return nil
+	}
+
+	for j, path := range paths {
+		allowed := false
+
+		for i := range p.Rules {
+			r := &p.Rules[i]
+			if r.Effect == PolicyAllow && r.appliesTo(kind) && globMatch(patterns[i], tokens[j], false) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return NewPolicyError("default", kind, path)
+		}
+	}
+
+	return nil
+}
+
+func (r *PolicyRule) appliesTo(kind string) bool {
+	if len(r.Ops) == 0 {
+		return true
+	}
+
+	for _, op := range r.Ops {
+		if op == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkOperation checks the paths of an operation. With doc, the tokens
+// indexing its arrays are resolved before they are matched, and the paths
+// created by ApplyOptions.EnsurePathExistsOnAdd are checked as well.
+func (p *Policy) checkOperation(doc *container, op Operation, options *ApplyOptions) error {
+	kind := op.Kind()
+
+	var (
+		paths  []string
+		tokens [][]string
+	)
+
+	if from, err := op.From(); err == nil {
+		toks, _, err := resolveIndices(doc, from, false, options)
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, from)
+		tokens = append(tokens, toks)
+	}
+
+	if path, err := op.Path(); err == nil {
+		toks, found, err := resolveIndices(doc, path, kind == "add" || kind == "copy" || kind == "move", options)
+		if err != nil {
+			return err
+		}
+
+		if doc != nil && kind == "add" && options.EnsurePathExistsOnAdd {
+			for i := found + 1; i < len(toks); i++ {
+				paths = append(paths, joinPointer(toks[:i]))
+				tokens = append(tokens, toks[:i])
+			}
+		}
+
+		paths = append(paths, path)
+		tokens = append(tokens, toks)
+	}
+
+	return p.check(kind, paths, tokens)
+}
+
+// checkPath checks that kind may be applied to path in doc, whose last token
+// is a position to insert at with insert.
+func (p *Policy) checkPath(doc *container, kind, path string, insert bool, options *ApplyOptions) error {
+	tokens, _, err := resolveIndices(doc, path, insert, options)
+	if err != nil {
+		return err
+	}
+
+	return p.check(kind, []string{path}, [][]string{tokens})
+}
+
+// resolveIndices splits path into tokens. With doc, the tokens indexing its arrays
+// are replaced by the index they refer to, so that rules match "-2", "00" and
+// a final "-" as the index they stand for, and found is the number of leading
+// tokens that refer to existing values. With insert, the last token is a
+// position to insert at rather than an element. Tokens of arrays that do not
+// resolve to an index are an error.
+func resolveIndices(doc *container, path string, insert bool, options *ApplyOptions) (tokens []string, found int, err error) {
+	if tokens, err = splitPointer(path); err != nil || doc == nil {
+		return tokens, 0, err
+	}
+
+	con := *doc
+
+	for i, tok := range tokens {
+		var next *lazyNode
+
+		switch c := con.(type) {
+		case *partialDoc:
+			if _, ok := c.obj[tok]; !ok {
+				return tokens, i, nil
+			}
+			next = c.obj[tok]
+		case *partialArray:
+			size := len(c.nodes)
+			if insert && i == len(tokens)-1 {
+				size++
+			}
+
+			idx, err := strconv.Atoi(tok)
+			switch {
+			case tok == "-" && i == len(tokens)-1:
+				idx = len(c.nodes)
+			case err != nil:
+				return nil, 0, fmt.Errorf("value was not a proper array index: '%s': %w", tok, ErrInvalidIndex)
+			case idx < 0 && (!options.SupportNegativeIndices || idx < -size):
+				return nil, 0, fmt.Errorf("Unable to access invalid index: %d: %w", idx, ErrInvalidIndex)
+			case idx < 0:
+				idx += size
+			}
+
+			tokens[i] = strconv.Itoa(idx)
+			if idx >= len(c.nodes) {
+				return tokens, i, nil
+			}
+			next = c.nodes[idx]
+		default:
+			return tokens, i, nil
+		}
+
+		switch nodeType(next) {
+		case nodeObject:
+			if con, err = next.intoDoc(options); err != nil {
+				return nil, 0, err
+			}
+		case nodeArray:
+			if con, err = next.intoAry(); err != nil {
+				return nil, 0, err
+			}
+		default:
+			// Tokens below a scalar or null are left as they are.
+			return tokens, i + 1, nil
+		}
+	}
+
+	return tokens, len(tokens), nil
+}
+
+// globMatch reports whether the pointer tokens match the pattern. With
+// prefix, it also reports whether they are an ancestor of a pointer the
+// pattern matches.
+func globMatch(pattern, tokens []string, prefix bool) bool {
+	if len(tokens) == 0 {
+		if prefix {
+			return true
+		}
+
+		for _, tok := range pattern {
+			if tok != "**" {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if len(pattern) == 0 {
+		return false
+	}
+
+	if pattern[0] == "**" {
+		return globMatch(pattern[1:], tokens, prefix) || globMatch(pattern, tokens[1:], prefix)
+	}
+
+	if !tokenMatch(pattern[0], tokens[0]) {
+		return false
+	}
+
+	return globMatch(pattern[1:], tokens[1:], prefix)
+}
+
+// tokenMatch matches a reference token against a pattern in which "*"
+// matches any run of characters.
+func tokenMatch(pattern, tok string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == tok
+	}
+
+	if !strings.HasPrefix(tok, parts[0]) {
+		return false
+	}
+	tok = tok[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(tok, part)
+		if i < 0 {
+			return false
+		}
+		tok = tok[i+len(part):]
+	}
+
+	return strings.HasSuffix(tok, parts[len(parts)-1])
+}
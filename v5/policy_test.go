@@ -0,0 +1,249 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Name: "immutable id", Effect: PolicyDeny, Path: "/id"},
+			{Effect: PolicyDeny, Path: "/billing/**"},
+			{Effect: PolicyAllow, Ops: []string{"replace"}, Path: "/profile/**"},
+			{Effect: PolicyAllow, Ops: []string{"add", "remove"}, Path: "/tags/*"},
+			{Effect: PolicyAllow, Path: "/settings/feature-*"},
+			{Effect: PolicyAllow, Ops: []string{"test"}, Path: "/**"},
+		},
+		Default: PolicyDeny,
+	}
+
+	doc := `{"id": 1, "billing": {"plan": "free"}, "profile": {"name": "a", "address": {"city": "x"}}, "tags": ["a"], "settings": {}}`
+
+	cases := []struct {
+		patch  string
+		result string
+		rule   string
+	}{
+		{
+			`[{"op": "replace", "path": "/profile/address/city", "value": "y"}]`,
+			`{"id": 1, "billing": {"plan": "free"}, "profile": {"name": "a", "address": {"city": "y"}}, "tags": ["a"], "settings": {}}`,
+			"",
+		},
+		{
+			`[{"op": "test", "path": "/profile/name", "value": "a"}, {"op": "add", "path": "/tags/-", "value": "b"}, {"op": "add", "path": "/settings/feature-x", "value": true}]`,
+			`{"id": 1, "billing": {"plan": "free"}, "profile": {"name": "a", "address": {"city": "x"}}, "tags": ["a", "b"], "settings": {"feature-x": true}}`,
+			"",
+		},
+		{`[{"op": "replace", "path": "/id", "value": 2}]`, "", "immutable id"},
+		{`[{"op": "replace", "path": "/billing/plan", "value": "pro"}]`, "", "deny * /billing/**"},
+		{`[{"op": "remove", "path": "/billing"}]`, "", "deny * /billing/**"},
+		{`[{"op": "replace", "path": "", "value": {}}]`, "", "immutable id"},
+		{`[{"op": "add", "path": "/profile/nick", "value": "b"}]`, "", "default"},
+		{`[{"op": "copy", "from": "/billing/plan", "path": "/profile/name"}]`, "", "deny * /billing/**"},
+		{`[{"op": "move", "from": "/profile/name", "path": "/id"}]`, "", "immutable id"},
+		{`[{"op": "add", "path": "/settings/other", "value": 1}]`, "", "default"},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			options := NewApplyOptions()
+			options.Policy = policy
+
+			out, err := p.ApplyWithOptions([]byte(doc), options)
+
+			if c.rule == "" {
+				if err != nil {
+					t.Fatalf("unable to apply patch: %s", err)
+				}
+				if !compareJSON(string(out), c.result) {
+					t.Errorf("expected %s, got %s", c.result, out)
+				}
+				return
+			}
+
+			var pe *PolicyError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected a PolicyError, got %v", err)
+			}
+			if pe.Rule() != c.rule {
+				t.Errorf("expected rule %q, got %q", c.rule, pe.Rule())
+			}
+		})
+	}
+}
+
+func TestPolicyEnsurePathExistsOnAdd(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Name: "no meta", Effect: PolicyDeny, Ops: []string{"add"}, Path: "/meta"},
+		},
+	}
+
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/meta/owner/name", "value": "a"}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	options := NewApplyOptions()
+	options.Policy = policy
+	options.EnsurePathExistsOnAdd = true
+
+	_, err = p.ApplyWithOptions([]byte(`{}`), options)
+
+	var pe *PolicyError
+	if !errors.As(err, &pe) || pe.Rule() != "no meta" || pe.Path() != "/meta" {
+		t.Fatalf("expected the implicit /meta to be rejected, got %v", err)
+	}
+
+	// Once /meta exists, adding below it is allowed.
+	out, err := p.ApplyWithOptions([]byte(`{"meta": {}}`), options)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"meta": {"owner": {"name": "a"}}}`) {
+		t.Errorf("unexpected result %s", out)
+	}
+}
+
+func TestPolicyWildcards(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "replace", "path": "/users/*/role", "value": "admin"}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	options := NewApplyOptions()
+	options.SupportWildcards = true
+	options.Policy = &Policy{
+		Rules: []PolicyRule{
+			{Effect: PolicyDeny, Path: "/users/0/**"},
+		},
+	}
+
+	doc := []byte(`{"users": [{"role": "owner"}, {"role": "user"}]}`)
+
+	_, err = p.ApplyWithOptions(doc, options)
+
+	var pe *PolicyError
+	if !errors.As(err, &pe) || pe.Path() != "/users/0/role" {
+		t.Fatalf("expected /users/0/role to be rejected, got %v", err)
+	}
+
+	options.Policy.Rules[0].Path = "/owners"
+
+	out, err := p.ApplyWithOptions(doc, options)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"users": [{"role": "admin"}, {"role": "admin"}]}`) {
+		t.Errorf("unexpected result %s", out)
+	}
+}
+
+func TestPolicyArrayIndices(t *testing.T) {
+	options := NewApplyOptions()
+	options.SupportNegativeIndices = true
+	options.Policy = &Policy{
+		Rules: []PolicyRule{
+			{Name: "first admin", Effect: PolicyDeny, Path: "/admins/0"},
+		},
+	}
+
+	doc := []byte(`{"admins": ["root", "alice"]}`)
+
+	cases := []struct {
+		patch  string
+		result string
+		err    error
+	}{
+		{`[{"op": "replace", "path": "/admins/-2", "value": "eve"}]`, "", &PolicyError{}},
+		{`[{"op": "remove", "path": "/admins/00"}]`, "", &PolicyError{}},
+		{`[{"op": "remove", "path": "/admins/+0"}]`, "", &PolicyError{}},
+		{`[{"op": "add", "path": "/admins/-3", "value": "eve"}]`, "", &PolicyError{}},
+		{`[{"op": "move", "from": "/admins/-2", "path": "/owner"}]`, "", &PolicyError{}},
+		{`[{"op": "remove", "path": "/admins/-3"}]`, "", ErrInvalidIndex},
+		{`[{"op": "remove", "path": "/admins/-/x"}]`, "", ErrInvalidIndex},
+		{`[{"op": "replace", "path": "/admins/-1", "value": "bob"}]`, `{"admins": ["root", "bob"]}`, nil},
+		{`[{"op": "add", "path": "/admins/-", "value": "bob"}]`, `{"admins": ["root", "alice", "bob"]}`, nil},
+		{`[{"op": "add", "path": "/admins/-1", "value": "bob"}]`, `{"admins": ["root", "alice", "bob"]}`, nil},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			out, err := p.ApplyWithOptions(doc, options)
+
+			immutable, ierr := NewImmutableDocument(doc, options)
+			if ierr != nil {
+				t.Fatalf("unable to create document: %s", ierr)
+			}
+			_, ierr = immutable.Apply(p)
+
+			switch e := c.err.(type) {
+			case nil:
+				if err != nil || ierr != nil {
+					t.Fatalf("unable to apply patch: %v, %v", err, ierr)
+				}
+				if !compareJSON(string(out), c.result) {
+					t.Errorf("expected %s, got %s", c.result, out)
+				}
+			case *PolicyError:
+				if !errors.As(err, &e) || e.Rule() != "first admin" {
+					t.Errorf("expected the first admin rule to reject the patch, got %v", err)
+				}
+				if !errors.As(ierr, &e) || e.Rule() != "first admin" {
+					t.Errorf("expected the first admin rule to reject the patch on an ImmutableDocument, got %v", ierr)
+				}
+			default:
+				if !errors.Is(err, c.err) || !errors.Is(ierr, c.err) {
+					t.Errorf("expected %v, got %v and %v", c.err, err, ierr)
+				}
+			}
+		})
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		prefix  bool
+		match   bool
+	}{
+		{"/a/*", "/a/b", false, true},
+		{"/a/*", "/a", false, false},
+		{"/a/*", "/a", true, true},
+		{"/a/**", "/a", false, true},
+		{"/a/**/c", "/a/b/x/c", false, true},
+		{"/a/**/c", "/a/b/x/d", false, false},
+		{"/a/b*d", "/a/bcd", false, true},
+		{"/a/b*d", "/a/bcde", false, false},
+		{"/a~1b", "/a~1b", false, true},
+		{"/a", "", true, true},
+		{"/a", "", false, false},
+		{"/a", "/a/b", true, false},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			pattern, _ := splitPointer(c.pattern)
+			tokens, _ := splitPointer(c.path)
+
+			if got := globMatch(pattern, tokens, c.prefix); got != c.match {
+				t.Errorf("globMatch(%q, %q, %t) = %t", c.pattern, c.path, c.prefix, got)
+			}
+		})
+	}
+}
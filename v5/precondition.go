@@ -0,0 +1,90 @@
+package jsonpatch
+
+import (
+	"fmt"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// WithPreconditions returns the patch with a "test" operation before each
+// operation that changes the document, asserting the value the operation
+// finds at "path", and at "from" for "move" and "copy", when applied to doc.
+// Applying the returned patch fails with ErrTestFailed when the document is
+// no longer doc.
+//
+// A new object member is guarded by a test for null, which fails when the
+// member was added in the meantime, unless with a null value. No test is
+// added for insertions into arrays at positions past their end, such as
+// appended elements.
+//
+// The patch must apply to doc.
+func (p Patch) WithPreconditions(doc []byte) (Patch, error) {
+	options := NewApplyOptions()
+
+	pd, err := newContainer(doc, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var accumulatedCopySize int64
+
+	out := make(Patch, 0, 2*len(p))
+
+	for i, op := range p {
+		switch op.Kind() {
+		case "add", "remove", "replace", "move", "copy":
+			var paths []string
+			if from, err := op.From(); err == nil {
+				paths = append(paths, from)
+			}
+			if path, err := op.Path(); err == nil && (len(paths) == 0 || paths[0] != path) {
+				paths = append(paths, path)
+			}
+
+			for _, path := range paths {
+				test, ok, err := precondition(&pd, path, options)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					out = append(out, test)
+				}
+			}
+		}
+
+		out = append(out, op)
+
+		if err := p.applyOperation(&pd, op, &accumulatedCopySize, options); err != nil {
+			return nil, fmt.Errorf("operation %d does not apply: %w", i, err)
+		}
+	}
+
+	return out, nil
+}
+
+// precondition returns a "test" operation asserting the current value at
+// path, or asserting null for a missing member of an object.
+func precondition(doc *container, path string, options *ApplyOptions) (Operation, bool, error) {
+	data := rawJSONNull
+
+	node, found := valueAt(doc, path, options)
+	if found {
+		var err error
+		if data, err = json.Marshal(node); err != nil {
+			return nil, false, err
+		}
+	} else {
+		// A missing member tests equal to null, while an index past the end
+		// of an array fails any test.
+		parent, _ := findObject(doc, path, options)
+		if _, ok := parent.(*partialDoc); !ok {
+			return nil, false, nil
+		}
+	}
+
+	return Operation{
+		"op":    rawString("test"),
+		"path":  rawString(path),
+		"value": newRawMessage(data),
+	}, true, nil
+}
@@ -0,0 +1,111 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+func TestWithPreconditions(t *testing.T) {
+	cases := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{
+			`{"a": 1, "b": {"c": [1, 2]}}`,
+			`[{"op": "replace", "path": "/a", "value": 2}, {"op": "remove", "path": "/b/c/0"}]`,
+			`[{"op": "test", "path": "/a", "value": 1}, {"op": "replace", "path": "/a", "value": 2},
+			  {"op": "test", "path": "/b/c/0", "value": 1}, {"op": "remove", "path": "/b/c/0"}]`,
+		},
+		{
+			`{"a": 1, "list": ["x"]}`,
+			`[{"op": "add", "path": "/b", "value": 2}, {"op": "add", "path": "/list/-", "value": "y"}, {"op": "add", "path": "/list/0", "value": "w"}]`,
+			`[{"op": "test", "path": "/b", "value": null}, {"op": "add", "path": "/b", "value": 2}, {"op": "add", "path": "/list/-", "value": "y"},
+			  {"op": "test", "path": "/list/0", "value": "x"}, {"op": "add", "path": "/list/0", "value": "w"}]`,
+		},
+		{
+			`{"a": {"x": null}, "b": 1}`,
+			`[{"op": "move", "from": "/a", "path": "/b"}, {"op": "copy", "from": "/b", "path": "/c"}]`,
+			`[{"op": "test", "path": "/a", "value": {"x": null}}, {"op": "test", "path": "/b", "value": 1}, {"op": "move", "from": "/a", "path": "/b"},
+			  {"op": "test", "path": "/b", "value": {"x": null}}, {"op": "test", "path": "/c", "value": null}, {"op": "copy", "from": "/b", "path": "/c"}]`,
+		},
+		{
+			`{"a": 1}`,
+			`[{"op": "test", "path": "/a", "value": 1}, {"op": "replace", "path": "", "value": {"b": 2}}]`,
+			`[{"op": "test", "path": "/a", "value": 1}, {"op": "test", "path": "", "value": {"a": 1}}, {"op": "replace", "path": "", "value": {"b": 2}}]`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			p, err := DecodePatch([]byte(c.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			guarded, err := p.WithPreconditions([]byte(c.doc))
+			if err != nil {
+				t.Fatalf("unable to add preconditions: %s", err)
+			}
+
+			data, err := json.Marshal(guarded)
+			if err != nil {
+				t.Fatalf("unable to encode patch: %s", err)
+			}
+
+			if !compareJSON(string(data), c.expected) {
+				t.Errorf("expected %s, got %s", reformatJSON(c.expected), data)
+			}
+
+			want, err := p.Apply([]byte(c.doc))
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			got, err := guarded.Apply([]byte(c.doc))
+			if err != nil {
+				t.Fatalf("unable to apply guarded patch: %s", err)
+			}
+
+			if !compareJSON(string(got), string(want)) {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestWithPreconditionsDrift(t *testing.T) {
+	p, err := CreatePatchWithOptions(
+		[]byte(`{"name": "a", "count": 1}`),
+		[]byte(`{"name": "b", "count": 1}`),
+		&DiffOptions{Preconditions: true},
+	)
+	if err != nil {
+		t.Fatalf("unable to create patch: %s", err)
+	}
+
+	if _, err := p.Apply([]byte(`{"name": "a", "count": 2}`)); err != nil {
+		t.Errorf("unexpected error for unrelated changes: %s", err)
+	}
+
+	if _, err := p.Apply([]byte(`{"name": "c", "count": 1}`)); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected ErrTestFailed, got %v", err)
+	}
+
+	// A member added in the meantime is not overwritten.
+	added, err := CreatePatchWithOptions([]byte(`{}`), []byte(`{"name": "a"}`), &DiffOptions{Preconditions: true})
+	if err != nil {
+		t.Fatalf("unable to create patch: %s", err)
+	}
+
+	if _, err := added.Apply([]byte(`{"name": "b"}`)); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected ErrTestFailed for a concurrently added member, got %v", err)
+	}
+
+	if _, err := (Patch{{"op": rawString("remove"), "path": rawString("/x")}}).WithPreconditions([]byte(`{}`)); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+}
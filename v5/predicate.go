@@ -0,0 +1,415 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// The predicate operations of the JSON Patch predicates draft
+// (draft-snell-json-test), enabled with ApplyOptions.SupportPredicates. Like
+// "test", a predicate that does not hold fails the patch with ErrTestFailed.
+var predicates = map[string]bool{
+	"contains":  true,
+	"defined":   true,
+	"undefined": true,
+	"starts":    true,
+	"ends":      true,
+	"matches":   true,
+	"type":      true,
+	"less":      true,
+	"more":      true,
+	"in":        true,
+	"and":       true,
+	"or":        true,
+	"not":       true,
+}
+
+var predicateTypes = map[string]bool{
+	"number":    true,
+	"string":    true,
+	"boolean":   true,
+	"object":    true,
+	"array":     true,
+	"null":      true,
+	"undefined": true,
+	"date":      true,
+	"date-time": true,
+	"time":      true,
+}
+
+func isPredicate(kind string) bool {
+	return predicates[kind]
+}
+
+func isCompound(kind string) bool {
+	return kind == "and" || kind == "or" || kind == "not"
+}
+
+// validatePredicate checks the members of a predicate operation. Paths of
+// predicates nested in "and", "or" and "not" are relative to the path of the
+// enclosing predicate, and compound predicates may leave out their path.
+func validatePredicate(op Operation, nested bool) error {
+	kind := op.Kind()
+
+	if _, err := op.Path(); err != nil && !(nested || isCompound(kind)) {
+		return fmt.Errorf("failed to decode 'path': %w", err)
+	}
+
+	if raw, ok := op["ignore_case"]; ok {
+		var b bool
+		if raw == nil || unmarshal(*raw, &b) != nil {
+			return fmt.Errorf("'ignore_case' must be a boolean: %w", ErrInvalid)
+		}
+	}
+
+	switch kind {
+	case "contains", "starts", "ends", "matches", "type":
+		var s string
+		if err := predicateValue(op, &s); err != nil {
+			return err
+		}
+
+		if kind == "matches" {
+			if _, err := regexp.Compile(s); err != nil {
+				return fmt.Errorf("failed to compile 'value': %w", err)
+			}
+		}
+
+		if kind == "type" && !predicateTypes[s] {
+			return fmt.Errorf("unknown type %q: %w", s, ErrInvalid)
+		}
+	case "less", "more":
+		var f float64
+		if err := predicateValue(op, &f); err != nil {
+			return err
+		}
+	case "in":
+		var a []*lazyNode
+		if err := predicateValue(op, &a); err != nil {
+			return err
+		}
+	case "and", "or", "not":
+		children, err := op.nestedPredicates()
+		if err != nil {
+			return err
+		}
+
+		for _, n := range children {
+			if !isPredicate(n.Kind()) {
+				return fmt.Errorf("unsupported predicate %q: %w", n.Kind(), ErrInvalid)
+			}
+
+			if err := validatePredicate(n, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func predicateValue(op Operation, into interface{}) error {
+	raw, ok := op["value"]
+	if !ok || raw == nil {
+		return fmt.Errorf("operation, missing value field: %w", ErrMissing)
+	}
+
+	if err := unmarshal(*raw, into); err != nil {
+		return fmt.Errorf("failed to decode 'value': %w", err)
+	}
+
+	return nil
+}
+
+func (o Operation) nestedPredicates() ([]Operation, error) {
+	raw, ok := o["apply"]
+	if !ok || raw == nil {
+		return nil, fmt.Errorf("operation, missing apply field: %w", ErrMissing)
+	}
+
+	var nested []Operation
+	if err := unmarshal(*raw, &nested); err != nil {
+		return nil, fmt.Errorf("failed to decode 'apply': %w", err)
+	}
+
+	return nested, nil
+}
+
+func (o Operation) ignoreCase() bool {
+	var b bool
+	if raw, ok := o["ignore_case"]; ok && raw != nil {
+		unmarshal(*raw, &b)
+	}
+	return b
+}
+
+func (p Patch) predicate(doc *container, op Operation, options *ApplyOptions) error {
+	path := ""
+	if op["path"] != nil || !isCompound(op.Kind()) {
+		var err error
+		if path, err = op.Path(); err != nil {
+			return fmt.Errorf("%s operation failed to decode path: %w", op.Kind(), err)
+		}
+	}
+
+	ok, err := evalPredicate(doc, op, path, options)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%s predicate on %s failed: %w", op.Kind(), path, ErrTestFailed)
+	}
+
+	return nil
+}
+
+func evalPredicate(doc *container, op Operation, path string, options *ApplyOptions) (bool, error) {
+	kind := op.Kind()
+
+	if isCompound(kind) {
+		nested, err := op.nestedPredicates()
+		if err != nil {
+			return false, err
+		}
+
+		matched := 0
+		for _, n := range nested {
+			sub := ""
+			if n["path"] != nil {
+				sub, _ = n.Path()
+			}
+
+			ok, err := evalPredicate(doc, n, path+sub, options)
+			if err != nil {
+				return false, err
+			}
+
+			if ok {
+				matched++
+			}
+		}
+
+		switch kind {
+		case "and":
+			return matched == len(nested), nil
+		case "or":
+			return matched > 0, nil
+		default:
+			return matched == 0, nil
+		}
+	}
+
+	val, found := valueAt(doc, path, options)
+
+	switch kind {
+	case "defined":
+		return found, nil
+	case "undefined":
+		return !found, nil
+	case "type":
+		var want string
+		if err := predicateValue(op, &want); err != nil {
+			return false, err
+		}
+		return hasPredicateType(val, found, want), nil
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	target, err := decodeNode(val)
+	if err != nil {
+		return false, err
+	}
+
+	fold := op.ignoreCase()
+
+	switch kind {
+	case "contains", "starts", "ends", "matches":
+		s, ok := target.(string)
+		if !ok {
+			return false, nil
+		}
+
+		var want string
+		if err := predicateValue(op, &want); err != nil {
+			return false, err
+		}
+
+		if fold && kind != "matches" {
+			s, want = strings.ToLower(s), strings.ToLower(want)
+		}
+
+		switch kind {
+		case "contains":
+			return strings.Contains(s, want), nil
+		case "starts":
+			return strings.HasPrefix(s, want), nil
+		case "ends":
+			return strings.HasSuffix(s, want), nil
+		}
+
+		if fold {
+			want = "(?i)" + want
+		}
+
+		re, err := regexp.Compile(want)
+		if err != nil {
+			return false, err
+		}
+
+		return re.MatchString(s), nil
+	case "less", "more":
+		num, ok := target.(json.Number)
+		if !ok {
+			return false, nil
+		}
+
+		n, err := num.Float64()
+		if err != nil {
+			return false, nil
+		}
+
+		var want float64
+		if err := predicateValue(op, &want); err != nil {
+			return false, err
+		}
+
+		if kind == "less" {
+			return n < want, nil
+		}
+		return n > want, nil
+	case "in":
+		var candidates []*lazyNode
+		if err := predicateValue(op, &candidates); err != nil {
+			return false, err
+		}
+
+		for _, c := range candidates {
+			if fold {
+				cv, err := decodeNode(c)
+				if err != nil {
+					return false, err
+				}
+
+				a, aok := target.(string)
+				b, bok := cv.(string)
+				if aok && bok && strings.EqualFold(a, b) {
+					return true, nil
+				}
+			}
+
+			if nodeEqual(val, c) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	return false, fmt.Errorf("Unexpected kind: %s", kind)
+}
+
+// valueAt returns the value at path, and whether it exists.
+func valueAt(doc *container, path string, options *ApplyOptions) (*lazyNode, bool) {
+	if path == "" {
+		var self lazyNode
+
+		switch sv := (*doc).(type) {
+		case *partialDoc:
+			self.doc = sv
+			self.which = eDoc
+		case *partialArray:
+			self.ary = sv
+			self.which = eAry
+		}
+
+		return &self, true
+	}
+
+	con, key := findObject(doc, path, options)
+	if con == nil {
+		return nil, false
+	}
+
+	val, err := con.get(key, options)
+	if err != nil {
+		return nil, false
+	}
+
+	if val == nil {
+		// Null values are decoded as nil nodes.
+		return newLazyNode(newRawMessage(rawJSONNull)), true
+	}
+
+	return val, true
+}
+
+func decodeNode(n *lazyNode) (interface{}, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	buf, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func hasPredicateType(val *lazyNode, found bool, want string) bool {
+	if !found {
+		return want == "undefined"
+	}
+
+	switch nodeType(val) {
+	case nodeObject:
+		return want == "object"
+	case nodeArray:
+		return want == "array"
+	}
+
+	if val.isNull() {
+		return want == "null"
+	}
+
+	v, err := decodeNode(val)
+	if err != nil {
+		return false
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		return want == "number"
+	case bool:
+		return want == "boolean"
+	case string:
+		switch want {
+		case "string":
+			return true
+		case "date":
+			_, err := time.Parse("2006-01-02", v)
+			return err == nil
+		case "date-time":
+			_, err := time.Parse(time.RFC3339Nano, v)
+			return err == nil
+		case "time":
+			_, err := time.Parse("15:04:05.999999999Z07:00", v)
+			return err == nil
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,170 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func applyPredicatePatch(doc, patch string) (string, error) {
+	options := NewApplyOptions()
+	options.SupportPredicates = true
+
+	p, err := DecodePatchWithOptions([]byte(patch), options)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := p.ApplyWithOptions([]byte(doc), options)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+var PredicateCases = []struct {
+	doc, patch string
+	holds      bool
+}{
+	{`{"s": "Hello World"}`, `[{"op": "contains", "path": "/s", "value": "o W"}]`, true},
+	{`{"s": "Hello World"}`, `[{"op": "contains", "path": "/s", "value": "o w"}]`, false},
+	{`{"s": "Hello World"}`, `[{"op": "contains", "path": "/s", "value": "o w", "ignore_case": true}]`, true},
+	{`{"s": "Hello World"}`, `[{"op": "starts", "path": "/s", "value": "Hello"}]`, true},
+	{`{"s": "Hello World"}`, `[{"op": "starts", "path": "/s", "value": "hello", "ignore_case": true}]`, true},
+	{`{"s": "Hello World"}`, `[{"op": "ends", "path": "/s", "value": "World"}]`, true},
+	{`{"s": "Hello World"}`, `[{"op": "ends", "path": "/s", "value": "Hello"}]`, false},
+	{`{"s": "Hello World"}`, `[{"op": "matches", "path": "/s", "value": "^H.*d$"}]`, true},
+	{`{"s": "Hello World"}`, `[{"op": "matches", "path": "/s", "value": "^h.*D$", "ignore_case": true}]`, true},
+	{`{"s": 1}`, `[{"op": "contains", "path": "/s", "value": "1"}]`, false},
+	{`{"a": null}`, `[{"op": "defined", "path": "/a"}]`, true},
+	{`{"a": null}`, `[{"op": "defined", "path": "/b"}]`, false},
+	{`{"a": null}`, `[{"op": "undefined", "path": "/b"}]`, true},
+	{`{"a": [1]}`, `[{"op": "undefined", "path": "/a/0"}]`, false},
+	{`{"a": 1.5}`, `[{"op": "type", "path": "/a", "value": "number"}]`, true},
+	{`{"a": [null]}`, `[{"op": "type", "path": "/a/0", "value": "null"}]`, true},
+	{`{"a": {}}`, `[{"op": "type", "path": "/a", "value": "object"}]`, true},
+	{`{"a": {}}`, `[{"op": "type", "path": "", "value": "object"}]`, true},
+	{`{"a": {}}`, `[{"op": "type", "path": "/b", "value": "undefined"}]`, true},
+	{`{"a": "2024-02-29"}`, `[{"op": "type", "path": "/a", "value": "date"}]`, true},
+	{`{"a": "2024-02-29T10:00:00Z"}`, `[{"op": "type", "path": "/a", "value": "date-time"}]`, true},
+	{`{"a": "2024-02-29"}`, `[{"op": "type", "path": "/a", "value": "date-time"}]`, false},
+	{`{"n": 5}`, `[{"op": "less", "path": "/n", "value": 6}]`, true},
+	{`{"n": 5}`, `[{"op": "less", "path": "/n", "value": 5}]`, false},
+	{`{"n": 5}`, `[{"op": "more", "path": "/n", "value": 4.5}]`, true},
+	{`{"status": "queued"}`, `[{"op": "in", "path": "/status", "value": ["pending", "queued"]}]`, true},
+	{`{"status": "QUEUED"}`, `[{"op": "in", "path": "/status", "value": ["pending", "queued"]}]`, false},
+	{`{"status": "QUEUED"}`, `[{"op": "in", "path": "/status", "value": ["pending", "queued"], "ignore_case": true}]`, true},
+	{`{"v": {"a": 1}}`, `[{"op": "in", "path": "/v", "value": [1, {"a": 1}]}]`, true},
+	{
+		`{"user": {"name": "Jane", "age": 30}}`,
+		`[{"op": "and", "path": "/user", "apply": [{"op": "starts", "path": "/name", "value": "J"}, {"op": "more", "path": "/age", "value": 18}]}]`,
+		true,
+	},
+	{
+		`{"user": {"name": "Jane", "age": 12}}`,
+		`[{"op": "and", "path": "/user", "apply": [{"op": "starts", "path": "/name", "value": "J"}, {"op": "more", "path": "/age", "value": 18}]}]`,
+		false,
+	},
+	{
+		`{"user": {"name": "Jane", "age": 12}}`,
+		`[{"op": "or", "apply": [{"op": "defined", "path": "/admin"}, {"op": "less", "path": "/user/age", "value": 18}]}]`,
+		true,
+	},
+	{
+		`{"user": {"name": "Jane"}}`,
+		`[{"op": "not", "path": "/user", "apply": [{"op": "defined", "path": "/age"}, {"op": "contains", "path": "/name", "value": "x"}]}]`,
+		true,
+	},
+	{
+		`{"user": {"name": "Jane"}}`,
+		`[{"op": "not", "path": "/user", "apply": [{"op": "defined", "path": "/name"}]}]`,
+		false,
+	},
+}
+
+func TestPredicates(t *testing.T) {
+	for i, c := range PredicateCases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			out, err := applyPredicatePatch(c.doc, c.patch)
+
+			if c.holds {
+				if err != nil {
+					t.Fatalf("expected predicate to hold: %s", err)
+				}
+
+				if !compareJSON(out, c.doc) {
+					t.Errorf("predicate changed the document: %s", out)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrTestFailed) {
+				t.Errorf("expected predicate to fail with ErrTestFailed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPredicateGuardsReplace(t *testing.T) {
+	patch := `[
+		{"op": "in", "path": "/status", "value": ["pending", "queued"]},
+		{"op": "replace", "path": "/status", "value": "running"}
+	]`
+
+	out, err := applyPredicatePatch(`{"status": "pending"}`, patch)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(out, `{"status": "running"}`) {
+		t.Errorf("unexpected document: %s", out)
+	}
+
+	if _, err := applyPredicatePatch(`{"status": "done"}`, patch); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected the guard to fail, got %v", err)
+	}
+}
+
+func TestPredicatesDisabled(t *testing.T) {
+	patch := []byte(`[{"op": "defined", "path": "/a"}]`)
+
+	if _, err := DecodePatch(patch); err == nil {
+		t.Error("expected predicates to be rejected by DecodePatch")
+	}
+
+	var p Patch
+	if err := unmarshal(patch, &p); err != nil {
+		t.Fatalf("unable to unmarshal patch: %s", err)
+	}
+
+	if _, err := p.Apply([]byte(`{"a": 1}`)); err == nil {
+		t.Error("expected predicates to be rejected without SupportPredicates")
+	}
+}
+
+func TestPredicateValidation(t *testing.T) {
+	cases := []string{
+		`[{"op": "contains", "path": "/a"}]`,
+		`[{"op": "contains", "path": "/a", "value": 1}]`,
+		`[{"op": "matches", "path": "/a", "value": "("}]`,
+		`[{"op": "type", "path": "/a", "value": "color"}]`,
+		`[{"op": "less", "path": "/a", "value": "1"}]`,
+		`[{"op": "in", "path": "/a", "value": 1}]`,
+		`[{"op": "defined"}]`,
+		`[{"op": "and", "path": "/a"}]`,
+		`[{"op": "and", "apply": [{"op": "add", "path": "/a", "value": 1}]}]`,
+		`[{"op": "starts", "path": "/a", "value": "x", "ignore_case": "yes"}]`,
+	}
+
+	options := NewApplyOptions()
+	options.SupportPredicates = true
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			if _, err := DecodePatchWithOptions([]byte(c), options); err == nil {
+				t.Errorf("expected %s to be rejected", c)
+			}
+		})
+	}
+}
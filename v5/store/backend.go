@@ -0,0 +1,148 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Backend persists the journal and the snapshots of a Store. Versions are
+// numbered from 0, and the patch of version n turns the document at version
+// n-1 into the document at version n.
+type Backend interface {
+	// Head returns the latest version of a patch or a snapshot, or -1 when
+	// the backend is empty.
+	Head() (int64, error)
+	// Append stores the patch of version. It returns ErrConflict if version
+	// was already appended.
+	Append(version int64, patch []byte) error
+	// Patches returns the patches of the versions after from, up to to.
+	Patches(from, to int64) ([][]byte, error)
+	// SaveSnapshot stores the document at version.
+	SaveSnapshot(version int64, doc []byte) error
+	// Snapshot returns the latest snapshot at or before version, and its
+	// version. It returns ErrVersionNotFound when there is none.
+	Snapshot(version int64) (int64, []byte, error)
+	// Compact removes the patches of the versions up to version, and the
+	// snapshots before it.
+	Compact(version int64) error
+}
+
+// MemoryBackend is a Backend keeping the journal in memory.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	patches   map[int64][]byte
+	snapshots map[int64][]byte
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		patches:   map[int64][]byte{},
+		snapshots: map[int64][]byte{},
+	}
+}
+
+// Head implements Backend.
+func (m *MemoryBackend) Head() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	head := int64(-1)
+
+	for v := range m.patches {
+		if v > head {
+			head = v
+		}
+	}
+
+	for v := range m.snapshots {
+		if v > head {
+			head = v
+		}
+	}
+
+	return head, nil
+}
+
+// Append implements Backend.
+func (m *MemoryBackend) Append(version int64, patch []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.patches[version]; ok {
+		return fmt.Errorf("version %d: %w", version, ErrConflict)
+	}
+
+	m.patches[version] = append([]byte(nil), patch...)
+
+	return nil
+}
+
+// Patches implements Backend.
+func (m *MemoryBackend) Patches(from, to int64) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var patches [][]byte
+
+	for v := from + 1; v <= to; v++ {
+		patch, ok := m.patches[v]
+		if !ok {
+			return nil, fmt.Errorf("version %d: %w", v, ErrVersionNotFound)
+		}
+
+		patches = append(patches, patch)
+	}
+
+	return patches, nil
+}
+
+// SaveSnapshot implements Backend.
+func (m *MemoryBackend) SaveSnapshot(version int64, doc []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.snapshots[version] = append([]byte(nil), doc...)
+
+	return nil
+}
+
+// Snapshot implements Backend.
+func (m *MemoryBackend) Snapshot(version int64) (int64, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := int64(-1)
+
+	for v := range m.snapshots {
+		if v <= version && v > found {
+			found = v
+		}
+	}
+
+	if found < 0 {
+		return 0, nil, fmt.Errorf("snapshot at or before version %d: %w", version, ErrVersionNotFound)
+	}
+
+	return found, append([]byte(nil), m.snapshots[found]...), nil
+}
+
+// Compact implements Backend.
+func (m *MemoryBackend) Compact(version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for v := range m.patches {
+		if v <= version {
+			delete(m.patches, v)
+		}
+	}
+
+	for v := range m.snapshots {
+		if v < version {
+			delete(m.snapshots, v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileBackend is a Backend keeping the journal in a directory, with a file
+// per patch and per snapshot. Appends are atomic, so that several processes
+// may share a directory.
+type FileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a FileBackend keeping the journal in dir, which is
+// created if needed.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	f := &FileBackend{dir: dir}
+
+	for _, sub := range []string{f.patchesDir(), f.snapshotsDir()} {
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (f *FileBackend) patchesDir() string {
+	return filepath.Join(f.dir, "patches")
+}
+
+func (f *FileBackend) snapshotsDir() string {
+	return filepath.Join(f.dir, "snapshots")
+}
+
+func fileName(version int64) string {
+	return fmt.Sprintf("%020d.json", version)
+}
+
+// versions lists the versions stored in dir, in increasing order.
+func versions(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var vs []int64
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		v, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		vs = append(vs, v)
+	}
+
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+
+	return vs, nil
+}
+
+// Head implements Backend.
+func (f *FileBackend) Head() (int64, error) {
+	head := int64(-1)
+
+	for _, dir := range []string{f.patchesDir(), f.snapshotsDir()} {
+		vs, err := versions(dir)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(vs) > 0 && vs[len(vs)-1] > head {
+			head = vs[len(vs)-1]
+		}
+	}
+
+	return head, nil
+}
+
+// writeTemp writes data to a new temporary file in dir.
+func writeTemp(dir string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// Append implements Backend. The patch is written to a temporary file which
+// is then linked in place, failing if the version already exists.
+func (f *FileBackend) Append(version int64, patch []byte) error {
+	tmp, err := writeTemp(f.patchesDir(), patch)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, filepath.Join(f.patchesDir(), fileName(version))); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("version %d: %w", version, ErrConflict)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Patches implements Backend.
+func (f *FileBackend) Patches(from, to int64) ([][]byte, error) {
+	var patches [][]byte
+
+	for v := from + 1; v <= to; v++ {
+		data, err := os.ReadFile(filepath.Join(f.patchesDir(), fileName(v)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("version %d: %w", v, ErrVersionNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		patches = append(patches, data)
+	}
+
+	return patches, nil
+}
+
+// SaveSnapshot implements Backend.
+func (f *FileBackend) SaveSnapshot(version int64, doc []byte) error {
+	tmp, err := writeTemp(f.snapshotsDir(), doc)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(f.snapshotsDir(), fileName(version))); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Snapshot implements Backend.
+func (f *FileBackend) Snapshot(version int64) (int64, []byte, error) {
+	vs, err := versions(f.snapshotsDir())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	i := sort.Search(len(vs), func(i int) bool { return vs[i] > version })
+	if i == 0 {
+		return 0, nil, fmt.Errorf("snapshot at or before version %d: %w", version, ErrVersionNotFound)
+	}
+
+	data, err := os.ReadFile(filepath.Join(f.snapshotsDir(), fileName(vs[i-1])))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return vs[i-1], data, nil
+}
+
+// Compact implements Backend.
+func (f *FileBackend) Compact(version int64) error {
+	patches, err := versions(f.patchesDir())
+	if err != nil {
+		return err
+	}
+
+	for _, v := range patches {
+		if v <= version {
+			if err := os.Remove(filepath.Join(f.patchesDir(), fileName(v))); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	snapshots, err := versions(f.snapshotsDir())
+	if err != nil {
+		return err
+	}
+
+	for _, v := range snapshots {
+		if v < version {
+			if err := os.Remove(filepath.Join(f.snapshotsDir(), fileName(v))); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package store keeps the history of a JSON document as a journal of RFC 6902
+// patches, with periodic snapshots of the document.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+var (
+	// ErrVersionNotFound is returned for versions that do not exist yet.
+	ErrVersionNotFound = errors.New("version not found")
+	// ErrCompacted is returned for versions that were removed by compaction.
+	ErrCompacted = errors.New("version was compacted")
+	// ErrConflict is returned by a Backend when a version was already
+	// appended.
+	ErrConflict = errors.New("version already exists")
+)
+
+// VersionConflictError is an error type returned when a patch is applied to
+// a version that is not the latest one.
+type VersionConflictError struct {
+	expected int64
+	actual   int64
+}
+
+// NewVersionConflictError returns a VersionConflictError.
+func NewVersionConflictError(expected, actual int64) *VersionConflictError {
+	return &VersionConflictError{expected: expected, actual: actual}
+}
+
+// Error implements the error interface.
+func (v *VersionConflictError) Error() string {
+	return fmt.Sprintf("Unable to apply the patch to version %d, the latest version is %d", v.expected, v.actual)
+}
+
+// Actual returns the latest version.
+func (v *VersionConflictError) Actual() int64 {
+	return v.actual
+}
+
+// Unwrap returns ErrConflict.
+func (v *VersionConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// Options specifies options for calls to Open.
+// Use NewOptions to obtain default values for Options.
+type Options struct {
+	// SnapshotInterval is the number of versions between snapshots. A
+	// value of 0 disables periodic snapshots.
+	// Default to 100.
+	SnapshotInterval int64
+	// ApplyOptions are used to apply patches. The journal holds the changes
+	// the patches made, as plain RFC 6902 patches, so that replaying it
+	// gives the same documents whatever the options.
+	// Default to jsonpatch.NewApplyOptions().
+	ApplyOptions *jsonpatch.ApplyOptions
+	// ErrorLog is given the errors that do not fail the call they happen
+	// in, such as a failure to save a periodic snapshot after the patch was
+	// journaled. The version stays available by replaying the journal.
+	// Default to nil, which logs them with the standard logger of the log
+	// package.
+	ErrorLog func(err error)
+}
+
+// NewOptions creates a default set of options for calls to Open.
+func NewOptions() *Options {
+	return &Options{
+		SnapshotInterval: 100,
+		ApplyOptions:     jsonpatch.NewApplyOptions(),
+	}
+}
+
+// Store is a versioned JSON document. Version 0 is the initial document, and
+// each patch applied creates the next version. A Store is safe for
+// concurrent use, and detects patches appended to its Backend by other
+// Stores.
+type Store struct {
+	backend Backend
+	options *Options
+	replay  *jsonpatch.ApplyOptions
+
+	mu   sync.Mutex
+	head int64
+	doc  []byte
+}
+
+// Open opens the document kept by backend. When backend is empty, initial
+// is stored as version 0.
+func Open(backend Backend, initial []byte, options *Options) (*Store, error) {
+	if options == nil {
+		options = NewOptions()
+	}
+
+	applyOptions := options.ApplyOptions
+	if applyOptions == nil {
+		applyOptions = jsonpatch.NewApplyOptions()
+	}
+
+	replay := jsonpatch.NewApplyOptions()
+	replay.EscapeHTML = applyOptions.EscapeHTML
+
+	s := &Store{
+		backend: backend,
+		options: &Options{SnapshotInterval: options.SnapshotInterval, ApplyOptions: applyOptions, ErrorLog: options.ErrorLog},
+		replay:  replay,
+	}
+
+	head, err := backend.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	if head < 0 {
+		if !json.Valid(initial) {
+			return nil, jsonpatch.ErrBadJSONDoc
+		}
+
+		if err := backend.SaveSnapshot(0, initial); err != nil {
+			return nil, err
+		}
+		head = 0
+	}
+
+	if s.doc, err = s.load(head); err != nil {
+		return nil, err
+	}
+	s.head = head
+
+	return s, nil
+}
+
+// Version returns the latest version.
+func (s *Store) Version() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.refresh(); err != nil {
+		return 0, err
+	}
+
+	return s.head, nil
+}
+
+// Get returns the document at version.
+func (s *Store) Get(version int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version > s.head {
+		if err := s.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	if version == s.head {
+		return append([]byte(nil), s.doc...), nil
+	}
+
+	if version < 0 || version > s.head {
+		return nil, fmt.Errorf("version %d: %w", version, ErrVersionNotFound)
+	}
+
+	return s.load(version)
+}
+
+// Apply applies patch to the latest version, which must be expectedVersion,
+// and returns the new version and its document. A *VersionConflictError is
+// returned when the latest version is another one. Once the change is
+// journaled, Apply succeeds: failing to save a snapshot is reported to
+// Options.ErrorLog instead.
+func (s *Store) Apply(patch jsonpatch.Patch, expectedVersion int64) (int64, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expectedVersion != s.head {
+		if err := s.refresh(); err != nil {
+			return 0, nil, err
+		}
+
+		if expectedVersion != s.head {
+			return 0, nil, NewVersionConflictError(expectedVersion, s.head)
+		}
+	}
+
+	doc, err := patch.ApplyWithOptions(s.doc, s.options.ApplyOptions)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// The patch itself is not journaled: with ApplyOptions.ContinueOnError
+	// some of its operations may have been skipped, and its wildcards and
+	// guards depend on the options. The change it made is.
+	change, err := jsonpatch.CreatePatch(s.doc, doc)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version := s.head + 1
+
+	if err := s.backend.Append(version, data); err != nil {
+		if !errors.Is(err, ErrConflict) {
+			return 0, nil, err
+		}
+
+		if err := s.refresh(); err != nil {
+			return 0, nil, err
+		}
+
+		return 0, nil, NewVersionConflictError(expectedVersion, s.head)
+	}
+
+	s.head = version
+	s.doc = doc
+
+	if s.options.SnapshotInterval > 0 && version%s.options.SnapshotInterval == 0 {
+		if err := s.backend.SaveSnapshot(version, doc); err != nil {
+			s.logError(fmt.Errorf("snapshot of version %d: %w", version, err))
+		}
+	}
+
+	return version, append([]byte(nil), doc...), nil
+}
+
+// History returns a patch turning the document at version from into the
+// document at version to, composed from the patches in between. When they
+// cannot be composed, the patch is created by comparing both documents.
+func (s *Store) History(from, to int64) (jsonpatch.Patch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if to > s.head {
+		if err := s.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	if from < 0 || from > to || to > s.head {
+		return nil, fmt.Errorf("versions %d to %d: %w", from, to, ErrVersionNotFound)
+	}
+
+	if _, _, err := s.backend.Snapshot(from); err != nil {
+		return nil, s.compacted(from, err)
+	}
+
+	journal, err := s.backend.Patches(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	patches := make([]jsonpatch.Patch, len(journal))
+	for i, data := range journal {
+		if patches[i], err = jsonpatch.DecodePatchWithOptions(data, s.replay); err != nil {
+			return nil, err
+		}
+	}
+
+	if composed, err := jsonpatch.ComposePatches(patches...); err == nil {
+		return composed, nil
+	}
+
+	original, err := s.load(from)
+	if err != nil {
+		return nil, err
+	}
+
+	modified, err := s.load(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonpatch.CreatePatch(original, modified)
+}
+
+// Compact stores a snapshot of version, and removes the patches and
+// snapshots of the versions before it. Those versions are no longer
+// available afterwards.
+func (s *Store) Compact(version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version < 0 || version > s.head {
+		return fmt.Errorf("version %d: %w", version, ErrVersionNotFound)
+	}
+
+	doc := s.doc
+	if version != s.head {
+		var err error
+		if doc, err = s.load(version); err != nil {
+			return err
+		}
+	}
+
+	if err := s.backend.SaveSnapshot(version, doc); err != nil {
+		return err
+	}
+
+	return s.backend.Compact(version)
+}
+
+// logError passes err to Options.ErrorLog.
+func (s *Store) logError(err error) {
+	if s.options.ErrorLog != nil {
+		s.options.ErrorLog(err)
+	} else {
+		log.Printf("store: %s", err)
+	}
+}
+
+// refresh catches up with the versions appended by other Stores.
+func (s *Store) refresh() error {
+	head, err := s.backend.Head()
+	if err != nil {
+		return err
+	}
+
+	if head == s.head {
+		return nil
+	}
+
+	doc, err := s.load(head)
+	if err != nil {
+		return err
+	}
+
+	s.head, s.doc = head, doc
+
+	return nil
+}
+
+// load replays the journal from the latest snapshot before version.
+func (s *Store) load(version int64) ([]byte, error) {
+	base, doc, err := s.backend.Snapshot(version)
+	if err != nil {
+		return nil, s.compacted(version, err)
+	}
+
+	journal, err := s.backend.Patches(base, version)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, data := range journal {
+		patch, err := jsonpatch.DecodePatchWithOptions(data, s.replay)
+		if err != nil {
+			return nil, fmt.Errorf("version %d: %w", base+int64(i)+1, err)
+		}
+
+		if doc, err = patch.ApplyWithOptions(doc, s.replay); err != nil {
+			return nil, fmt.Errorf("version %d: %w", base+int64(i)+1, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// compacted reports a missing snapshot as ErrCompacted, since version 0 is
+// always stored as one.
+func (s *Store) compacted(version int64, err error) error {
+	if errors.Is(err, ErrVersionNotFound) {
+		return fmt.Errorf("version %d: %w", version, ErrCompacted)
+	}
+
+	return err
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+func decodePatch(t *testing.T, s string) jsonpatch.Patch {
+	t.Helper()
+
+	p, err := jsonpatch.DecodePatch([]byte(s))
+	if err != nil {
+		t.Fatalf("unable to decode patch %s: %s", s, err)
+	}
+
+	return p
+}
+
+func backends(t *testing.T) map[string]func() Backend {
+	dir := t.TempDir()
+	n := 0
+
+	return map[string]func() Backend{
+		"memory": func() Backend { return NewMemoryBackend() },
+		"file": func() Backend {
+			n++
+			b, err := NewFileBackend(fmt.Sprintf("%s/%d", dir, n))
+			if err != nil {
+				t.Fatalf("unable to create backend: %s", err)
+			}
+			return b
+		},
+	}
+}
+
+func TestStore(t *testing.T) {
+	for name, newBackend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			options := NewOptions()
+			options.SnapshotInterval = 2
+
+			s, err := Open(newBackend(), []byte(`{"n": 0, "list": []}`), options)
+			if err != nil {
+				t.Fatalf("unable to open store: %s", err)
+			}
+
+			for i := 1; i <= 5; i++ {
+				p := decodePatch(t, fmt.Sprintf(`[{"op": "replace", "path": "/n", "value": %d}, {"op": "add", "path": "/list/-", "value": %d}]`, i, i))
+
+				version, doc, err := s.Apply(p, int64(i-1))
+				if err != nil {
+					t.Fatalf("unable to apply patch %d: %s", i, err)
+				}
+
+				if version != int64(i) {
+					t.Errorf("expected version %d, got %d", i, version)
+				}
+
+				if i == 5 && !jsonpatch.Equal(doc, []byte(`{"n": 5, "list": [1, 2, 3, 4, 5]}`)) {
+					t.Errorf("unexpected document %s", doc)
+				}
+			}
+
+			for v, expected := range []string{
+				`{"n": 0, "list": []}`,
+				`{"n": 1, "list": [1]}`,
+				`{"n": 2, "list": [1, 2]}`,
+				`{"n": 3, "list": [1, 2, 3]}`,
+			} {
+				doc, err := s.Get(int64(v))
+				if err != nil {
+					t.Fatalf("unable to get version %d: %s", v, err)
+				}
+
+				if !jsonpatch.Equal(doc, []byte(expected)) {
+					t.Errorf("version %d: expected %s, got %s", v, expected, doc)
+				}
+			}
+
+			if _, err := s.Get(6); !errors.Is(err, ErrVersionNotFound) {
+				t.Errorf("expected ErrVersionNotFound, got %v", err)
+			}
+
+			_, _, err = s.Apply(decodePatch(t, `[{"op": "remove", "path": "/n"}]`), 3)
+
+			var conflict *VersionConflictError
+			if !errors.As(err, &conflict) || conflict.Actual() != 5 || !errors.Is(err, ErrConflict) {
+				t.Errorf("expected a version conflict, got %v", err)
+			}
+
+			history, err := s.History(1, 4)
+			if err != nil {
+				t.Fatalf("unable to get history: %s", err)
+			}
+
+			doc, err := history.Apply([]byte(`{"n": 1, "list": [1]}`))
+			if err != nil {
+				t.Fatalf("unable to apply history: %s", err)
+			}
+
+			if !jsonpatch.Equal(doc, []byte(`{"n": 4, "list": [1, 2, 3, 4]}`)) {
+				t.Errorf("unexpected document %s", doc)
+			}
+
+			if err := s.Compact(3); err != nil {
+				t.Fatalf("unable to compact: %s", err)
+			}
+
+			if _, err := s.Get(2); !errors.Is(err, ErrCompacted) {
+				t.Errorf("expected ErrCompacted, got %v", err)
+			}
+
+			if _, err := s.History(1, 5); !errors.Is(err, ErrCompacted) {
+				t.Errorf("expected ErrCompacted, got %v", err)
+			}
+
+			doc, err = s.Get(4)
+			if err != nil || !jsonpatch.Equal(doc, []byte(`{"n": 4, "list": [1, 2, 3, 4]}`)) {
+				t.Errorf("unexpected version 4 after compaction: %s, %v", doc, err)
+			}
+		})
+	}
+}
+
+func TestStoreFailedPatch(t *testing.T) {
+	s, err := Open(NewMemoryBackend(), []byte(`{"a": 1}`), nil)
+	if err != nil {
+		t.Fatalf("unable to open store: %s", err)
+	}
+
+	if _, _, err := s.Apply(decodePatch(t, `[{"op": "remove", "path": "/b"}]`), 0); !errors.Is(err, jsonpatch.ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+
+	if v, err := s.Version(); err != nil || v != 0 {
+		t.Errorf("expected version 0, got %d, %v", v, err)
+	}
+}
+
+func TestStoreSharedBackend(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create backend: %s", err)
+	}
+
+	a, err := Open(backend, []byte(`{"n": 0}`), nil)
+	if err != nil {
+		t.Fatalf("unable to open store: %s", err)
+	}
+
+	b, err := Open(backend, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to open store: %s", err)
+	}
+
+	if _, _, err := a.Apply(decodePatch(t, `[{"op": "replace", "path": "/n", "value": 1}]`), 0); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	// b has not seen version 1 yet.
+	_, _, err = b.Apply(decodePatch(t, `[{"op": "replace", "path": "/n", "value": 2}]`), 0)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	version, doc, err := b.Apply(decodePatch(t, `[{"op": "replace", "path": "/n", "value": 2}]`), 1)
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if version != 2 || !jsonpatch.Equal(doc, []byte(`{"n": 2}`)) {
+		t.Errorf("unexpected version %d %s", version, doc)
+	}
+
+	doc, err = a.Get(2)
+	if err != nil || !jsonpatch.Equal(doc, []byte(`{"n": 2}`)) {
+		t.Errorf("unexpected document %s, %v", doc, err)
+	}
+}
+
+func TestStoreReopenAfterPartialApply(t *testing.T) {
+	for name, newBackend := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			backend := newBackend()
+
+			options := NewOptions()
+			options.ApplyOptions.ContinueOnError = true
+			options.ApplyOptions.Policy = &jsonpatch.Policy{
+				Rules: []jsonpatch.PolicyRule{{Effect: jsonpatch.PolicyDeny, Path: "/id"}},
+			}
+			options.ApplyOptions.OnOperation = func(event *jsonpatch.OperationEvent) error {
+				if event.Path == "/veto" {
+					return errors.New("vetoed")
+				}
+				return nil
+			}
+
+			s, err := Open(backend, []byte(`{"id": 1}`), options)
+			if err != nil {
+				t.Fatalf("unable to open store: %s", err)
+			}
+
+			p := decodePatch(t, `[
+				{"op": "replace", "path": "/id", "value": 2},
+				{"op": "add", "path": "/veto", "value": true},
+				{"op": "add", "path": "/n", "value": 1}
+			]`)
+
+			_, doc, err := s.Apply(p, 0)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			expected := []byte(`{"id": 1, "n": 1}`)
+			if !jsonpatch.Equal(doc, expected) {
+				t.Fatalf("expected %s, got %s", expected, doc)
+			}
+
+			// The journal is replayed when the store is opened again.
+			reopened, err := Open(backend, nil, options)
+			if err != nil {
+				t.Fatalf("unable to open store: %s", err)
+			}
+
+			doc, err = reopened.Get(1)
+			if err != nil || !jsonpatch.Equal(doc, expected) {
+				t.Errorf("expected %s after reopening, got %s, %v", expected, doc, err)
+			}
+
+			history, err := reopened.History(0, 1)
+			if err != nil {
+				t.Fatalf("unable to get history: %s", err)
+			}
+
+			doc, err = history.Apply([]byte(`{"id": 1}`))
+			if err != nil || !jsonpatch.Equal(doc, expected) {
+				t.Errorf("expected %s from history, got %s, %v", expected, doc, err)
+			}
+		})
+	}
+}
+
+func TestStoreHistoryWildcards(t *testing.T) {
+	backend := NewMemoryBackend()
+
+	options := NewOptions()
+	options.ApplyOptions.SupportWildcards = true
+
+	s, err := Open(backend, []byte(`{"items": [{"s": "a"}, {"s": "b"}]}`), options)
+	if err != nil {
+		t.Fatalf("unable to open store: %s", err)
+	}
+
+	p, err := jsonpatch.DecodePatchWithOptions([]byte(`[{"op": "replace", "path": "/items/*/s", "value": "x"}]`), options.ApplyOptions)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, _, err := s.Apply(p, 0); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	p, err = jsonpatch.DecodePatchWithOptions([]byte(`[{"op": "replace", "path": "/items/*/s", "value": "y"}]`), options.ApplyOptions)
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	if _, _, err := s.Apply(p, 1); err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	history, err := s.History(0, 2)
+	if err != nil {
+		t.Fatalf("unable to get history: %s", err)
+	}
+
+	// The history applies without the options of the store.
+	doc, err := history.Apply([]byte(`{"items": [{"s": "a"}, {"s": "b"}]}`))
+	if err != nil {
+		t.Fatalf("unable to apply history %v: %s", history, err)
+	}
+
+	expected := []byte(`{"items": [{"s": "y"}, {"s": "y"}]}`)
+	if !jsonpatch.Equal(doc, expected) {
+		t.Errorf("expected %s, got %s", expected, doc)
+	}
+}
+
+// snapshotFailingBackend fails to save snapshots once fail is set.
+type snapshotFailingBackend struct {
+	*MemoryBackend
+	fail bool
+}
+
+var errSnapshot = errors.New("disk full")
+
+func (b *snapshotFailingBackend) SaveSnapshot(version int64, doc []byte) error {
+	if b.fail {
+		return errSnapshot
+	}
+	return b.MemoryBackend.SaveSnapshot(version, doc)
+}
+
+func TestStoreSnapshotFailure(t *testing.T) {
+	backend := &snapshotFailingBackend{MemoryBackend: NewMemoryBackend()}
+
+	var logged []error
+
+	options := NewOptions()
+	options.SnapshotInterval = 1
+	options.ErrorLog = func(err error) { logged = append(logged, err) }
+
+	s, err := Open(backend, []byte(`{"n": 0}`), options)
+	if err != nil {
+		t.Fatalf("unable to open store: %s", err)
+	}
+
+	backend.fail = true
+
+	// The patch is journaled, so the version exists without its snapshot.
+	version, _, err := s.Apply(decodePatch(t, `[{"op": "replace", "path": "/n", "value": 1}]`), 0)
+	if err != nil || version != 1 {
+		t.Fatalf("expected version 1, got %d, %v", version, err)
+	}
+
+	if len(logged) != 1 || !errors.Is(logged[0], errSnapshot) {
+		t.Errorf("expected the snapshot error to be logged, got %v", logged)
+	}
+
+	reopened, err := Open(backend, nil, options)
+	if err != nil {
+		t.Fatalf("unable to reopen store: %s", err)
+	}
+
+	if doc, err := reopened.Get(1); err != nil || !jsonpatch.Equal(doc, []byte(`{"n": 1}`)) {
+		t.Errorf("expected version 1 to be replayed, got %s, %v", doc, err)
+	}
+}
@@ -0,0 +1,312 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// Template is a patch whose operations contain placeholders for variables.
+// In "path" and "from", "${name}" is replaced by the variable as a reference
+// token, which must be a string, number or boolean; "$${" stands for a
+// literal "${". Anywhere in "value", an object of the form {"$var": "name"}
+// is replaced by the variable as JSON.
+type Template struct {
+	ops     []Operation
+	options *ApplyOptions
+}
+
+// DecodeTemplate decodes the passed JSON document as a patch template.
+func DecodeTemplate(buf []byte) (*Template, error) {
+	return DecodeTemplateWithOptions(buf, NewApplyOptions())
+}
+
+// DecodeTemplateWithOptions decodes the passed JSON document as a patch
+// template. The operations enabled by the passed in ApplyOptions are accepted
+// by Validate, and the patches are applied with them by Apply.
+func DecodeTemplateWithOptions(buf []byte, options *ApplyOptions) (*Template, error) {
+	if !json.Valid(buf) {
+		return nil, ErrInvalid
+	}
+
+	var ops []Operation
+	if err := unmarshal(buf, &ops); err != nil {
+		return nil, err
+	}
+
+	t := &Template{ops: ops, options: options}
+
+	// Checks that the placeholders are well formed.
+	if _, err := t.execute(nil, true); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Variables returns the sorted names of the variables the template refers
+// to.
+func (t *Template) Variables() []string {
+	var names []string
+	seen := map[string]bool{}
+
+	t.execute(func(name string) (interface{}, bool) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil, false
+	}, true)
+
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that vars resolves every variable of the template, and that
+// the resulting patch is valid. Variables missing from vars are reported
+// together with an UnresolvedVariablesError.
+func (t *Template) Validate(vars map[string]interface{}) error {
+	_, err := t.Execute(vars)
+	return err
+}
+
+// Execute returns the patch the template produces with vars.
+func (t *Template) Execute(vars map[string]interface{}) (Patch, error) {
+	var missing []string
+	seen := map[string]bool{}
+
+	lookup := func(name string) (interface{}, bool) {
+		v, ok := vars[name]
+		if !ok && !seen[name] {
+			seen[name] = true
+			missing = append(missing, name)
+		}
+		return v, ok
+	}
+
+	// The first pass collects every unresolved variable.
+	if _, err := t.execute(lookup, true); err != nil {
+		return nil, err
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, NewUnresolvedVariablesError(missing)
+	}
+
+	p, err := t.execute(lookup, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePatch(p, t.options); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Apply applies the patch the template produces with vars to doc.
+func (t *Template) Apply(doc []byte, vars map[string]interface{}) ([]byte, error) {
+	p, err := t.Execute(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ApplyWithOptions(doc, t.options)
+}
+
+// execute substitutes the variables of the template. With dryRun, variables
+// are only looked up, which lets the caller see every one of them.
+func (t *Template) execute(lookup func(string) (interface{}, bool), dryRun bool) (Patch, error) {
+	p := make(Patch, 0, len(t.ops))
+
+	for _, op := range t.ops {
+		n := make(Operation, len(op))
+		for k, v := range op {
+			n[k] = v
+		}
+
+		for _, member := range []string{"path", "from"} {
+			raw, ok := op[member]
+			if !ok || raw == nil {
+				continue
+			}
+
+			var s string
+			if err := unmarshal(*raw, &s); err != nil {
+				continue
+			}
+
+			path, err := expandPath(s, lookup, dryRun)
+			if err != nil {
+				return nil, err
+			}
+
+			n[member] = rawString(path)
+		}
+
+		if raw, ok := op["value"]; ok && raw != nil {
+			value, err := expandValue(newLazyNode(raw), lookup, dryRun)
+			if err != nil {
+				return nil, err
+			}
+
+			if !dryRun {
+				buf, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				n["value"] = newRawMessage(buf)
+			}
+		}
+
+		p = append(p, n)
+	}
+
+	return p, nil
+}
+
+func isVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' && c != '-' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func expandPath(path string, lookup func(string) (interface{}, bool), dryRun bool) (string, error) {
+	var sb strings.Builder
+
+	for {
+		i := strings.Index(path, "${")
+		if i < 0 {
+			sb.WriteString(path)
+			return sb.String(), nil
+		}
+
+		if i > 0 && path[i-1] == '$' {
+			sb.WriteString(path[:i-1])
+			sb.WriteString("${")
+			path = path[i+2:]
+			continue
+		}
+
+		sb.WriteString(path[:i])
+
+		end := strings.IndexByte(path[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q: %w", path, ErrInvalid)
+		}
+
+		name := path[i+2 : i+end]
+		if !isVariableName(name) {
+			return "", fmt.Errorf("invalid variable name %q: %w", name, ErrInvalid)
+		}
+
+		path = path[i+end+1:]
+
+		if lookup == nil {
+			continue
+		}
+
+		v, ok := lookup(name)
+		if !ok || dryRun {
+			continue
+		}
+
+		tok, err := pathToken(name, v)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(encodePatchKey(tok))
+	}
+}
+
+// pathToken formats a variable as a reference token.
+func pathToken(name string, v interface{}) (string, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode variable %s: %w", name, err)
+	}
+
+	var decoded interface{}
+	if err := unmarshal(buf, &decoded); err != nil {
+		return "", fmt.Errorf("unable to encode variable %s: %w", name, err)
+	}
+
+	switch decoded := decoded.(type) {
+	case string:
+		return decoded, nil
+	case json.Number, bool:
+		return string(buf), nil
+	}
+
+	return "", fmt.Errorf("variable %s in a path must be a string, number or boolean: %w", name, ErrInvalid)
+}
+
+// expandValue replaces the {"$var": "name"} objects of a value.
+func expandValue(n *lazyNode, lookup func(string) (interface{}, bool), dryRun bool) (*lazyNode, error) {
+	switch nodeType(n) {
+	case nodeObject:
+		doc, err := n.intoDoc(NewApplyOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		if raw, ok := doc.obj["$var"]; ok && len(doc.obj) == 1 {
+			var name string
+			if raw == nil || unmarshal(*raw.raw, &name) != nil || !isVariableName(name) {
+				return nil, fmt.Errorf("$var must name a variable: %w", ErrInvalid)
+			}
+
+			if lookup == nil {
+				return n, nil
+			}
+
+			v, ok := lookup(name)
+			if !ok || dryRun {
+				return n, nil
+			}
+
+			buf, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("unable to encode variable %s: %w", name, err)
+			}
+
+			return newLazyNode(newRawMessage(buf)), nil
+		}
+
+		for k, v := range doc.obj {
+			child, err := expandValue(v, lookup, dryRun)
+			if err != nil {
+				return nil, err
+			}
+			doc.obj[k] = child
+		}
+	case nodeArray:
+		ary, err := n.intoAry()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, v := range ary.nodes {
+			child, err := expandValue(v, lookup, dryRun)
+			if err != nil {
+				return nil, err
+			}
+			ary.nodes[i] = child
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,126 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	cases := []struct {
+		template, doc string
+		vars          map[string]interface{}
+		result        string
+	}{
+		{
+			`[{"op": "replace", "path": "/spec/${component}/replicas", "value": {"$var": "replicas"}}]`,
+			`{"spec": {"api": {"replicas": 1}}}`,
+			map[string]interface{}{"component": "api", "replicas": 3},
+			`{"spec": {"api": {"replicas": 3}}}`,
+		},
+		{
+			`[{"op": "add", "path": "/labels/${key}", "value": {"env": {"$var": "env"}, "list": [{"$var": "n"}, 2], "big": 12345678901234567890}}]`,
+			`{"labels": {}}`,
+			map[string]interface{}{"key": "a/b~c", "env": "prod", "n": 1.5},
+			`{"labels": {"a/b~c": {"env": "prod", "list": [1.5, 2], "big": 12345678901234567890}}}`,
+		},
+		{
+			`[{"op": "add", "path": "/items/${index}", "value": {"$var": "item"}}, {"op": "copy", "from": "/items/${index}", "path": "/first"}]`,
+			`{"items": ["b"]}`,
+			map[string]interface{}{"index": 0, "item": map[string]interface{}{"name": "a", "tags": []string{"x"}}},
+			`{"items": [{"name": "a", "tags": ["x"]}, "b"], "first": {"name": "a", "tags": ["x"]}}`,
+		},
+		{
+			`[{"op": "add", "path": "/$${literal}", "value": {"$var": "v"}}]`,
+			`{}`,
+			map[string]interface{}{"v": nil},
+			`{"${literal}": null}`,
+		},
+		{
+			`[{"op": "add", "path": "/a", "value": {"$var": "v", "other": 1}}]`,
+			`{}`,
+			nil,
+			`{"a": {"$var": "v", "other": 1}}`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			tmpl, err := DecodeTemplate([]byte(c.template))
+			if err != nil {
+				t.Fatalf("unable to decode template: %s", err)
+			}
+
+			if err := tmpl.Validate(c.vars); err != nil {
+				t.Fatalf("unable to validate template: %s", err)
+			}
+
+			out, err := tmpl.Apply([]byte(c.doc), c.vars)
+			if err != nil {
+				t.Fatalf("unable to apply template: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+		})
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	tmpl, err := DecodeTemplate([]byte(`[
+		{"op": "replace", "path": "/spec/${component}/replicas", "value": {"$var": "replicas"}},
+		{"op": "move", "from": "/${component}", "path": "/${target}", "value": [{"$var": "replicas"}]}
+	]`))
+	if err != nil {
+		t.Fatalf("unable to decode template: %s", err)
+	}
+
+	if names := tmpl.Variables(); !reflect.DeepEqual(names, []string{"component", "replicas", "target"}) {
+		t.Errorf("unexpected variables %v", names)
+	}
+
+	err = tmpl.Validate(map[string]interface{}{"component": "api"})
+
+	var unresolved *UnresolvedVariablesError
+	if !errors.As(err, &unresolved) {
+		t.Fatalf("expected an UnresolvedVariablesError, got %v", err)
+	}
+
+	if !reflect.DeepEqual(unresolved.Variables(), []string{"replicas", "target"}) {
+		t.Errorf("unexpected unresolved variables %v", unresolved.Variables())
+	}
+
+	if !errors.Is(err, ErrMissing) {
+		t.Errorf("expected the error to match ErrMissing")
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, tmpl := range []string{
+		`{}`,
+		`[{"op": "add", "path": "/${name", "value": 1}]`,
+		`[{"op": "add", "path": "/${}", "value": 1}]`,
+		`[{"op": "add", "path": "/${a b}", "value": 1}]`,
+		`[{"op": "add", "path": "/a", "value": {"$var": 1}}]`,
+	} {
+		if _, err := DecodeTemplate([]byte(tmpl)); err == nil {
+			t.Errorf("expected %s to be rejected", tmpl)
+		}
+	}
+
+	tmpl, err := DecodeTemplate([]byte(`[{"op": "${op}", "path": "/${key}", "value": 1}]`))
+	if err != nil {
+		t.Fatalf("unable to decode template: %s", err)
+	}
+
+	if err := tmpl.Validate(map[string]interface{}{"key": []int{1}}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected an array in a path to be rejected, got %v", err)
+	}
+
+	if err := tmpl.Validate(map[string]interface{}{"key": "a"}); err == nil {
+		t.Error("expected an invalid operation to be rejected")
+	}
+}
@@ -0,0 +1,543 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// TieBreak decides which of two concurrent patches wins when both set the
+// same location.
+type TieBreak int
+
+const (
+	// FirstWins keeps the values of the first patch passed to Transform, and
+	// orders its insertions before those of the second patch.
+	FirstWins TieBreak = iota
+	// SecondWins keeps the values of the second patch passed to Transform, and
+	// orders its insertions before those of the first patch.
+	SecondWins
+)
+
+// TransformOptions specifies options for calls to TransformWithOptions.
+type TransformOptions struct {
+	// TieBreak decides the outcome of concurrent replaces of the same path,
+	// and the order of concurrent insertions at the same array index.
+	// Defaults to FirstWins.
+	TieBreak TieBreak
+}
+
+// Transform rewrites two patches a and b, both made against the same
+// document, so that each can be applied after the other: applying a then
+// bPrime yields the same document as applying b then aPrime.
+//
+// Array indices are shifted for concurrent insertions and removals,
+// operations follow values moved by the other patch, and operations inside
+// values removed or replaced by the other patch are dropped. When both
+// patches set the same location, a wins; use TransformWithOptions to change
+// this. A removal always wins over a concurrent replace of the same location.
+//
+// An error wrapping ErrIndeterminate is returned when the outcome depends on
+// the document, such as for concurrent appends to the same array, for
+// changes to a value the other patch moves to the end of an array, or for
+// array positions inside values that either patch replaces or moves.
+func Transform(a, b Patch) (aPrime, bPrime Patch, err error) {
+	return TransformWithOptions(a, b, &TransformOptions{})
+}
+
+// TransformWithOptions is like Transform, with the tie-break given by the
+// passed in TransformOptions.
+func TransformWithOptions(a, b Patch, options *TransformOptions) (aPrime, bPrime Patch, err error) {
+	c := newComposer()
+
+	as, err := c.decodeAll(a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bs, err := c.decodeAll(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aFirst := options.TieBreak == FirstWins
+
+	var out []*composeOp
+
+	for _, x := range as {
+		rest := make([]*composeOp, 0, len(bs))
+
+		for _, y := range bs {
+			if x == nil {
+				rest = append(rest, y)
+				continue
+			}
+
+			x2, err := c.transform(x, y, aFirst)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			y2, err := c.transform(y, x, !aFirst)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			x = x2
+			if y2 != nil {
+				rest = append(rest, y2)
+			}
+		}
+
+		if x != nil {
+			out = append(out, x)
+		}
+
+		bs = rest
+	}
+
+	return composedPatch(out), composedPatch(bs), nil
+}
+
+func (c *composer) decodeAll(p Patch) ([]*composeOp, error) {
+	out := make([]*composeOp, 0, len(p))
+
+	for _, op := range p {
+		cop, err := newComposeOp(op)
+		if err != nil {
+			return nil, err
+		}
+
+		c.observe(cop)
+		out = append(out, cop)
+	}
+
+	return out, nil
+}
+
+func composedPatch(ops []*composeOp) Patch {
+	out := make(Patch, 0, len(ops))
+	for _, op := range ops {
+		out = append(out, op.operation())
+	}
+	return out
+}
+
+type mapStatus int
+
+const (
+	// mapKept means the location still exists, possibly at another pointer.
+	mapKept mapStatus = iota
+	// mapGone means the location was removed, or lies inside a value that
+	// was replaced.
+	mapGone
+	// mapOverwritten means the value at the location itself was set.
+	mapOverwritten
+	// mapMoved means the location was carried along by a move, and the
+	// pointer already refers to its destination.
+	mapMoved
+)
+
+// movingEffect is an effect that may carry the value it removes to another
+// location, as the first effect of a move does.
+type movingEffect struct {
+	effect
+	moveTo []string
+}
+
+func (c *composer) movingEffects(op *composeOp) []movingEffect {
+	effects := c.effects(op)
+
+	out := make([]movingEffect, len(effects))
+	for i, e := range effects {
+		out[i].effect = e
+	}
+
+	if op.kind == "move" {
+		out[0].moveTo = op.path
+	}
+
+	return out
+}
+
+// transform rewrites x to apply after y, where both were made against the
+// same document. first tells whether x wins over y in case of a tie. A nil
+// result means x no longer has any effect.
+func (c *composer) transform(x, y *composeOp, first bool) (*composeOp, error) {
+	if y.kind == "test" {
+		return x.clone(), nil
+	}
+
+	effects := c.movingEffects(y)
+
+	switch x.kind {
+	case "test":
+		if c.modifiesWithin(effects, x.path, first) {
+			return nil, nil
+		}
+
+		path, status, err := c.mapThrough(x.path, false, effects, first)
+		if err != nil || status != mapKept {
+			return nil, err
+		}
+
+		return x.withPath(path), nil
+	case "replace", "remove":
+		path, status, err := c.mapThrough(x.path, false, effects, first)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case status == mapGone:
+			return nil, nil
+		case status == mapOverwritten && x.kind == "replace" && !first:
+			return nil, nil
+		}
+
+		return x.withPath(path), nil
+	case "add":
+		path, status, err := c.mapThrough(x.path, c.isPosition(x.path), effects, first)
+		if err != nil {
+			return nil, err
+		}
+
+		if status == mapGone || (status == mapOverwritten && !first) {
+			return nil, nil
+		}
+
+		if c.setsElement(x.path, path) {
+			// The member was moved into an array by y, so x now replaces the
+			// element rather than inserting one.
+			return x.withKind("replace", x.value).withPath(path), nil
+		}
+
+		return x.withPath(path), nil
+	case "copy":
+		from, status, err := c.mapThrough(x.from, false, effects, first)
+		if err != nil {
+			return nil, err
+		}
+
+		if status != mapKept || c.modifiesWithin(effects, x.from, first) {
+			return nil, fmt.Errorf("cannot transform copy from %q, which is concurrently modified: %w",
+				joinPointer(x.from), ErrIndeterminate)
+		}
+
+		path, status, err := c.mapThrough(x.path, c.isPosition(x.path), effects, first)
+		if err != nil {
+			return nil, err
+		}
+
+		if status == mapGone || (status == mapOverwritten && !first) {
+			return nil, nil
+		}
+
+		if c.setsElement(x.path, path) {
+			return nil, movedTargetError(x)
+		}
+
+		n := x.withPath(path)
+		n.from = from
+		return n, nil
+	case "move":
+		return c.transformMove(x, y, effects, first)
+	}
+
+	return nil, fmt.Errorf("Unexpected kind: %s", x.kind)
+}
+
+func (c *composer) transformMove(x, y *composeOp, effects []movingEffect, first bool) (*composeOp, error) {
+	if y.kind == "move" && pointerEqual(x.from, y.from) {
+		if !pointerEqual(x.path, y.path) && !(c.isPosition(x.path) && c.isPosition(y.path)) {
+			// Whichever move loses would have to restore the member the
+			// other one replaced.
+			return nil, fmt.Errorf("cannot transform concurrent moves of %q onto a member: %w",
+				joinPointer(x.from), ErrIndeterminate)
+		}
+
+		if !first {
+			return nil, nil
+		}
+
+		if !c.isConcrete(y.path) {
+			return nil, fmt.Errorf("cannot transform move of %q, concurrently moved to %q: %w",
+				joinPointer(x.from), joinPointer(y.path), ErrIndeterminate)
+		}
+
+		// y already moved the value; carry on from where it put it. Removing
+		// it from there leaves the same document as removing it from x.from,
+		// so x.path needs no adjustment.
+		n := x.clone()
+		n.from = appendPointer(y.path)
+		return n, nil
+	}
+
+	from, status, err := c.mapThrough(x.from, false, effects, first)
+	if err != nil {
+		return nil, err
+	}
+
+	position := c.isPosition(x.path)
+
+	if status == mapGone {
+		if c.goneWithin(x.from, effects, first) {
+			// Dropping x would lose the value along with the one y removes or
+			// replaces, while y after x leaves it where x moved it.
+			return nil, fmt.Errorf("cannot transform move from %q, inside a value that is concurrently removed or replaced: %w",
+				joinPointer(x.from), ErrIndeterminate)
+		}
+
+		if !position {
+			return nil, fmt.Errorf("cannot transform move onto member %q of a value that is concurrently removed: %w",
+				joinPointer(x.path), ErrIndeterminate)
+		}
+		return nil, nil
+	}
+
+	// x.path is relative to the document with x.from removed, so map it
+	// through the effects of y as seen from that document.
+	virtual := make([]movingEffect, 0, len(effects))
+	mapped := make([]bool, len(effects))
+	removed := x.from
+
+	for i, e := range effects {
+		ptr, st, err := c.mapPointer(e.ptr, e.kind == effectInsert, movingEffect{effect: effect{kind: effectRemove, ptr: removed}}, first)
+		if err != nil {
+			return nil, err
+		}
+
+		if st != mapGone {
+			mapped[i] = true
+			virtual = append(virtual, movingEffect{effect: effect{kind: e.kind, ptr: ptr}})
+		}
+
+		removed, _, err = c.mapPointer(removed, false, e, first)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if effects[0].moveTo != nil && mapped[0] {
+		if !mapped[1] {
+			return nil, fmt.Errorf("cannot transform move from %q into a value that is concurrently moved into it: %w",
+				joinPointer(x.from), ErrIndeterminate)
+		}
+		virtual[0].moveTo = virtual[1].ptr
+	}
+
+	path, status, err := c.mapThrough(x.path, position, virtual, first)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == mapGone || (status == mapOverwritten && !first) {
+		// The value would have been moved into a location that no longer
+		// exists, or that is set by y, so x only removes it.
+		rm := x.withKind("remove", nil)
+		rm.path = from
+		return rm, nil
+	}
+
+	if c.setsElement(x.path, path) {
+		return nil, movedTargetError(x)
+	}
+
+	n := x.withPath(path)
+	n.from = from
+	return n, nil
+}
+
+func (c *composeOp) withPath(path []string) *composeOp {
+	n := c.clone()
+	n.path = path
+	return n
+}
+
+// isPosition reports whether ptr, as the target of an add, is an insertion
+// position in an array rather than a location to set.
+func (c *composer) isPosition(ptr []string) bool {
+	return c.placement(ptr).kind == effectInsert
+}
+
+// isConcrete reports whether ptr names a single location regardless of the
+// document, unlike an append or a negative index.
+func (c *composer) isConcrete(ptr []string) bool {
+	switch c.classify(ptr) {
+	case tokenMember, tokenIndex:
+		return true
+	}
+	return false
+}
+
+// setsElement reports whether ptr, the target of an add that sets an object
+// member, was mapped to mapped, an element of an array.
+func (c *composer) setsElement(ptr, mapped []string) bool {
+	return !c.isPosition(ptr) && c.isPosition(mapped)
+}
+
+func movedTargetError(op *composeOp) error {
+	return fmt.Errorf("cannot transform %s onto member %q, which is concurrently moved into an array: %w",
+		op.kind, joinPointer(op.path), ErrIndeterminate)
+}
+
+// goneWithin reports whether ptr is gone after effects because a value
+// containing it was removed or replaced, rather than its own value.
+func (c *composer) goneWithin(ptr []string, effects []movingEffect, first bool) bool {
+	for _, e := range effects {
+		next, status, err := c.mapPointer(ptr, false, e, first)
+		switch {
+		case err != nil, status == mapMoved, status == mapOverwritten:
+			return false
+		case status == mapGone:
+			return len(e.ptr) < len(ptr)
+		}
+
+		ptr = next
+	}
+
+	return false
+}
+
+// modifiesWithin reports whether any of effects changes something inside the
+// value at ptr, following the value from one effect to the next.
+func (c *composer) modifiesWithin(effects []movingEffect, ptr []string, first bool) bool {
+	for _, e := range effects {
+		if e.kind != effectRead && len(e.ptr) > len(ptr) && hasPointerPrefix(e.ptr, ptr) {
+			return true
+		}
+
+		next, status, err := c.mapPointer(ptr, false, e, first)
+		if err != nil || status != mapKept {
+			return false
+		}
+
+		ptr = next
+	}
+	return false
+}
+
+// mapThrough maps a pointer through a sequence of effects, returning where
+// the location it refers to ends up.
+func (c *composer) mapThrough(ptr []string, position bool, effects []movingEffect, first bool) ([]string, mapStatus, error) {
+	for _, e := range effects {
+		next, status, err := c.mapPointer(ptr, position, e, first)
+		switch {
+		case err != nil:
+			return nil, status, err
+		case status == mapMoved:
+			return next, mapKept, nil
+		case status != mapKept:
+			return next, status, nil
+		}
+
+		ptr = next
+	}
+
+	return ptr, mapKept, nil
+}
+
+// mapPointer maps ptr through a single effect. If position is true, ptr is an
+// insertion position in an array rather than the location of a value. first
+// breaks ties between insertions at the same position.
+func (c *composer) mapPointer(ptr []string, position bool, e movingEffect, first bool) ([]string, mapStatus, error) {
+	switch e.kind {
+	case effectRead:
+		return ptr, mapKept, nil
+	case effectWrite:
+		switch {
+		case pointerEqual(ptr, e.ptr):
+			if position {
+				return ptr, mapKept, nil
+			}
+			return ptr, mapOverwritten, nil
+		case hasPointerPrefix(ptr, e.ptr):
+			return ptr, mapGone, nil
+		}
+
+		return ptr, mapKept, nil
+	}
+
+	class := c.classify(e.ptr)
+
+	if class == tokenMember {
+		// Removal of an object member.
+		if !hasPointerPrefix(ptr, e.ptr) {
+			return ptr, mapKept, nil
+		}
+
+		if e.moveTo != nil {
+			return c.moveInto(ptr, e, ptr[len(e.ptr):])
+		}
+
+		return ptr, mapGone, nil
+	}
+
+	level := len(e.ptr) - 1
+	if len(ptr) <= level || !hasPointerPrefix(ptr, e.ptr[:level]) {
+		return ptr, mapKept, nil
+	}
+
+	tok := ptr[level]
+	exact := len(ptr) == level+1
+
+	indeterminate := func() ([]string, mapStatus, error) {
+		return nil, mapKept, fmt.Errorf("cannot map %q across a concurrent change at %q: %w",
+			joinPointer(ptr), joinPointer(e.ptr), ErrIndeterminate)
+	}
+
+	switch {
+	case class == tokenNegative, class == tokenAmbiguous, isNegativeIndex(tok):
+		return indeterminate()
+	case class == tokenAppend:
+		if tok == "-" && exact && position && e.kind == effectInsert {
+			return indeterminate()
+		}
+		return ptr, mapKept, nil
+	case tok == "-":
+		return ptr, mapKept, nil
+	}
+
+	k, _ := arrayIndex(e.ptr[level])
+
+	j, ok := arrayIndex(tok)
+	if !ok {
+		return ptr, mapKept, nil
+	}
+
+	switch e.kind {
+	case effectRemove:
+		switch {
+		case j == k && exact && position:
+		case j == k:
+			if e.moveTo != nil {
+				return c.moveInto(ptr, e, ptr[level+1:])
+			}
+			return ptr, mapGone, nil
+		case j > k:
+			j--
+		}
+	case effectInsert:
+		switch {
+		case j > k:
+			j++
+		case j == k && !(exact && position && first):
+			j++
+		}
+	}
+
+	out := appendPointer(ptr)
+	out[level] = strconv.Itoa(j)
+
+	return out, mapKept, nil
+}
+
+// moveInto maps ptr, whose part inside the value removed by e is rest, to
+// where the move of e puts that value.
+func (c *composer) moveInto(ptr []string, e movingEffect, rest []string) ([]string, mapStatus, error) {
+	if c.isConcrete(e.moveTo) {
+		return appendPointer(e.moveTo, rest...), mapMoved, nil
+	}
+
+	return nil, mapKept, fmt.Errorf("cannot map %q into a value concurrently moved to %q: %w",
+		joinPointer(ptr), joinPointer(e.moveTo), ErrIndeterminate)
+}
@@ -0,0 +1,422 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+var TransformCases = []struct {
+	doc      string
+	a, b     string
+	tieBreak TieBreak
+	result   string
+}{
+	{
+		`{"list": ["a", "b", "c"]}`,
+		`[{"op": "add", "path": "/list/0", "value": "x"}]`,
+		`[{"op": "remove", "path": "/list/1"}]`,
+		FirstWins,
+		`{"list": ["x", "a", "c"]}`,
+	},
+	{
+		`{"list": ["a", "b", "c"]}`,
+		`[{"op": "add", "path": "/list/1", "value": "x"}]`,
+		`[{"op": "add", "path": "/list/1", "value": "y"}]`,
+		FirstWins,
+		`{"list": ["a", "x", "y", "b", "c"]}`,
+	},
+	{
+		`{"list": ["a", "b", "c"]}`,
+		`[{"op": "add", "path": "/list/1", "value": "x"}]`,
+		`[{"op": "add", "path": "/list/1", "value": "y"}]`,
+		SecondWins,
+		`{"list": ["a", "y", "x", "b", "c"]}`,
+	},
+	{
+		`{"foo": 1}`,
+		`[{"op": "replace", "path": "/foo", "value": 2}]`,
+		`[{"op": "replace", "path": "/foo", "value": 3}]`,
+		FirstWins,
+		`{"foo": 2}`,
+	},
+	{
+		`{"foo": 1}`,
+		`[{"op": "replace", "path": "/foo", "value": 2}]`,
+		`[{"op": "replace", "path": "/foo", "value": 3}]`,
+		SecondWins,
+		`{"foo": 3}`,
+	},
+	{
+		`{"foo": 1}`,
+		`[{"op": "replace", "path": "/foo", "value": 2}]`,
+		`[{"op": "remove", "path": "/foo"}]`,
+		FirstWins,
+		`{}`,
+	},
+	{
+		`{"foo": {"bar": 1}, "baz": 2}`,
+		`[{"op": "replace", "path": "/foo/bar", "value": 3}, {"op": "replace", "path": "/baz", "value": 4}]`,
+		`[{"op": "remove", "path": "/foo"}]`,
+		FirstWins,
+		`{"baz": 4}`,
+	},
+	{
+		`{"a": {"x": 1}, "b": {}}`,
+		`[{"op": "replace", "path": "/a/x", "value": 2}]`,
+		`[{"op": "move", "from": "/a", "path": "/b/a"}]`,
+		FirstWins,
+		`{"b": {"a": {"x": 2}}}`,
+	},
+	{
+		`{"list": ["a", "b", "c", "d"]}`,
+		`[{"op": "move", "from": "/list/0", "path": "/list/3"}]`,
+		`[{"op": "remove", "path": "/list/1"}, {"op": "replace", "path": "/list/2", "value": "z"}]`,
+		FirstWins,
+		`{"list": ["c", "z", "a"]}`,
+	},
+	{
+		`{"list": ["a", "b"]}`,
+		`[{"op": "move", "from": "/list/0", "path": "/list/1"}]`,
+		`[{"op": "move", "from": "/list/0", "path": "/list/0"}]`,
+		FirstWins,
+		`{"list": ["b", "a"]}`,
+	},
+	{
+		`{"foo": 1, "bar": 2}`,
+		`[{"op": "test", "path": "/foo", "value": 1}, {"op": "replace", "path": "/bar", "value": 3}]`,
+		`[{"op": "replace", "path": "/foo", "value": 4}]`,
+		FirstWins,
+		`{"foo": 4, "bar": 3}`,
+	},
+	{
+		`{"list": [1, 2]}`,
+		`[{"op": "copy", "from": "/list/1", "path": "/list/0"}]`,
+		`[{"op": "add", "path": "/list/1", "value": 3}]`,
+		FirstWins,
+		`{"list": [2, 1, 3, 2]}`,
+	},
+	{
+		`{"list": [{"k": 0}, {"k": 1}]}`,
+		`[{"op": "move", "from": "/list/0", "path": "/list/0/other"}]`,
+		`[{"op": "test", "path": "/list/1", "value": {"k": 1}}]`,
+		FirstWins,
+		`{"list": [{"k": 1, "other": {"k": 0}}]}`,
+	},
+}
+
+func TestTransform(t *testing.T) {
+	for i, c := range TransformCases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			patches := decodePatches(t, []string{c.a, c.b})
+			a, b := patches[0], patches[1]
+
+			aPrime, bPrime, err := TransformWithOptions(a, b, &TransformOptions{TieBreak: c.tieBreak})
+			if err != nil {
+				t.Fatalf("unable to transform patches: %s", err)
+			}
+
+			ab := applySequence(t, c.doc, []Patch{a, bPrime})
+			ba := applySequence(t, c.doc, []Patch{b, aPrime})
+
+			if !compareJSON(ab, c.result) {
+				t.Errorf("unexpected result of a then b'. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(ab))
+			}
+
+			if !compareJSON(ba, c.result) {
+				t.Errorf("unexpected result of b then a'. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(ba))
+			}
+		})
+	}
+}
+
+func TestTransformDefaultsToFirstWins(t *testing.T) {
+	patches := decodePatches(t, []string{
+		`[{"op": "replace", "path": "/foo", "value": 2}]`,
+		`[{"op": "replace", "path": "/foo", "value": 3}]`,
+	})
+
+	aPrime, bPrime, err := Transform(patches[0], patches[1])
+	if err != nil {
+		t.Fatalf("unable to transform patches: %s", err)
+	}
+
+	if len(bPrime) != 0 {
+		t.Errorf("expected b' to be empty, got %d operation(s)", len(bPrime))
+	}
+
+	got, err := json.Marshal(aPrime)
+	if err != nil {
+		t.Fatalf("unable to marshal patch: %s", err)
+	}
+
+	if !compareJSON(string(got), `[{"op": "replace", "path": "/foo", "value": 2}]`) {
+		t.Errorf("unexpected a': %s", got)
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	cases := []struct {
+		a, b          string
+		indeterminate bool
+	}{
+		{
+			`[{"op": "add", "path": "/list/-", "value": 1}]`,
+			`[{"op": "add", "path": "/list/-", "value": 2}]`,
+			true,
+		},
+		{
+			`[{"op": "copy", "from": "/foo", "path": "/bar"}]`,
+			`[{"op": "replace", "path": "/foo", "value": 1}]`,
+			true,
+		},
+		{
+			`[{"op": "add", "path": "/list/-1", "value": 1}]`,
+			`[{"op": "remove", "path": "/list/0"}]`,
+			true,
+		},
+		{
+			`[{"op": "remove", "path": "/o/x"}]`,
+			`[{"op": "move", "from": "/o/x", "path": "/a/-"}]`,
+			true,
+		},
+		{
+			`[{"op": "move", "from": "/a/0", "path": "/a/-"}]`,
+			`[{"op": "replace", "path": "/a/0/k", "value": 1}]`,
+			true,
+		},
+		{
+			`[{"op": "move", "from": "/a/0", "path": "/b/0"}]`,
+			`[{"op": "move", "from": "/a/0", "path": "/a/-"}]`,
+			true,
+		},
+		{
+			`[{"op": "remove", "path": "/a/0"}]`,
+			`[{"op": "move", "from": "/a/0/k", "path": "/a/3"}]`,
+			true,
+		},
+		{
+			`[{"op": "replace", "path": "/a/0", "value": 1}]`,
+			`[{"op": "move", "from": "/a/0/k", "path": "/b/k"}]`,
+			true,
+		},
+		{
+			`[{"op": "remove", "path": "/a/1/k"}]`,
+			`[{"op": "copy", "from": "/o/x", "path": "/a/1"}, {"op": "move", "from": "/a", "path": "/o"}, {"op": "remove", "path": "/o/1"}]`,
+			true,
+		},
+		{
+			`[{"op": "replace", "path": "/s", "value": 5}]`,
+			`[{"op": "move", "from": "/a", "path": "/o"}, {"op": "move", "from": "/s", "path": "/o/-"}]`,
+			true,
+		},
+		{
+			`[{"op": "replace", "path": "/2/a/0", "value": "x"}, {"op": "move", "from": "/2/a", "path": "/2"}, {"op": "remove", "path": "/1"}]`,
+			`[{"op": "add", "path": "/2/a/-", "value": 2}]`,
+			true,
+		},
+		{
+			`[{"op": "add", "path": "foo", "value": 1}]`,
+			`[]`,
+			false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			patches := decodePatches(t, []string{c.a, c.b})
+
+			_, _, err := Transform(patches[0], patches[1])
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if errors.Is(err, ErrIndeterminate) != c.indeterminate {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+// transformPointers lists the pointers to the values inside v, and the
+// positions an add could insert at or set.
+func transformPointers(v interface{}, prefix string, values, positions *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		*positions = append(*positions, prefix+"/new")
+		for _, k := range keys {
+			ptr := prefix + "/" + k
+			*values = append(*values, ptr)
+			*positions = append(*positions, ptr)
+			transformPointers(v[k], ptr, values, positions)
+		}
+	case []interface{}:
+		*positions = append(*positions, prefix+"/-")
+		for i := 0; i <= len(v); i++ {
+			*positions = append(*positions, prefix+"/"+strconv.Itoa(i))
+		}
+		for i, e := range v {
+			ptr := prefix + "/" + strconv.Itoa(i)
+			*values = append(*values, ptr)
+			transformPointers(e, ptr, values, positions)
+		}
+	}
+}
+
+// randomOperation returns an operation that applies to doc, setting one of
+// values where it sets a value.
+func randomOperation(t *testing.T, r *rand.Rand, doc string, values ...string) Patch {
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("unable to unmarshal document: %s", err)
+	}
+
+	if len(values) == 0 {
+		values = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	}
+
+	var ptrs, positions []string
+	transformPointers(v, "", &ptrs, &positions)
+
+	pick := func(ptrs []string) string {
+		return ptrs[r.Intn(len(ptrs))]
+	}
+
+	value := func() string {
+		return values[r.Intn(len(values))]
+	}
+
+	for {
+		kind := r.Intn(6)
+		if len(ptrs) == 0 {
+			kind = 0
+		}
+
+		var op string
+		switch kind {
+		case 0:
+			op = fmt.Sprintf(`{"op": "add", "path": %q, "value": %s}`, pick(positions), value())
+		case 1:
+			op = fmt.Sprintf(`{"op": "remove", "path": %q}`, pick(ptrs))
+		case 2:
+			op = fmt.Sprintf(`{"op": "replace", "path": %q, "value": %s}`, pick(ptrs), value())
+		case 3:
+			op = fmt.Sprintf(`{"op": "move", "from": %q, "path": %q}`, pick(ptrs), pick(positions))
+		case 4:
+			op = fmt.Sprintf(`{"op": "copy", "from": %q, "path": %q}`, pick(ptrs), pick(positions))
+		case 5:
+			op = fmt.Sprintf(`{"op": "test", "path": %q, "value": %s}`, pick(ptrs), value())
+		}
+
+		p, err := DecodePatch([]byte("[" + op + "]"))
+		if err != nil {
+			t.Fatalf("unable to decode patch: %s", err)
+		}
+
+		if _, err := p.Apply([]byte(doc)); err == nil {
+			return p
+		}
+	}
+}
+
+func TestTransformConverges(t *testing.T) {
+	docs := []string{
+		`{"a": [{"k": 0}, {"k": 1}, 2, 3], "o": {"x": {"y": 1}, "z": [4, 5]}, "s": "t"}`,
+		`{"a": [[1, [2, 3]], {"b": [4, {"c": 5}]}], "d": {"e": [6], "f": {"g": 7}}}`,
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, doc := range docs {
+		for i := 0; i < 2000; i++ {
+			a := randomOperation(t, r, doc)
+			b := randomOperation(t, r, doc)
+
+			for _, tieBreak := range []TieBreak{FirstWins, SecondWins} {
+				aPrime, bPrime, err := TransformWithOptions(a, b, &TransformOptions{TieBreak: tieBreak})
+				if errors.Is(err, ErrIndeterminate) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unable to transform patches: %s", err)
+				}
+
+				ab := applySequence(t, doc, []Patch{a, bPrime})
+				ba := applySequence(t, doc, []Patch{b, aPrime})
+
+				if !compareJSON(ab, ba) {
+					ja, _ := json.Marshal(a)
+					jb, _ := json.Marshal(b)
+					t.Fatalf("transforms of %s and %s with tie-break %d diverge:\n%s\n%s", ja, jb, tieBreak, ab, ba)
+				}
+			}
+		}
+	}
+}
+
+// randomPatch builds a patch of n random operations, each made against the
+// document left by the previous ones.
+func randomPatch(t *testing.T, r *rand.Rand, doc string, n int, values ...string) Patch {
+	var p Patch
+
+	for ; n > 0; n-- {
+		op := randomOperation(t, r, doc, values...)
+		p = append(p, op...)
+		doc = applySequence(t, doc, []Patch{op})
+	}
+
+	return p
+}
+
+func TestTransformConvergesMultiOp(t *testing.T) {
+	docs := []string{
+		`{"a": [{"k": 0}, {"k": 1}, 2, 3], "o": {"x": {"y": 1}, "z": [4, 5]}, "s": "t"}`,
+		`{"a": [[1, [2, 3]], {"b": [4, {"c": 5}]}], "d": {"e": [6], "f": {"g": 7}}}`,
+		`[1, [2, 3], {"a": [4]}]`,
+	}
+
+	values := []string{"1", "2", `"x"`, "{}", "[]", `{"k": 3}`, "[4, 5]"}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, doc := range docs {
+		for i := 0; i < 2000; i++ {
+			a := randomPatch(t, r, doc, 1+r.Intn(5), values...)
+			b := randomPatch(t, r, doc, 1+r.Intn(5), values...)
+
+			for _, tieBreak := range []TieBreak{FirstWins, SecondWins} {
+				aPrime, bPrime, err := TransformWithOptions(a, b, &TransformOptions{TieBreak: tieBreak})
+				if errors.Is(err, ErrIndeterminate) {
+					continue
+				}
+
+				ja, _ := json.Marshal(a)
+				jb, _ := json.Marshal(b)
+
+				if err != nil {
+					t.Fatalf("unable to transform %s and %s: %s", ja, jb, err)
+				}
+
+				ab := applySequence(t, doc, []Patch{a, bPrime})
+				ba := applySequence(t, doc, []Patch{b, aPrime})
+
+				if !compareJSON(ab, ba) {
+					t.Fatalf("transforms of %s and %s with tie-break %d diverge:\n%s\n%s", ja, jb, tieBreak, ab, ba)
+				}
+			}
+		}
+	}
+}
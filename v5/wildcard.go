@@ -0,0 +1,220 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/evanphx/json-patch/v5/internal/json"
+)
+
+// isWildcard reports whether a decoded reference token is "*" or a
+// "[key=value]" filter.
+func isWildcard(tok string) bool {
+	if tok == "*" {
+		return true
+	}
+
+	_, _, ok := parseFilter(tok)
+	return ok
+}
+
+// parseFilter splits a "[key=value]" token. A value that is not valid JSON
+// is taken as a string.
+func parseFilter(tok string) (string, *lazyNode, bool) {
+	if len(tok) < 3 || tok[0] != '[' || tok[len(tok)-1] != ']' {
+		return "", nil, false
+	}
+
+	key, value, ok := strings.Cut(tok[1:len(tok)-1], "=")
+	if !ok {
+		return "", nil, false
+	}
+
+	raw := []byte(value)
+	if !json.Valid(raw) {
+		var err error
+		if raw, err = json.Marshal(value); err != nil {
+			return "", nil, false
+		}
+	}
+
+	return key, newLazyNode(newRawMessage(raw)), true
+}
+
+func hasWildcard(path string) bool {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return false
+	}
+
+	for _, tok := range tokens {
+		if isWildcard(tok) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateWildcards rejects wildcards where they cannot be expanded: in
+// "from", and in the "path" of "move", which can only move a value once.
+func validateWildcards(op Operation) error {
+	if from, err := op.From(); err == nil && hasWildcard(from) {
+		return fmt.Errorf("wildcards are not supported in 'from': %w", ErrInvalid)
+	}
+
+	if path, err := op.Path(); err == nil && op.Kind() == "move" && hasWildcard(path) {
+		return fmt.Errorf("wildcards are not supported in the path of move: %w", ErrInvalid)
+	}
+
+	return nil
+}
+
+// expandOperation resolves the wildcards in the path of op against doc, and
+// returns an operation for each matching value.
+func expandOperation(doc *container, op Operation, options *ApplyOptions) ([]Operation, error) {
+	path, err := op.Path()
+	if err != nil || !hasWildcard(path) {
+		return []Operation{op}, nil
+	}
+
+	if err := validateWildcards(op); err != nil {
+		return nil, err
+	}
+
+	paths, err := resolveWildcards(doc, path, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]Operation, len(paths))
+	for i, p := range paths {
+		n := make(Operation, len(op))
+		for k, v := range op {
+			n[k] = v
+		}
+		n["path"] = rawString(p)
+		ops[i] = n
+	}
+
+	return ops, nil
+}
+
+// resolveWildcards returns the concrete paths matched by a path with
+// wildcards. The value the first wildcard applies to must exist, and be an
+// object or an array. Deeper wildcards skip values that do not fit.
+func resolveWildcards(doc *container, path string, options *ApplyOptions) ([]string, error) {
+	tokens, err := splitPointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := [][]string{{}}
+	first := true
+
+	for _, tok := range tokens {
+		if !isWildcard(tok) {
+			for i, m := range matches {
+				matches[i] = appendPointer(m, tok)
+			}
+			continue
+		}
+
+		var next [][]string
+
+		for _, m := range matches {
+			prefix := joinPointer(m)
+
+			node, found := valueAt(doc, prefix, options)
+			if !found {
+				if first {
+					return nil, fmt.Errorf("unable to expand %s: doc is missing path: %s: %w", path, prefix, ErrMissing)
+				}
+				continue
+			}
+
+			keys, children, err := wildcardChildren(node, options)
+			if err != nil {
+				if first {
+					return nil, fmt.Errorf("unable to expand %s: %s is not an object or array: %w", path, prefix, ErrInvalid)
+				}
+				continue
+			}
+
+			for i, child := range children {
+				if tok == "*" || filterMatches(tok, child, options) {
+					next = append(next, appendPointer(m, keys[i]))
+				}
+			}
+		}
+
+		matches = next
+		first = false
+	}
+
+	paths := make([]string, len(matches))
+	for i, m := range matches {
+		paths[i] = joinPointer(m)
+	}
+
+	return paths, nil
+}
+
+// wildcardChildren returns the members of an object or the elements of an
+// array, in document order.
+func wildcardChildren(node *lazyNode, options *ApplyOptions) ([]string, []*lazyNode, error) {
+	switch nodeType(node) {
+	case nodeObject:
+		doc, err := node.intoDoc(options)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var keys []string
+		var children []*lazyNode
+		seen := map[string]bool{}
+
+		for _, k := range doc.keys {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+
+			keys = append(keys, k)
+			children = append(children, doc.obj[k])
+		}
+
+		return keys, children, nil
+	case nodeArray:
+		ary, err := node.intoAry()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys := make([]string, len(ary.nodes))
+		for i := range ary.nodes {
+			keys[i] = strconv.Itoa(i)
+		}
+
+		return keys, ary.nodes, nil
+	}
+
+	return nil, nil, ErrInvalid
+}
+
+func filterMatches(tok string, node *lazyNode, options *ApplyOptions) bool {
+	key, want, _ := parseFilter(tok)
+
+	if nodeType(node) != nodeObject {
+		return false
+	}
+
+	doc, err := node.intoDoc(options)
+	if err != nil {
+		return false
+	}
+
+	v, ok := doc.obj[key]
+	return ok && nodeEqual(v, want)
+}
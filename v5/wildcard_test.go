@@ -0,0 +1,160 @@
+package jsonpatch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func wildcardOptions() *ApplyOptions {
+	options := NewApplyOptions()
+	options.SupportWildcards = true
+	return options
+}
+
+func TestWildcardPaths(t *testing.T) {
+	cases := []struct {
+		doc, patch, result string
+		paths              [][]string
+	}{
+		{
+			`{"items": [{"status": "new"}, {"status": "done"}]}`,
+			`[{"op": "replace", "path": "/items/*/status", "value": "archived"}]`,
+			`{"items": [{"status": "archived"}, {"status": "archived"}]}`,
+			[][]string{{"/items/0/status", "/items/1/status"}},
+		},
+		{
+			`{"users": [{"name": "a", "role": "guest"}, {"name": "b", "role": "admin"}, {"name": "c", "role": "guest"}]}`,
+			`[{"op": "remove", "path": "/users/[role=guest]"}]`,
+			`{"users": [{"name": "b", "role": "admin"}]}`,
+			[][]string{{"/users/0", "/users/2"}},
+		},
+		{
+			`{"users": [{"id": 1}, {"id": "1"}, {"id": 2}]}`,
+			`[{"op": "add", "path": "/users/[id=1]/match", "value": true}, {"op": "add", "path": "/users/[id=\"1\"]/string", "value": true}]`,
+			`{"users": [{"id": 1, "match": true}, {"id": "1", "string": true}, {"id": 2}]}`,
+			[][]string{{"/users/0/match"}, {"/users/1/string"}},
+		},
+		{
+			`{"a": {"x": 1}, "b": {"x": 2}}`,
+			`[{"op": "remove", "path": "/*/x"}]`,
+			`{"a": {}, "b": {}}`,
+			[][]string{{"/a/x", "/b/x"}},
+		},
+		{
+			`{"groups": [{"members": [{"on": true}, {"on": false}]}, {"members": [{"on": true}]}, {"name": "empty"}]}`,
+			`[{"op": "remove", "path": "/groups/*/members/[on=true]"}]`,
+			`{"groups": [{"members": [{"on": false}]}, {"members": []}, {"name": "empty"}]}`,
+			[][]string{{"/groups/0/members/0", "/groups/1/members/0"}},
+		},
+		{
+			`{"list": [1, 2, 3]}`,
+			`[{"op": "add", "path": "/list/*", "value": 0}]`,
+			`{"list": [0, 1, 0, 2, 0, 3]}`,
+			[][]string{{"/list/0", "/list/1", "/list/2"}},
+		},
+		{
+			`{"list": [{"a": 1}], "src": "x"}`,
+			`[{"op": "copy", "from": "/src", "path": "/list/*/copied"}, {"op": "test", "path": "/list/*/a", "value": 1}]`,
+			`{"list": [{"a": 1, "copied": "x"}], "src": "x"}`,
+			[][]string{{"/list/0/copied"}, {"/list/0/a"}},
+		},
+		{
+			`{"users": [{"role": "admin"}]}`,
+			`[{"op": "remove", "path": "/users/[role=guest]"}, {"op": "add", "path": "/count", "value": 1}]`,
+			`{"users": [{"role": "admin"}], "count": 1}`,
+			[][]string{{}, {"/count"}},
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := wildcardOptions()
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			out, results, err := p.ApplyWithResults([]byte(c.doc), options)
+			if err != nil {
+				t.Fatalf("unable to apply patch: %s", err)
+			}
+
+			if !compareJSON(string(out), c.result) {
+				t.Errorf("unexpected result. Expected:\n%s\n\nActual:\n%s",
+					reformatJSON(c.result), reformatJSON(string(out)))
+			}
+
+			if len(results) != len(c.paths) {
+				t.Fatalf("expected %d results, got %d", len(c.paths), len(results))
+			}
+
+			for j, r := range results {
+				if len(r.Paths) == 0 && len(c.paths[j]) == 0 {
+					continue
+				}
+
+				if !reflect.DeepEqual(r.Paths, c.paths[j]) {
+					t.Errorf("operation %d: expected paths %v, got %v", j, c.paths[j], r.Paths)
+				}
+			}
+		})
+	}
+}
+
+func TestWildcardPathErrors(t *testing.T) {
+	cases := []struct {
+		doc, patch string
+		err        error
+	}{
+		{`{}`, `[{"op": "remove", "path": "/users/*"}]`, ErrMissing},
+		{`{"users": 1}`, `[{"op": "remove", "path": "/users/*"}]`, ErrInvalid},
+		{`{"list": [{"a": 1}, {"a": 2}]}`, `[{"op": "test", "path": "/list/*/a", "value": 1}]`, ErrTestFailed},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
+			options := wildcardOptions()
+
+			p, err := DecodePatchWithOptions([]byte(c.patch), options)
+			if err != nil {
+				t.Fatalf("unable to decode patch: %s", err)
+			}
+
+			if _, err := p.ApplyWithOptions([]byte(c.doc), options); !errors.Is(err, c.err) {
+				t.Errorf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+
+	for _, patch := range []string{
+		`[{"op": "copy", "from": "/list/*", "path": "/x"}]`,
+		`[{"op": "move", "from": "/x", "path": "/list/*"}]`,
+	} {
+		if _, err := DecodePatchWithOptions([]byte(patch), wildcardOptions()); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected %s to be rejected, got %v", patch, err)
+		}
+	}
+}
+
+func TestWildcardPathsDisabled(t *testing.T) {
+	p, err := DecodePatch([]byte(`[{"op": "add", "path": "/*", "value": 1}]`))
+	if err != nil {
+		t.Fatalf("unable to decode patch: %s", err)
+	}
+
+	out, results, err := p.ApplyWithResults([]byte(`{"a": 0}`), NewApplyOptions())
+	if err != nil {
+		t.Fatalf("unable to apply patch: %s", err)
+	}
+
+	if !compareJSON(string(out), `{"a": 0, "*": 1}`) {
+		t.Errorf("expected * to be a member name, got %s", out)
+	}
+
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Paths, []string{"/*"}) {
+		t.Errorf("unexpected results %v", results)
+	}
+}